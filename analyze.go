@@ -0,0 +1,135 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// analyzeCommand prints the memory map and subroutines static
+// analysis finds in a rom, and writes its control flow graph
+func analyzeCommand(args []string) {
+	fs := flag.NewFlagSet("analyze", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to analyze")
+	symbolsPath := fs.String("symbols", "", "Symbol file naming addresses in the rom")
+	dotPath := fs.String("dot", "", "Write the control flow graph as Graphviz DOT to this file, - for stdout")
+	calls := fs.Bool("calls", false, "Write only the call graph with -dot")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator analyze [flags] rom")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	romFilePath := fs.Arg(0)
+	a, symbols := analyzeRom(romFilePath, *romEntry, *romDBPath, *symbolsPath)
+
+	write := a.WriteDOT
+	if *calls {
+		write = a.WriteCallGraph
+	}
+	switch *dotPath {
+	case "":
+	case "-":
+		if err := write(os.Stdout, symbols); err != nil {
+			log.Fatal(err)
+		}
+		return
+	default:
+		writeFile(*dotPath, func(w io.Writer) error { return write(w, symbols) })
+	}
+
+	printAnalysis(romFilePath, a, symbols)
+}
+
+func printAnalysis(romFilePath string, a *chip8.Analysis, symbols *chip8.Symbols) {
+	bytes := a.Bytes()
+	fmt.Printf("Rom:         %s, %d bytes\n", romFilePath, len(a.Rom))
+	fmt.Printf("Code:        %d bytes, %d blocks in %d subroutines\n", bytes[chip8.ByteCode], len(a.Blocks), len(a.Subroutines))
+	fmt.Printf("Sprites:     %d bytes, %d sprites\n", bytes[chip8.ByteSprite], len(a.Sprites))
+	fmt.Printf("Data:        %d bytes\n", bytes[chip8.ByteData])
+	if len(a.Unresolved) > 0 {
+		var addrs []string
+		for _, addr := range a.Unresolved {
+			addrs = append(addrs, symbols.Describe(addr))
+		}
+		fmt.Printf("Unresolved:  computed jumps at %s\n", strings.Join(addrs, ", "))
+	}
+
+	fmt.Println()
+	fmt.Println("Memory map:")
+	for _, r := range a.MemoryMap() {
+		fmt.Printf("  %03X-%03X  %-6s  %4d bytes", r.Start, r.End-1, r.Kind, r.End-r.Start)
+		if name := symbols.Name(r.Start); name != "" {
+			fmt.Printf("  %s", name)
+		}
+		fmt.Println()
+	}
+
+	fmt.Println()
+	fmt.Println("Subroutines:")
+	for _, sub := range a.Subroutines {
+		var calls []string
+		for _, to := range sub.Calls {
+			calls = append(calls, chip8.SubroutineName(to, symbols))
+		}
+		fmt.Printf("  %03X  %-16s  %3d blocks, calls %s\n", sub.Entry, chip8.SubroutineName(sub.Entry, symbols), len(sub.Blocks), orDash(strings.Join(calls, ", ")))
+	}
+}
+
+// decompileCommand prints a rom as pseudo-C, one function per subroutine
+func decompileCommand(args []string) {
+	fs := flag.NewFlagSet("decompile", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to decompile")
+	symbolsPath := fs.String("symbols", "", "Symbol file naming subroutines, labels and data")
+	outPath := fs.String("o", "", "Write to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator decompile [flags] rom")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	a, symbols := analyzeRom(fs.Arg(0), *romEntry, *romDBPath, *symbolsPath)
+	if *outPath != "" {
+		writeFile(*outPath, func(w io.Writer) error { return a.Decompile(w, symbols) })
+		return
+	}
+	if err := a.Decompile(os.Stdout, symbols); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// analyzeRom loads and analyzes a rom the way it'd be run,
+// with its symbols if there's a file of them
+func analyzeRom(romFilePath, romEntry, romDBPath, symbolsPath string) (*chip8.Analysis, *chip8.Symbols) {
+	var symbols *chip8.Symbols
+	if symbolsPath != "" {
+		var err error
+		if symbols, err = loadSymbols(symbolsPath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	rom := LoadRomFile(romFilePath, romEntry)
+	settings := resolveRomSettings(rom, romFilePath, loadRomDB(romDBPath), &VMConfig{})
+	return chip8.Analyze(rom, settings.config.Quirks), symbols
+}
@@ -0,0 +1,217 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// benchMode is a way of running roms
+type benchMode struct {
+	name      string
+	recompile bool
+	native    bool
+}
+
+// benchCommand runs a rom headless, as fast as it goes, and reports
+// the speed and allocations of each way of running it, and where
+// the interpreter's time goes by opcode class
+func benchCommand(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to run")
+	ipf := fs.Int("ipf", 1000, "Instructions per frame, timers count down once per frame")
+	frames := fs.Int("frames", 600, "Frames to run")
+	instructions := fs.Int("n", 0, "Instructions to run, rounded up to whole frames, instead of -frames")
+	seed := fs.Int64("seed", 1, "Seed for the random number generator")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator bench [flags] rom")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *ipf < 1 || *frames < 1 || *instructions < 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	romFilePath := fs.Arg(0)
+	if *instructions > 0 {
+		*frames = (*instructions + *ipf - 1) / *ipf
+	}
+
+	rom := LoadRomFile(romFilePath, *romEntry)
+	settings := resolveRomSettings(rom, romFilePath, loadRomDB(*romDBPath), &VMConfig{})
+	config := settings.config
+	config.InstructionsPerFrame = *ipf
+	config.Seed = *seed
+
+	newVM := func(mode benchMode) *chip8.VM {
+		vm := chip8.New(config)
+		if err := vm.LoadROM(rom); err != nil {
+			log.Fatalf("%s: %v", romFilePath, err)
+		}
+		vm.Recompile(mode.recompile)
+		vm.RunNative(mode.native)
+		return vm
+	}
+
+	modes := []benchMode{{name: "Interpreted"}, {name: "Recompiled", recompile: true}}
+	for _, p := range chip8.NativePrograms() {
+		if bytes.Equal(p.Rom, rom) {
+			modes = append(modes, benchMode{name: "Native", native: true})
+		}
+	}
+
+	total := *frames * *ipf
+	fmt.Printf("Rom:          %s, %d frames of %d instructions\n", romFilePath, *frames, *ipf)
+
+	var interpreted time.Duration
+	var vms []*chip8.VM
+	for _, mode := range modes {
+		vm := newVM(mode)
+		elapsed, allocs, faults := runBenchFrames(vm, *frames)
+		vms = append(vms, vm)
+
+		perInstruction := float64(elapsed.Nanoseconds()) / float64(total)
+		fmt.Printf("%-13s %6.1f ns/instruction, %6.1fM instructions/s, %.3f allocs/instruction",
+			mode.name+":", perInstruction, 1e3/perInstruction, float64(allocs)/float64(total))
+		if interpreted == 0 {
+			interpreted = elapsed
+		} else {
+			fmt.Printf(", %.1fx", float64(interpreted)/float64(elapsed))
+		}
+		if faults.count > 0 {
+			fmt.Printf(", %d faults", faults.count)
+		}
+		fmt.Println()
+
+		if faults.count > 0 {
+			log.Warnf("%s: the rom faulted and was started over %d times, first at frame %d: %v",
+				mode.name, faults.count, faults.frame, faults.first)
+		}
+	}
+
+	// every way must end up in the same place
+	a := vms[0]
+	for i, b := range vms[1:] {
+		if !reflect.DeepEqual(a.State(), b.State()) || a.Framebuffer() != b.Framebuffer() ||
+			!bytes.Equal(a.ReadMemory(0, chip8.RAMSize), b.ReadMemory(0, chip8.RAMSize)) {
+			log.Fatalf("%s and %s runs disagree after %d frames", modes[0].name, modes[i+1].name, *frames)
+		}
+	}
+	fmt.Printf("Check:        same state every way after %d instructions\n", total)
+
+	fmt.Println()
+	fmt.Println("Time by opcode class, interpreted:")
+	timer := newClassTimer()
+	vm := newVM(benchMode{})
+	vm.AddTracer(timer)
+	timer.last = time.Now()
+	for i := 0; i < *frames; i++ {
+		runBenchFrame(vm)
+	}
+	timer.print()
+}
+
+// benchFaults counts the frames which stopped on an error
+type benchFaults struct {
+	count int
+	// the first one and the frame it happened in
+	first error
+	frame int
+}
+
+// runBenchFrames runs frames and returns how long they took,
+// how many allocations they made and how many faulted
+func runBenchFrames(vm *chip8.VM, frames int) (time.Duration, uint64, benchFaults) {
+	var faults benchFaults
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	for i := 0; i < frames; i++ {
+		if err := runBenchFrame(vm); err != nil {
+			if faults.count == 0 {
+				faults.first, faults.frame = err, i
+			}
+			faults.count++
+		}
+	}
+
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return elapsed, after.Mallocs - before.Mallocs, faults
+}
+
+// runBenchFrame runs a frame, starting the rom over if it faults,
+// and returns the fault
+func runBenchFrame(vm *chip8.VM) error {
+	err := vm.RunFrame()
+	if err != nil {
+		vm.HardReset()
+	}
+	return err
+}
+
+// classTimer charges the time since the previous step to the opcode
+// class of each step, which includes the vm's own overhead per step
+// and tracing's, so it's a split of the time rather than a measure
+type classTimer struct {
+	last    time.Time
+	classes map[uint16]string
+	time    map[string]time.Duration
+	count   map[string]int
+}
+
+func newClassTimer() *classTimer {
+	return &classTimer{
+		classes: map[uint16]string{},
+		time:    map[string]time.Duration{},
+		count:   map[string]int{},
+	}
+}
+
+// TraceStep implements chip8.Tracer
+func (t *classTimer) TraceStep(ev *chip8.StepEvent) {
+	now := time.Now()
+
+	class, ok := t.classes[ev.Opcode]
+	if !ok {
+		class = chip8.Decode(ev.Opcode).Mnemonic
+		t.classes[ev.Opcode] = class
+	}
+	t.time[class] += now.Sub(t.last)
+	t.count[class]++
+
+	// leave the bookkeeping out of the next step's time
+	t.last = time.Now()
+}
+
+func (t *classTimer) print() {
+	var classes []string
+	var total time.Duration
+	for class, d := range t.time {
+		classes = append(classes, class)
+		total += d
+	}
+	sort.Slice(classes, func(i, j int) bool { return t.time[classes[i]] > t.time[classes[j]] })
+
+	for _, class := range classes {
+		d, n := t.time[class], t.count[class]
+		fmt.Printf("  %-5s %5.1f%%  %9d instructions  %7.1f ns each\n",
+			class, 100*float64(d)/float64(total), n, float64(d.Nanoseconds())/float64(n))
+	}
+}
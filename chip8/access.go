@@ -0,0 +1,288 @@
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Every ram access an instruction makes goes through readRAM,
+// writeRAM or fetch (for the instruction itself), which is where
+// watchpoints, access counters and tracers hook in.
+
+// AccessKind is a kind of memory access, or a set of them
+type AccessKind uint8
+
+// Memory access kinds
+const (
+	AccessRead AccessKind = 1 << iota
+	AccessWrite
+	AccessExec
+)
+
+func (k AccessKind) String() string {
+	var sb strings.Builder
+	for _, c := range []struct {
+		kind AccessKind
+		name byte
+	}{{AccessRead, 'r'}, {AccessWrite, 'w'}, {AccessExec, 'x'}} {
+		if k&c.kind != 0 {
+			sb.WriteByte(c.name)
+		}
+	}
+	return sb.String()
+}
+
+// ParseAccessKind reads a set of kinds written as in String, e.g. "rw"
+func ParseAccessKind(s string) (AccessKind, error) {
+	var k AccessKind
+	for _, c := range strings.ToLower(s) {
+		switch c {
+		case 'r':
+			k |= AccessRead
+		case 'w':
+			k |= AccessWrite
+		case 'x':
+			k |= AccessExec
+		default:
+			return 0, fmt.Errorf("unknown access kind %q, want r, w or x", c)
+		}
+	}
+	if k == 0 {
+		return 0, fmt.Errorf("no access kind given")
+	}
+	return k, nil
+}
+
+// Watchpoint stops the vm when the program accesses
+// any of the Len bytes from Addr in one of the Kind ways
+type Watchpoint struct {
+	Addr uint16
+	Len  uint16
+	Kind AccessKind
+}
+
+func (w Watchpoint) covers(addr uint16, kind AccessKind) bool {
+	return w.Kind&kind != 0 && addr >= w.Addr && addr-w.Addr < w.Len
+}
+
+func (w Watchpoint) String() string {
+	if w.Len <= 1 {
+		return fmt.Sprintf("%s:%03X", w.Kind, w.Addr)
+	}
+	return fmt.Sprintf("%s:%03X-%03X", w.Kind, w.Addr, w.Addr+w.Len-1)
+}
+
+// ParseWatchpoint reads a watchpoint written as in String:
+// the access kinds, a colon and an address or range,
+// e.g. "w:3A0" or "rx:0x300-0x30F" (addresses are hex)
+func ParseWatchpoint(s string) (Watchpoint, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return Watchpoint{}, fmt.Errorf("watchpoint %q wants kinds:address, e.g. w:0x3A0", s)
+	}
+
+	kind, err := ParseAccessKind(parts[0])
+	if err != nil {
+		return Watchpoint{}, fmt.Errorf("watchpoint %q: %v", s, err)
+	}
+
+	bounds := strings.SplitN(parts[1], "-", 2)
+	var addrs [2]uint64
+	for i, b := range bounds {
+		b = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(b), "0x"), "0X")
+		addrs[i], err = strconv.ParseUint(b, 16, 16)
+		if err != nil || addrs[i] > RAMEndAddr {
+			return Watchpoint{}, fmt.Errorf("watchpoint %q: bad address %q", s, bounds[i])
+		}
+	}
+	if len(bounds) == 1 {
+		addrs[1] = addrs[0]
+	}
+	if addrs[1] < addrs[0] {
+		return Watchpoint{}, fmt.Errorf("watchpoint %q: range is backwards", s)
+	}
+
+	return Watchpoint{Addr: uint16(addrs[0]), Len: uint16(addrs[1]-addrs[0]) + 1, Kind: kind}, nil
+}
+
+// WatchpointHit is the error Step and RunFrame stop with when a
+// watchpoint triggers. Reads and writes stop after the instruction
+// ran, execution before it does; resuming carries on from there.
+type WatchpointHit struct {
+	Watchpoint Watchpoint
+
+	Kind  AccessKind
+	Addr  uint16
+	Value byte
+
+	// PC of the instruction making the access
+	PC uint16
+}
+
+func (h *WatchpointHit) Error() string {
+	return fmt.Sprintf("watchpoint %s: %s of %03X (%02X) by the instruction at %03X", h.Watchpoint, h.Kind, h.Addr, h.Value, h.PC)
+}
+
+// MemoryStats counts the accesses to one address, see TrackMemory
+type MemoryStats struct {
+	Reads, Writes, Execs uint64
+
+	// LastWriter is the PC of the instruction which
+	// last wrote the byte, if Written
+	LastWriter uint16
+	Written    bool
+}
+
+// memoryTracking holds MemoryStats for all of ram
+type memoryTracking struct {
+	stats [RAMSize]MemoryStats
+}
+
+// TrackMemory turns per address access counting and write
+// provenance on or off, it's off by default to save the work.
+// Turning it on starts the counts over.
+func (vm *VM) TrackMemory(on bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if on {
+		vm.tracking = &memoryTracking{}
+	} else {
+		vm.tracking = nil
+	}
+}
+
+// MemoryStats returns the access counts for addr,
+// all zero unless TrackMemory is on
+func (vm *VM) MemoryStats(addr uint16) MemoryStats {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.tracking == nil {
+		return MemoryStats{}
+	}
+	return vm.tracking.stats[addr&RAMEndAddr]
+}
+
+// AddWatchpoint starts watching w
+func (vm *VM) AddWatchpoint(w Watchpoint) {
+	if w.Len == 0 {
+		w.Len = 1
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.watchpoints = append(vm.watchpoints, w)
+}
+
+// RemoveWatchpoint stops watching w, reporting whether it was set
+func (vm *VM) RemoveWatchpoint(w Watchpoint) bool {
+	if w.Len == 0 {
+		w.Len = 1
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	for i, wp := range vm.watchpoints {
+		if wp == w {
+			vm.watchpoints = append(vm.watchpoints[:i], vm.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Watchpoints returns the watchpoints set
+func (vm *VM) Watchpoints() []Watchpoint {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return append([]Watchpoint(nil), vm.watchpoints...)
+}
+
+// watch records the first watchpoint addr trips this instruction
+func (vm *VM) watch(addr uint16, kind AccessKind, value byte) {
+	if vm.watchHit != nil {
+		return
+	}
+
+	for _, w := range vm.watchpoints {
+		if w.covers(addr, kind) {
+			vm.watchHit = &WatchpointHit{
+				Watchpoint: w,
+				Kind:       kind,
+				Addr:       addr,
+				Value:      value,
+				PC:         vm.cpu.programCounter}
+			return
+		}
+	}
+}
+
+// readRAM is how instructions load from ram
+func (vm *VM) readRAM(addr uint16) byte {
+	addr &= RAMEndAddr
+	value := vm.memory.ram[addr]
+
+	if vm.tracking != nil {
+		vm.tracking.stats[addr].Reads++
+	}
+	if len(vm.watchpoints) > 0 {
+		vm.watch(addr, AccessRead, value)
+	}
+
+	return value
+}
+
+// writeRAM is how instructions store to ram
+func (vm *VM) writeRAM(addr uint16, value byte) {
+	addr &= RAMEndAddr
+	vm.memory.ram[addr] = value
+	vm.invalidateCode(addr)
+
+	if vm.tracking != nil {
+		s := &vm.tracking.stats[addr]
+		s.Writes++
+		s.LastWriter = vm.cpu.programCounter
+		s.Written = true
+	}
+	if len(vm.watchpoints) > 0 {
+		vm.watch(addr, AccessWrite, value)
+	}
+	if len(vm.tracers) > 0 {
+		vm.event.Writes = append(vm.event.Writes, MemoryWrite{Addr: addr, Value: value})
+	}
+}
+
+// fetch accounts for executing the instruction at pc, returning
+// the breakpoint or watchpoint hit if it mustn't run yet
+func (vm *VM) fetch(pc uint16, opcode uint16) error {
+	if len(vm.breakpoints) > 0 && vm.resumeExecAt != int(pc) && vm.breakpoints[pc] {
+		vm.resumeExecAt = int(pc)
+		return &BreakpointHit{PC: pc}
+	}
+
+	if len(vm.watchpoints) > 0 && vm.resumeExecAt != int(pc) {
+		vm.watchHit = nil
+		vm.watch(pc, AccessExec, byte(opcode>>8))
+		vm.watch(pc+1, AccessExec, byte(opcode))
+
+		if hit := vm.watchHit; hit != nil {
+			vm.watchHit = nil
+			vm.resumeExecAt = int(pc)
+			return hit
+		}
+	}
+
+	// stopped here before, this time it runs
+	vm.resumeExecAt = -1
+
+	if vm.tracking != nil {
+		vm.tracking.stats[pc].Execs++
+		vm.tracking.stats[pc+1].Execs++
+	}
+	return nil
+}
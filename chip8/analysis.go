@@ -0,0 +1,558 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Static analysis. Analyze follows the program from ProgramAreaStart
+// the way the vm could run it, through jumps, calls and both ways of
+// every skip, and works out from that which bytes are code, which
+// are sprites (what I points at when drawing) and which are data.
+//
+// It's a guess where CHIP-8 gives nothing to go on: Bnnn targets are
+// only found when V0 was just loaded with a constant or nnn holds a
+// table of jumps, and I is only followed along the path the analysis
+// happened to take first.
+
+// ByteKind is what analysis takes a rom byte for
+type ByteKind byte
+
+const (
+	// ByteData is anything not found to be code or a sprite,
+	// including code analysis couldn't find a way into
+	ByteData ByteKind = iota
+	ByteCode
+	ByteSprite
+)
+
+func (k ByteKind) String() string {
+	switch k {
+	case ByteCode:
+		return "code"
+	case ByteSprite:
+		return "sprite"
+	}
+	return "data"
+}
+
+// EdgeKind is how control gets from one block to another
+type EdgeKind byte
+
+const (
+	// EdgeFall runs on into the next instruction,
+	// for a CALL that's once the subroutine returns
+	EdgeFall EdgeKind = iota
+	EdgeJump
+	// EdgeSkip is a skip instruction skipping
+	EdgeSkip
+	EdgeCall
+	// EdgeComputed is a Bnnn jump to one of its possible targets
+	EdgeComputed
+)
+
+func (k EdgeKind) String() string {
+	switch k {
+	case EdgeJump:
+		return "jump"
+	case EdgeSkip:
+		return "skip"
+	case EdgeCall:
+		return "call"
+	case EdgeComputed:
+		return "computed"
+	}
+	return "fall"
+}
+
+// Edge leads to the block starting at To
+type Edge struct {
+	To   uint16
+	Kind EdgeKind
+}
+
+// Block is a basic block: instructions from Start up to End which,
+// once the first runs, all run. Blocks end at jumps, calls, skips,
+// returns, and where another block gets jumped into.
+type Block struct {
+	Start, End uint16
+	Succs      []Edge
+}
+
+// Instructions returns the addresses of the block's instructions
+func (b *Block) Instructions() []uint16 {
+	var addrs []uint16
+	for addr := b.Start; addr < b.End; addr += 2 {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// Subroutine is the code reached from an entry point without
+// following calls: the rom's entry point or a CALL target
+type Subroutine struct {
+	Entry uint16
+	// Blocks in address order, the first is the entry block
+	Blocks []*Block
+	// Calls are the entry points of the subroutines it calls
+	Calls []uint16
+}
+
+// SpriteRef is memory drawn as a sprite, found from an Annn
+// followed by a Dxyn with no other change to I in between
+type SpriteRef struct {
+	Addr   uint16
+	Height int
+	// DrawnAt are the addresses of the DRW instructions
+	DrawnAt []uint16
+}
+
+// Analysis is what static analysis makes of a rom, see Analyze
+type Analysis struct {
+	Rom    []byte
+	Quirks Quirks
+
+	// Kinds classifies each rom byte, Kinds[i] being the
+	// one at ProgramAreaStart+i
+	Kinds []ByteKind
+
+	// Blocks in address order
+	Blocks []*Block
+
+	// Subroutines in address order
+	Subroutines []*Subroutine
+
+	// Sprites in address order, they can lie outside the rom
+	// when a program draws the font or sprites it built itself
+	Sprites []SpriteRef
+
+	// Unresolved are the Bnnn jumps no target was found for
+	Unresolved []uint16
+
+	blocks map[uint16]*Block
+}
+
+// Region is a run of bytes of one kind, from Start up to End
+type Region struct {
+	Start, End uint16
+	Kind       ByteKind
+}
+
+// most entries a Bnnn jump table is taken to have, V0 being a byte
+const maxJumpTable = 128
+
+// pathState is what analysis knows about the registers
+// on the way to an instruction: I and the last V loaded
+type pathState struct {
+	i      int // -1 when not known
+	lastLd int // opcode of the previous instruction if it was 6xkk, else -1
+}
+
+var unknownPath = pathState{i: -1, lastLd: -1}
+
+// Analyze works out the structure of rom, loaded at ProgramAreaStart
+// and run with quirks (which decide the register Bnnn jumps by)
+func Analyze(rom []byte, quirks Quirks) *Analysis {
+	if max := RAMSize - ProgramAreaStart; len(rom) > max {
+		rom = rom[:max]
+	}
+
+	a := &Analysis{
+		Rom:    rom,
+		Quirks: quirks,
+		Kinds:  make([]ByteKind, len(rom)),
+		blocks: map[uint16]*Block{},
+	}
+	end := ProgramAreaStart + len(rom)
+	inRom := func(addr int) bool { return addr >= ProgramAreaStart && addr+1 < end }
+	opcodeAt := func(addr int) uint16 {
+		return uint16(rom[addr-ProgramAreaStart])<<8 | uint16(rom[addr-ProgramAreaStart+1])
+	}
+
+	insns := map[uint16]bool{}
+	leaders := map[uint16]bool{ProgramAreaStart: true}
+	succs := map[uint16][]Edge{}
+	sprites := map[uint16]*SpriteRef{}
+	unresolved := map[uint16]bool{}
+	// instructions ending a block: jumps, calls, skips and returns
+	ends := map[uint16]bool{}
+
+	type work struct {
+		addr  int
+		state pathState
+	}
+	todo := []work{{ProgramAreaStart, unknownPath}}
+	branch := func(from uint16, to int, kind EdgeKind, state pathState) {
+		succs[from] = append(succs[from], Edge{To: uint16(to), Kind: kind})
+		leaders[uint16(to)] = true
+		todo = append(todo, work{to, state})
+	}
+
+	for len(todo) > 0 {
+		w := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+
+		for addr, state := w.addr, w.state; inRom(addr); addr += 2 {
+			pc := uint16(addr)
+			if insns[pc] {
+				// running into code walked before, which has to start a block
+				leaders[pc] = true
+				break
+			}
+
+			opcode := opcodeAt(addr)
+			in := Decode(opcode)
+			// zeroed memory isn't taken for a run of NOPs
+			if !in.Valid() || opcode == 0x0000 {
+				break
+			}
+			insns[pc] = true
+
+			x := int(opcode>>8) & 0xF
+			n := int(opcode & 0xF)
+			nnn := int(opcode & 0xFFF)
+			next := state
+			next.lastLd = -1
+
+			switch {
+			case opcode == 0x00EE:
+				addr = end // stop here
+			case opcode>>12 == 0x1:
+				branch(pc, nnn, EdgeJump, state)
+				addr = end
+			case opcode>>12 == 0x2:
+				branch(pc, nnn, EdgeCall, unknownPath)
+				// nothing is known of I once the subroutine returns
+				branch(pc, addr+2, EdgeFall, unknownPath)
+				addr = end
+			case in.IsSkip():
+				branch(pc, addr+2, EdgeFall, next)
+				branch(pc, addr+4, EdgeSkip, next)
+				addr = end
+			case opcode>>12 == 0xB:
+				reg := 0
+				if quirks.JumpUsesVX {
+					reg = x
+				}
+				targets := computedTargets(opcodeAt, inRom, nnn, reg, state.lastLd)
+				if len(targets) == 0 {
+					unresolved[pc] = true
+				}
+				for _, to := range targets {
+					branch(pc, to, EdgeComputed, unknownPath)
+				}
+				addr = end
+			case opcode>>12 == 0x6:
+				next.lastLd = int(opcode)
+			case opcode>>12 == 0xA:
+				next.i = nnn
+			case opcode>>12 == 0xD:
+				if state.i >= 0 && n > 0 {
+					ref := sprites[uint16(state.i)]
+					if ref == nil {
+						ref = &SpriteRef{Addr: uint16(state.i)}
+						sprites[ref.Addr] = ref
+					}
+					if n > ref.Height {
+						ref.Height = n
+					}
+					ref.DrawnAt = append(ref.DrawnAt, pc)
+				}
+			case opcode&0xF0FF == 0xF055, opcode&0xF0FF == 0xF065:
+				if quirks.LoadStoreIncrementsI && state.i >= 0 {
+					next.i = state.i + x + 1
+				}
+			case opcode&0xF0FF == 0xF01E, opcode&0xF0FF == 0xF029:
+				next.i = -1
+			}
+			if addr == end {
+				ends[pc] = true
+			}
+			state = next
+		}
+	}
+
+	// classify, code wins over sprites, sprites over data
+	for addr := range insns {
+		a.Kinds[int(addr)-ProgramAreaStart] = ByteCode
+		a.Kinds[int(addr)+1-ProgramAreaStart] = ByteCode
+	}
+	for _, ref := range sprites {
+		for addr := int(ref.Addr); addr < int(ref.Addr)+ref.Height; addr++ {
+			if addr >= ProgramAreaStart && addr < end && a.Kinds[addr-ProgramAreaStart] == ByteData {
+				a.Kinds[addr-ProgramAreaStart] = ByteSprite
+			}
+		}
+		sort.Slice(ref.DrawnAt, func(i, j int) bool { return ref.DrawnAt[i] < ref.DrawnAt[j] })
+		a.Sprites = append(a.Sprites, *ref)
+	}
+	sort.Slice(a.Sprites, func(i, j int) bool { return a.Sprites[i].Addr < a.Sprites[j].Addr })
+
+	for pc := range unresolved {
+		a.Unresolved = append(a.Unresolved, pc)
+	}
+	sortAddrs(a.Unresolved)
+
+	a.buildBlocks(insns, leaders, ends, succs)
+	a.buildSubroutines()
+	return a
+}
+
+// computedTargets guesses where a Bnnn goes: nnn plus the constant
+// the register was just loaded with, or else every entry of the
+// table of jumps at nnn
+func computedTargets(opcodeAt func(int) uint16, inRom func(int) bool, nnn, reg, lastLd int) []int {
+	if lastLd >= 0 && (lastLd>>8)&0xF == reg {
+		return []int{nnn + lastLd&0xFF}
+	}
+
+	var targets []int
+	for addr := nnn; len(targets) < maxJumpTable && inRom(addr) && opcodeAt(addr)>>12 == 0x1; addr += 2 {
+		targets = append(targets, addr)
+	}
+	return targets
+}
+
+// buildBlocks cuts the instructions found into blocks at the leaders
+func (a *Analysis) buildBlocks(insns, leaders, ends map[uint16]bool, succs map[uint16][]Edge) {
+	var starts []uint16
+	for addr := range leaders {
+		if insns[addr] {
+			starts = append(starts, addr)
+		}
+	}
+	sortAddrs(starts)
+
+	for _, start := range starts {
+		b := &Block{Start: start}
+		for addr := start; ; addr += 2 {
+			b.End = addr + 2
+			if ends[addr] {
+				for _, e := range succs[addr] {
+					// edges to where no code was found (out of the rom,
+					// invalid opcodes) lead nowhere
+					if insns[e.To] {
+						b.Succs = append(b.Succs, e)
+					}
+				}
+				break
+			}
+			if !insns[addr+2] {
+				break
+			}
+			if leaders[addr+2] {
+				b.Succs = append(b.Succs, Edge{To: addr + 2, Kind: EdgeFall})
+				break
+			}
+		}
+		a.Blocks = append(a.Blocks, b)
+		a.blocks[start] = b
+	}
+}
+
+// buildSubroutines groups the blocks reachable from each entry point
+func (a *Analysis) buildSubroutines() {
+	entries := map[uint16]bool{ProgramAreaStart: true}
+	for _, b := range a.Blocks {
+		for _, e := range b.Succs {
+			if e.Kind == EdgeCall {
+				entries[e.To] = true
+			}
+		}
+	}
+
+	for entry := range entries {
+		first := a.blocks[entry]
+		if first == nil {
+			continue
+		}
+
+		sub := &Subroutine{Entry: entry}
+		seen := map[uint16]bool{entry: true}
+		calls := map[uint16]bool{}
+		todo := []*Block{first}
+		for len(todo) > 0 {
+			b := todo[len(todo)-1]
+			todo = todo[:len(todo)-1]
+			sub.Blocks = append(sub.Blocks, b)
+
+			for _, e := range b.Succs {
+				if e.Kind == EdgeCall {
+					calls[e.To] = true
+				} else if !seen[e.To] {
+					seen[e.To] = true
+					todo = append(todo, a.blocks[e.To])
+				}
+			}
+		}
+
+		sort.Slice(sub.Blocks[1:], func(i, j int) bool { return sub.Blocks[i+1].Start < sub.Blocks[j+1].Start })
+		for to := range calls {
+			sub.Calls = append(sub.Calls, to)
+		}
+		sortAddrs(sub.Calls)
+		a.Subroutines = append(a.Subroutines, sub)
+	}
+	sort.Slice(a.Subroutines, func(i, j int) bool { return a.Subroutines[i].Entry < a.Subroutines[j].Entry })
+}
+
+// Block returns the block starting at addr, or nil
+func (a *Analysis) Block(addr uint16) *Block {
+	return a.blocks[addr]
+}
+
+// Kind returns what the byte at addr is taken for,
+// anything outside the rom being data
+func (a *Analysis) Kind(addr uint16) ByteKind {
+	i := int(addr) - ProgramAreaStart
+	if i < 0 || i >= len(a.Kinds) {
+		return ByteData
+	}
+	return a.Kinds[i]
+}
+
+// Opcode returns the instruction at addr in the rom
+func (a *Analysis) Opcode(addr uint16) uint16 {
+	i := int(addr) - ProgramAreaStart
+	if i < 0 || i+1 >= len(a.Rom) {
+		return 0
+	}
+	return uint16(a.Rom[i])<<8 | uint16(a.Rom[i+1])
+}
+
+// SpriteBytes returns the rows of a sprite as the font and rom have
+// them, false when it lies in memory the program fills in itself
+func (a *Analysis) SpriteBytes(ref SpriteRef) ([]byte, bool) {
+	start, end := int(ref.Addr), int(ref.Addr)+ref.Height
+	switch {
+	case end <= len(chip8Fontset):
+		return append([]byte(nil), chip8Fontset[start:end]...), true
+	case start >= ProgramAreaStart && end <= ProgramAreaStart+len(a.Rom):
+		return append([]byte(nil), a.Rom[start-ProgramAreaStart:end-ProgramAreaStart]...), true
+	}
+	return nil, false
+}
+
+// MemoryMap returns the rom as runs of code, sprites and data
+func (a *Analysis) MemoryMap() []Region {
+	var regions []Region
+	for i, kind := range a.Kinds {
+		addr := uint16(ProgramAreaStart + i)
+		if n := len(regions); n > 0 && regions[n-1].Kind == kind {
+			regions[n-1].End = addr + 1
+			continue
+		}
+		regions = append(regions, Region{Start: addr, End: addr + 1, Kind: kind})
+	}
+	return regions
+}
+
+// Bytes counts the rom bytes of each kind
+func (a *Analysis) Bytes() map[ByteKind]int {
+	counts := map[ByteKind]int{}
+	for _, kind := range a.Kinds {
+		counts[kind]++
+	}
+	return counts
+}
+
+// SubroutineName is the name of the subroutine at entry: its label
+// if symbols has one, otherwise "start" or sub_2A0 as in profiles
+func SubroutineName(entry uint16, symbols *Symbols) string {
+	if label := symbols.Label(entry); label != "" {
+		return label
+	}
+	return subroutineName(entry)
+}
+
+// WriteDOT writes the control flow graph as Graphviz DOT, one
+// cluster of blocks per subroutine, labelled with symbols if given
+func (a *Analysis) WriteDOT(w io.Writer, symbols *Symbols) error {
+	var b strings.Builder
+
+	b.WriteString("digraph cfg {\n")
+	b.WriteString("\tnode [shape=box fontname=monospace];\n")
+
+	// blocks shared by several subroutines are drawn in the first
+	drawn := map[uint16]bool{}
+	for _, sub := range a.Subroutines {
+		fmt.Fprintf(&b, "\tsubgraph cluster_%03X {\n", sub.Entry)
+		fmt.Fprintf(&b, "\t\tlabel=\"%s\";\n", dotEscape(SubroutineName(sub.Entry, symbols)))
+		for _, block := range sub.Blocks {
+			if drawn[block.Start] {
+				continue
+			}
+			drawn[block.Start] = true
+			fmt.Fprintf(&b, "\t\tb%03X [label=\"%s\"];\n", block.Start, a.blockLabel(block, symbols))
+		}
+		b.WriteString("\t}\n")
+	}
+
+	for _, block := range a.Blocks {
+		for _, e := range block.Succs {
+			fmt.Fprintf(&b, "\tb%03X -> b%03X", block.Start, e.To)
+			switch e.Kind {
+			case EdgeSkip:
+				b.WriteString(" [label=skip color=darkgreen]")
+			case EdgeCall:
+				b.WriteString(" [style=dashed color=blue]")
+			case EdgeComputed:
+				b.WriteString(" [style=dotted color=red]")
+			}
+			b.WriteString(";\n")
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteCallGraph writes only which subroutine calls which as DOT
+func (a *Analysis) WriteCallGraph(w io.Writer, symbols *Symbols) error {
+	var b strings.Builder
+
+	b.WriteString("digraph calls {\n")
+	b.WriteString("\tnode [shape=box fontname=monospace];\n")
+	for _, sub := range a.Subroutines {
+		fmt.Fprintf(&b, "\ts%03X [label=\"%s\\n%03X, %d blocks\"];\n",
+			sub.Entry, dotEscape(SubroutineName(sub.Entry, symbols)), sub.Entry, len(sub.Blocks))
+	}
+	for _, sub := range a.Subroutines {
+		for _, to := range sub.Calls {
+			if a.blocks[to] != nil {
+				fmt.Fprintf(&b, "\ts%03X -> s%03X;\n", sub.Entry, to)
+			}
+		}
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// blockLabel is the block's disassembly, lines left aligned
+func (a *Analysis) blockLabel(block *Block, symbols *Symbols) string {
+	var b strings.Builder
+	if label := symbols.Label(block.Start); label != "" {
+		fmt.Fprintf(&b, "%s:\\l", dotEscape(label))
+	}
+	for _, addr := range block.Instructions() {
+		fmt.Fprintf(&b, "%03X  %s\\l", addr, Disassemble(a.Opcode(addr)))
+	}
+	return b.String()
+}
+
+// dotEscape quotes s for a double quoted DOT string,
+// symbol names can hold anything
+func dotEscape(s string) string {
+	return dotEscaper.Replace(s)
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func sortAddrs(addrs []uint16) {
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+}
@@ -0,0 +1,53 @@
+package chip8
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// dotQuotesBalance checks every double quoted string in a DOT
+// file ends on its own line, the way ours are written
+func dotQuotesBalance(dot string) bool {
+	for _, line := range strings.Split(dot, "\n") {
+		quoted := false
+		for i := 0; i < len(line); i++ {
+			switch {
+			case quoted && line[i] == '\\':
+				i++
+			case line[i] == '"':
+				quoted = !quoted
+			}
+		}
+		if quoted {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWriteDOTEscapesSymbols(t *testing.T) {
+	// 0x200: CALL 0x204; JP 0x202; 0x204: RET
+	a := Analyze([]byte{0x22, 0x04, 0x12, 0x02, 0x00, 0xEE}, Quirks{})
+
+	symbols := NewSymbols()
+	symbols.Add(`main "loop"`, 0x200)
+	symbols.Add(`C:\draw`, 0x204)
+
+	for name, write := range map[string]func(*bytes.Buffer) error{
+		"cfg":   func(b *bytes.Buffer) error { return a.WriteDOT(b, symbols) },
+		"calls": func(b *bytes.Buffer) error { return a.WriteCallGraph(b, symbols) },
+	} {
+		var b bytes.Buffer
+		if err := write(&b); err != nil {
+			t.Fatal(err)
+		}
+		dot := b.String()
+		if !dotQuotesBalance(dot) {
+			t.Errorf("%s: unbalanced quotes in\n%s", name, dot)
+		}
+		if !strings.Contains(dot, `main \"loop\"`) || !strings.Contains(dot, `C:\\draw`) {
+			t.Errorf("%s: symbols not escaped in\n%s", name, dot)
+		}
+	}
+}
@@ -0,0 +1,589 @@
+// Package chip8 is the emulator core: cpu, memory, display and
+// keypad state plus the instruction set. It knows nothing about
+// windows or timing; frontends drive it through Step/RunFrame
+// and poll Framebuffer to show what's drawn.
+package chip8
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultInstructionsPerFrame roughly matches the 5ms per
+// instruction (200 Hz) the desktop frontend has always ticked at
+const DefaultInstructionsPerFrame = 3
+
+// VM contains the whole state of emulator.
+// All exported methods are safe to call from multiple go routines.
+type VM struct {
+	// guards the vm state between the cpu loop and
+	// anyone peeking at it (e.g. the web frontend)
+	mu sync.Mutex
+
+	config Config
+
+	cpu    *CPU
+	screen *Screen
+	memory *Memory
+	keypad *Keypad
+	rng    rng
+
+	// rom currently loaded, kept around for Reset
+	rom []byte
+
+	// speed controls, see clock.go
+	paused      bool
+	fastForward bool
+	speedLabel  speedLabel
+
+	// set while Fx0A is waiting on a key press
+	waitingForKey  bool
+	keyWaitPresses uint64
+
+	// instructions and frames executed so far
+	steps  uint64
+	frames uint64
+
+	// see tracer.go, event is reused between steps
+	tracers []Tracer
+	event   StepEvent
+
+	// see access.go
+	watchpoints  []Watchpoint
+	watchHit     *WatchpointHit
+	resumeExecAt int
+	tracking     *memoryTracking
+
+	// see debug.go
+	breakpoints map[uint16]bool
+
+	// see history.go, nil unless recording
+	history *history
+
+	// see symbols.go
+	symbols *Symbols
+
+	// see recompile.go, nil unless recompiling
+	recompiler *recompiler
+
+	// see native.go, nil unless the rom was compiled ahead of time
+	native    *nativeState
+	nativeOff bool
+}
+
+// Config ...
+type Config struct {
+	// InstructionsPerFrame is the number of instructions
+	// executed by RunFrame, defaults to DefaultInstructionsPerFrame
+	InstructionsPerFrame int
+
+	// Platform the rom targets, informational only,
+	// see PlatformQuirks for picking Quirks off of it
+	Platform string
+
+	// Quirks the interpreter runs with
+	Quirks Quirks
+
+	// Seed for the Cxkk random numbers, vms sharing a seed
+	// draw the same numbers. 0 seeds off the clock.
+	Seed int64
+}
+
+// State is a snapshot of the cpu, as handed out to debuggers and frontends
+type State struct {
+	V     [16]byte `json:"v"`
+	I     uint16   `json:"i"`
+	PC    uint16   `json:"pc"`
+	SP    byte     `json:"sp"`
+	DT    byte     `json:"dt"`
+	ST    byte     `json:"st"`
+	Stack []uint16 `json:"stack"`
+}
+
+// ErrRomTooLarge is returned by LoadROM for roms which don't fit the program area
+var ErrRomTooLarge = errors.New("rom does not fit in the program area")
+
+// ErrRomEmpty is returned by LoadROM for zero length roms
+var ErrRomEmpty = errors.New("rom is empty")
+
+// ErrStackUnderflow is returned for a RET with nothing on the stack
+var ErrStackUnderflow = errors.New("stack underflow")
+
+// ErrStackOverflow is returned for a CALL with the stack full
+var ErrStackOverflow = errors.New("stack overflow")
+
+// New returns a vm with an empty program area, ready for LoadROM
+func New(config Config) *VM {
+
+	if config.InstructionsPerFrame <= 0 {
+		config.InstructionsPerFrame = DefaultInstructionsPerFrame
+	}
+
+	vm := new(VM)
+	vm.config = config
+	vm.cpu = newCPU()
+	vm.screen = newScreen()
+	vm.memory = newMemory()
+	vm.keypad = newKeypad()
+	vm.rng = newRNG(config.Seed)
+	vm.resumeExecAt = -1
+
+	return vm
+}
+
+// SetConfig swaps the speed, platform and quirks the vm runs with,
+// e.g. before loading a rom which wants different ones.
+// The random number generator keeps its current seed.
+func (vm *VM) SetConfig(config Config) {
+	if config.InstructionsPerFrame <= 0 {
+		config.InstructionsPerFrame = DefaultInstructionsPerFrame
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.config = config
+	vm.flushBlocks()
+	vm.restartHistory()
+}
+
+// LoadROM starts the vm over with rom
+// in place of whatever it was running
+func (vm *VM) LoadROM(rom []byte) error {
+	if len(rom) == 0 {
+		return ErrRomEmpty
+	}
+	if len(rom) > ProgramAreaEnd-ProgramAreaStart+1 {
+		return fmt.Errorf("%w: %d bytes, at most %d fit", ErrRomTooLarge, len(rom), ProgramAreaEnd-ProgramAreaStart+1)
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.rom = append([]byte(nil), rom...)
+	vm.memory = newMemory()
+	vm.lookupNative(vm.rom)
+	vm.reset()
+	vm.restartHistory()
+
+	return nil
+}
+
+// Reset restarts the current rom, the way a reset button would:
+// cpu and display start over, the rest of ram is left as is.
+func (vm *VM) Reset() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.reset()
+	vm.restartHistory()
+}
+
+// HardReset is a power cycle: on top of what Reset does ram is
+// wiped, the keypad released and the random numbers re-seeded
+// (replaying the same sequence again when the config has a seed)
+func (vm *VM) HardReset() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.memory = newMemory()
+	vm.keypad = newKeypad()
+	vm.rng = newRNG(vm.config.Seed)
+	vm.reset()
+	vm.restartHistory()
+}
+
+func (vm *VM) reset() {
+	vm.cpu = newCPU()
+	vm.memory.LoadRom(vm.rom)
+	vm.flushBlocks()
+	if vm.native != nil {
+		vm.native.runtime.Modified = false
+	}
+	vm.screen.clearDisplay()
+	vm.waitingForKey = false
+}
+
+// Step executes one OPCODE at a time
+func (vm *VM) Step() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.step()
+}
+
+func (vm *VM) step() error {
+	opcode, err := vm.readOpcode()
+	if err != nil {
+		return err
+	}
+
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debug(vm.cpu.register)
+	}
+
+	recording := vm.history != nil && !vm.history.replaying
+	if recording {
+		vm.recordStep()
+	}
+
+	pc := vm.cpu.programCounter
+	if err := vm.fetch(pc, opcode); err != nil {
+		return err
+	}
+
+	tracing := len(vm.tracers) > 0
+	ev := &vm.event
+	if tracing {
+		ev.Index = vm.steps
+		ev.Frame = vm.frames
+		ev.PC = pc
+		ev.Opcode = opcode
+		ev.Stack = vm.cpu.stack[:vm.cpu.stackPointer]
+		ev.Writes = ev.Writes[:0]
+	}
+
+	if err := vm.executeOpcode(opcode); err != nil {
+		vm.watchHit = nil
+		return err
+	}
+	vm.steps++
+	if recording {
+		vm.history.end = vm.steps
+	}
+
+	if tracing {
+		ev.NextPC = vm.cpu.programCounter
+		ev.Regs = vm.registers()
+		for _, t := range vm.tracers {
+			t.TraceStep(ev)
+		}
+	}
+
+	// reads and writes stop the vm once the instruction is done
+	if hit := vm.watchHit; hit != nil {
+		vm.watchHit = nil
+		return hit
+	}
+	return nil
+}
+
+// RunFrame executes one 60 Hz frame worth of instructions
+// and then counts the timers down once
+func (vm *VM) RunFrame() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	for i := 0; i < vm.config.InstructionsPerFrame; {
+		n := 1
+		var err error
+		if vm.runningNative() {
+			n, err = vm.runNative(vm.config.InstructionsPerFrame - i)
+		} else if vm.recompiling() {
+			n, err = vm.runBlock(vm.config.InstructionsPerFrame - i)
+		} else {
+			err = vm.step()
+		}
+		if err != nil {
+			return err
+		}
+		i += n
+	}
+
+	vm.tickTimers()
+	return nil
+}
+
+// StepTimers counts the delay and sound timers down once,
+// for frontends which clock instructions themselves via Step
+func (vm *VM) StepTimers() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.tickTimers()
+}
+
+// Framebuffer returns a copy of what's currently drawn
+func (vm *VM) Framebuffer() Framebuffer {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.screen.display
+}
+
+// Config returns the configuration the vm runs with
+func (vm *VM) Config() Config {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.config
+}
+
+// SetKey updates the state of a keypad key (0x0-0xF)
+func (vm *VM) SetKey(k byte, down bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.history != nil {
+		vm.recordInput(historyEvent{key: k, down: down})
+	}
+	vm.keypad.setKey(k, down)
+}
+
+// State returns a snapshot of the cpu
+func (vm *VM) State() State {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	cpu := vm.cpu
+	return State{
+		V:     cpu.register,
+		I:     cpu.registerI,
+		PC:    cpu.programCounter,
+		SP:    cpu.stackPointer,
+		DT:    cpu.delay,
+		ST:    cpu.sound,
+		Stack: append([]uint16(nil), cpu.stack[:cpu.stackPointer]...)}
+}
+
+// Register returns the value of Vx
+func (vm *VM) Register(x uint8) byte {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.cpu.register[x&0xF]
+}
+
+// PC returns the program counter
+func (vm *VM) PC() uint16 {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.cpu.programCounter
+}
+
+// ReadMemory copies n bytes of ram starting at addr,
+// cut short at the end of ram, nil for n <= 0
+func (vm *VM) ReadMemory(addr uint16, n int) []byte {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	start := int(addr)
+	if n <= 0 || start >= RAMSize {
+		return nil
+	}
+	// n isn't added to start, which could overflow
+	if n > RAMSize-start {
+		n = RAMSize - start
+	}
+
+	return append([]byte(nil), vm.memory.ram[start:start+n]...)
+}
+
+// ReadOpcode returns the opcode the program counter points at
+func (vm *VM) ReadOpcode() (uint16, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.readOpcode()
+}
+
+// readOpcode checks the memory and the current state of cpu
+// and returns the current opcode which is a 2 byte in size
+func (vm *VM) readOpcode() (uint16, error) {
+
+	memory := vm.memory
+	cpu := vm.cpu
+
+	pc := cpu.programCounter
+	if pc > RAMEndAddr-1 {
+		return 0, fmt.Errorf("program counter out of ram: %s", vm.describe(pc))
+	}
+
+	// Read two bytes of data and concat
+	// (what's happening in the below function call)
+	// op1 := memory.ram[pc]
+	// op2 := memory.ram[pc+1]
+	// concat the 2 bytes of code
+	// opcode := (uint16(op1) << 8) | uint16(op2)
+	opcode := binary.BigEndian.Uint16(memory.ram[pc : pc+2])
+
+	return opcode, nil
+}
+
+// Our massive switch statement
+// @future: Can this be improved with rust like matching and
+// how can function pointer array be leveraged to make this better
+func (vm *VM) executeOpcode(opcode uint16) error {
+
+	// Notes to self while understanding the opcode anatomy and execution
+	// Anatomy of a CHIP-8 opcode
+	// Length of every opcode: 2 bytes
+	//   1st nibble 2nd nibble      3rd nibble 4th nibble
+	// |_______________________|  |_______________________|
+	//        upperByte                  lowerByte
+
+	// terminology used for variables and opcode below
+	// In these listings, the following variables are used:
+
+	// nnn or addr - A 12-bit value, the lowest 12 bits of the instruction
+	// n or nibble - A 4-bit value, the lowest 4 bits of the instruction
+	// x - A 4-bit value, the lower 4 bits of the high byte of the instruction
+	// y - A 4-bit value, the upper 4 bits of the low byte of the instruction
+	// kk or byte - An 8-bit value, the lowest 8 bits of the instruction
+
+	// NOTE: Above docs have been provided
+	// in opcodes.go for easy reference
+
+	upperByte := byte(opcode >> 8) // & 0xFF00
+	lowerByte := byte(opcode & 0xFF)
+
+	// In most significant -> to least significant order
+	firstNibble := upperByte >> 4
+	secondNibble := upperByte & 0xF
+	thirdNibble := lowerByte >> 4
+	fourthNibble := lowerByte & 0xF
+
+	mmm := opcode & 0xFFF
+
+	x := secondNibble
+	y := thirdNibble
+	kk := lowerByte
+
+	if opcode == 0 {
+		// NOP, roms running off into empty memory execute
+		// a lot of these, so only logged when debugging
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.Debugf("NO OP code called! %s", HexOf(opcode))
+		}
+	} else if upperByte == 0 {
+		if lowerByte == 0xE0 {
+			vm.cls()
+		} else if lowerByte == 0xEE {
+			return vm.ret()
+		} else {
+			// 0nnn, execute machine language subroutine at address NNN
+			return vm.unknownOpcode(opcode)
+		}
+	} else if firstNibble == 1 {
+		// 1nnn
+		vm.jp(mmm)
+	} else if firstNibble == 2 {
+		// 2nnn
+		return vm.call(mmm)
+	} else if firstNibble == 3 {
+		// 3xkk
+		vm.se(x, kk)
+	} else if firstNibble == 4 {
+		// 4xkk
+		vm.se_not(x, kk)
+	} else if firstNibble == 5 {
+		// 5xy0
+		vm.se_reg(x, y)
+	} else if firstNibble == 6 {
+		// 6xkk
+		vm.ld(x, kk)
+	} else if firstNibble == 7 {
+		// 7xkk
+		vm.add(x, kk)
+	} else if firstNibble == 8 {
+		if fourthNibble == 0 {
+			// 8xy0
+			vm.ld_reg(x, y)
+		} else if fourthNibble == 1 {
+			// 8xy1
+			vm.or(x, y)
+		} else if fourthNibble == 2 {
+			// 8xy2
+			vm.and(x, y)
+		} else if fourthNibble == 3 {
+			// 8xy3
+			vm.xor(x, y)
+		} else if fourthNibble == 4 {
+			// 8xy4
+			vm.add_reg(x, y)
+		} else if fourthNibble == 5 {
+			// 8xy5
+			vm.sub_reg(x, y)
+		} else if fourthNibble == 6 {
+			// 8xy6
+			vm.shr(x, y)
+		} else if fourthNibble == 7 {
+			// 8xy7
+			vm.subn(x, y)
+		} else if fourthNibble == 0xE {
+			// 8xyE
+			vm.shl(x, y)
+		} else {
+			return vm.unknownOpcode(opcode)
+		}
+	} else if firstNibble == 9 {
+		// 9xy0
+		vm.sne(x, y)
+	} else if firstNibble == 0xA {
+		// Annn
+		vm.ld_i(mmm)
+	} else if firstNibble == 0xB {
+		// Bnnn
+		vm.jp_add(mmm)
+	} else if firstNibble == 0xC {
+		// Cxkk
+		vm.rnd(x, kk)
+	} else if firstNibble == 0xD {
+		// Dxyn
+		n := uint8(fourthNibble)
+		vm.drw(x, y, n)
+	} else if firstNibble == 0xE {
+		if thirdNibble == 0x9 {
+			// Ex9E
+			vm.skp(x)
+		} else if thirdNibble == 0xA {
+			// ExA1
+			vm.sknp(x)
+		} else {
+			return vm.unknownOpcode(opcode)
+		}
+	} else if firstNibble == 0xF {
+		// last remaining in series
+		if lowerByte == 0x07 {
+			// Fx07
+			vm.ld_dt_in_vx(x)
+		} else if lowerByte == 0x0A {
+			// Fx0A
+			vm.ld_key(x)
+		} else if lowerByte == 0x15 {
+			// Fx15
+			vm.ld_dt(x)
+		} else if lowerByte == 0x18 {
+			// Fx18
+			vm.ld_st(x)
+		} else if lowerByte == 0x1E {
+			// Fx1E
+			vm.add_i(x)
+		} else if lowerByte == 0x29 {
+			// Fx29
+			vm.ld_font(x)
+		} else if lowerByte == 0x33 {
+			// Fx33
+			vm.bcd_ld(x)
+		} else if lowerByte == 0x55 {
+			// Fx55
+			vm.ld_i_to_vx(x)
+		} else if lowerByte == 0x65 {
+			// Fx65
+			vm.ld_vx(x)
+		} else {
+			return vm.unknownOpcode(opcode)
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) unknownOpcode(opcode uint16) error {
+	return fmt.Errorf("opcode %04X at %s not implemented", opcode, vm.describe(vm.cpu.programCounter))
+}
@@ -0,0 +1,168 @@
+package chip8
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestStackErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rom  []byte
+		want error
+		pc   uint16
+	}{
+		// RET with nothing called
+		{"underflow", []byte{0x00, 0xEE}, ErrStackUnderflow, 0x200},
+		// 0x200: CALL 0x202, 0x202: CALL 0x204, ... 17 deep
+		{"overflow", []byte{
+			0x22, 0x02, 0x22, 0x04, 0x22, 0x06, 0x22, 0x08, 0x22, 0x0A, 0x22, 0x0C,
+			0x22, 0x0E, 0x22, 0x10, 0x22, 0x12, 0x22, 0x14, 0x22, 0x16, 0x22, 0x18,
+			0x22, 0x1A, 0x22, 0x1C, 0x22, 0x1E, 0x22, 0x20, 0x22, 0x22,
+		}, ErrStackOverflow, 0x220},
+	}
+
+	for _, tt := range tests {
+		for _, recompile := range []bool{false, true} {
+			vm := New(Config{InstructionsPerFrame: 20})
+			if err := vm.LoadROM(tt.rom); err != nil {
+				t.Fatal(err)
+			}
+			vm.Recompile(recompile)
+
+			err := vm.RunFrame()
+			if !errors.Is(err, tt.want) {
+				t.Errorf("%s, recompile %v: got %v, want %v", tt.name, recompile, err, tt.want)
+				continue
+			}
+			// the faulting instruction is left to run again
+			if s := vm.State(); s.PC != tt.pc {
+				t.Errorf("%s, recompile %v: stopped at %03X, want %03X", tt.name, recompile, s.PC, tt.pc)
+			}
+		}
+	}
+}
+
+func TestReadMemory(t *testing.T) {
+	vm := New(Config{})
+	if err := vm.LoadROM([]byte{0x00, 0xE0, 0x12, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr uint16
+		n    int
+		want int
+	}{
+		{0x200, 4, 4},
+		{0x200, 0, 0},
+		{0x200, -1, 0},
+		{0xFFE, 4, 2},
+		{0x200, int(^uint(0) >> 1), RAMSize - 0x200},
+		{RAMSize, 1, 0},
+		{0xFFFF, 1, 0},
+	}
+
+	for _, tt := range tests {
+		if got := vm.ReadMemory(tt.addr, tt.n); len(got) != tt.want {
+			t.Errorf("ReadMemory(%03X, %d): got %d bytes, want %d", tt.addr, tt.n, len(got), tt.want)
+		}
+	}
+	if got := vm.ReadMemory(0x200, 4); !bytes.Equal(got, []byte{0x00, 0xE0, 0x12, 0x02}) {
+		t.Errorf("ReadMemory(200, 4): got % X", got)
+	}
+}
+
+func TestRunFrameAllocs(t *testing.T) {
+	for _, recompile := range []bool{false, true} {
+		vm := New(Config{InstructionsPerFrame: 1000})
+		if err := vm.LoadROM(loopRom); err != nil {
+			t.Fatal(err)
+		}
+		vm.Recompile(recompile)
+
+		// the recompiler allocates compiling code it hasn't seen
+		if err := vm.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+
+		n := testing.AllocsPerRun(100, func() {
+			if err := vm.RunFrame(); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if n != 0 {
+			t.Fatalf("recompile %v: a frame allocates %g times, want 0", recompile, n)
+		}
+	}
+}
+
+func TestALUFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     byte // the n of 8xyn
+		x, y   byte
+		vx, vy byte
+		want   byte // Vx
+		vf     byte
+	}{
+		{"add", 0x4, 1, 2, 0xFF, 0x01, 0x00, 1},
+		{"add no carry", 0x4, 1, 2, 0x10, 0x01, 0x11, 0},
+		{"sub", 0x5, 1, 2, 0x01, 0x02, 0xFF, 0},
+		{"sub equal", 0x5, 1, 2, 0x05, 0x05, 0x00, 1},
+		{"shr", 0x6, 1, 2, 0x03, 0x00, 0x01, 1},
+		{"subn", 0x7, 1, 2, 0x02, 0x01, 0xFF, 0},
+		{"subn equal", 0x7, 1, 2, 0x05, 0x05, 0x00, 1},
+		{"shl", 0xE, 1, 2, 0x81, 0x00, 0x02, 1},
+
+		// with x = F the flag is what's left in VF
+		{"add into VF", 0x4, 0xF, 2, 0xFF, 0x01, 1, 1},
+		{"sub into VF", 0x5, 0xF, 2, 0x05, 0x05, 1, 1},
+		{"shr into VF", 0x6, 0xF, 2, 0x02, 0x00, 0, 0},
+		{"subn into VF", 0x7, 0xF, 2, 0x02, 0x01, 0, 0},
+		{"shl into VF", 0xE, 0xF, 2, 0x80, 0x00, 1, 1},
+	}
+
+	for _, tt := range tests {
+		rom := []byte{
+			0x60 | tt.x, tt.vx, // LD Vx, vx
+			0x60 | tt.y, tt.vy, // LD Vy, vy
+			0x80 | tt.x, tt.y<<4 | tt.op,
+			0x12, 0x06, // JP 0x206
+		}
+		for _, recompile := range []bool{false, true} {
+			res := runFrames(t, rom, 3, 1, recompile)
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if v := res.state.V; v[tt.x] != tt.want || v[0xF] != tt.vf {
+				t.Errorf("%s, recompile %v: got V%X=%02X VF=%d, want %02X and VF=%d",
+					tt.name, recompile, tt.x, v[tt.x], v[0xF], tt.want, tt.vf)
+			}
+		}
+	}
+}
+
+func TestSpeedLabel(t *testing.T) {
+	vm := New(Config{InstructionsPerFrame: 10})
+
+	steps := []struct {
+		change func()
+		want   string
+	}{
+		{func() {}, "10 ipf (600 ips)"},
+		{func() { vm.SetPaused(true) }, "10 ipf (600 ips) paused"},
+		{func() { vm.SetPaused(false); vm.SetFastForward(true) }, "10 ipf (600 ips) x" + fmt.Sprint(FastForwardFactor)},
+		{func() { vm.SetFastForward(false); vm.SetInstructionsPerFrame(20) }, "20 ipf (1200 ips)"},
+	}
+
+	// the label is cached, it must still follow every change
+	for _, s := range steps {
+		s.change()
+		if got := vm.SpeedLabel(); got != s.want {
+			t.Errorf("got %q, want %q", got, s.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package chip8
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FrameRate is the 60 Hz the timers count down at,
+// Roughly every 16 millisecond.
+const FrameRate = time.Duration(16666) * time.Microsecond
+
+// FastForwardFactor is how many frames Run executes per tick
+// while fast forwarding
+const FastForwardFactor = 8
+
+// Steps SpeedUp/SlowDown move along, in instructions per frame
+var speedSteps = []int{1, 2, 3, 5, 7, 10, 15, 20, 30, 50, 75, 100, 200, 500, 1000}
+
+// Run drives the vm in real time, one RunFrame per FrameRate tick,
+// until ctx is done or the program faults. Every vm runs on its own
+// ticker so any number of them can run side by side.
+func (vm *VM) Run(ctx context.Context) error {
+	ticker := time.NewTicker(FrameRate)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		vm.mu.Lock()
+		frames := 1
+		if vm.paused {
+			frames = 0
+		} else if vm.fastForward {
+			frames = FastForwardFactor
+		}
+		vm.mu.Unlock()
+
+		for i := 0; i < frames; i++ {
+			if err := vm.RunFrame(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SetInstructionsPerFrame changes the cpu speed, how many
+// instructions RunFrame executes per 60 Hz frame
+func (vm *VM) SetInstructionsPerFrame(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.config.InstructionsPerFrame = n
+}
+
+// InstructionsPerFrame returns the current cpu speed
+func (vm *VM) InstructionsPerFrame() int {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.config.InstructionsPerFrame
+}
+
+// SpeedUp bumps the cpu speed to the next step up
+func (vm *VM) SpeedUp() {
+	ipf := vm.InstructionsPerFrame()
+	for _, step := range speedSteps {
+		if step > ipf {
+			vm.SetInstructionsPerFrame(step)
+			return
+		}
+	}
+}
+
+// SlowDown drops the cpu speed to the next step down
+func (vm *VM) SlowDown() {
+	ipf := vm.InstructionsPerFrame()
+	for i := len(speedSteps) - 1; i >= 0; i-- {
+		if speedSteps[i] < ipf {
+			vm.SetInstructionsPerFrame(speedSteps[i])
+			return
+		}
+	}
+}
+
+// SetPaused stops (or resumes) Run from executing frames
+func (vm *VM) SetPaused(paused bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.paused = paused
+}
+
+// Paused reports whether Run is paused
+func (vm *VM) Paused() bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.paused
+}
+
+// SetFastForward makes Run execute FastForwardFactor frames per tick
+func (vm *VM) SetFastForward(fastForward bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.fastForward = fastForward
+}
+
+// AdvanceFrame runs a single frame, for stepping through a paused vm
+func (vm *VM) AdvanceFrame() error {
+	if !vm.Paused() {
+		return nil
+	}
+
+	return vm.RunFrame()
+}
+
+// SpeedLabel describes the current speed, e.g. "10 ipf (600 ips)"
+func (vm *VM) SpeedLabel() string {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	// the osd asks for it every frame while paused
+	l := &vm.speedLabel
+	if l.text != "" && l.ipf == vm.config.InstructionsPerFrame &&
+		l.paused == vm.paused && l.fastForward == vm.fastForward {
+		return l.text
+	}
+
+	ipf := vm.config.InstructionsPerFrame
+	label := fmt.Sprintf("%d ipf (%d ips)", ipf, ipf*60)
+
+	if vm.paused {
+		label += " paused"
+	} else if vm.fastForward {
+		label += fmt.Sprintf(" x%d", FastForwardFactor)
+	}
+
+	*l = speedLabel{label, ipf, vm.paused, vm.fastForward}
+	return label
+}
+
+// speedLabel is the last SpeedLabel and the speed it was made for
+type speedLabel struct {
+	text        string
+	ipf         int
+	paused      bool
+	fastForward bool
+}
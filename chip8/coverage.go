@@ -0,0 +1,140 @@
+package chip8
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Coverage is a Tracer recording which addresses a program executed,
+// and for every skip instruction whether it skipped, didn't, or both
+type Coverage struct {
+	mu sync.Mutex
+
+	rom      []byte
+	hits     [RAMSize]uint64
+	skipped  [RAMSize]uint64
+	fellThru [RAMSize]uint64
+}
+
+// NewCoverage starts recording coverage of rom
+func NewCoverage(rom []byte) *Coverage {
+	return &Coverage{rom: append([]byte(nil), rom...)}
+}
+
+// TraceStep records ev, implements Tracer
+func (c *Coverage) TraceStep(ev *StepEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pc := ev.PC & RAMEndAddr
+	c.hits[pc]++
+
+	if Decode(ev.Opcode).IsSkip() {
+		if ev.NextPC == ev.PC+4 {
+			c.skipped[pc]++
+		} else {
+			c.fellThru[pc]++
+		}
+	}
+}
+
+// Profile returns the coverage recorded so far, as a single run
+func (c *Coverage) Profile() *CoverageProfile {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := &CoverageProfile{
+		RomHash: RomHash(c.rom),
+		Rom:     c.rom,
+		Runs:    1,
+	}
+	for addr := range c.hits {
+		if c.hits[addr] > 0 {
+			p.Addrs = append(p.Addrs, CoverageAddr{
+				Addr:     uint16(addr),
+				Hits:     c.hits[addr],
+				Skipped:  c.skipped[addr],
+				FellThru: c.fellThru[addr]})
+		}
+	}
+	return p
+}
+
+// CoverageProfile is coverage as saved to disk, possibly
+// summed up over several runs of the same rom
+type CoverageProfile struct {
+	RomHash string `json:"rom_sha1"`
+	Rom     []byte `json:"rom"`
+	Runs    int    `json:"runs"`
+
+	// executed addresses, in order
+	Addrs []CoverageAddr `json:"addrs"`
+}
+
+// CoverageAddr is how often the instruction at Addr ran, and for
+// skip instructions how often they skipped or fell through
+type CoverageAddr struct {
+	Addr     uint16 `json:"addr"`
+	Hits     uint64 `json:"hits"`
+	Skipped  uint64 `json:"skipped,omitempty"`
+	FellThru uint64 `json:"fell_through,omitempty"`
+}
+
+// ReadCoverage reads a profile written by WriteCoverage
+func ReadCoverage(r io.Reader) (*CoverageProfile, error) {
+	var p CoverageProfile
+	if err := json.NewDecoder(r).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.RomHash != RomHash(p.Rom) {
+		return nil, fmt.Errorf("coverage rom doesn't match its hash %s", p.RomHash)
+	}
+
+	sort.Slice(p.Addrs, func(i, j int) bool { return p.Addrs[i].Addr < p.Addrs[j].Addr })
+	return &p, nil
+}
+
+// WriteCoverage saves p as json
+func (p *CoverageProfile) WriteCoverage(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(p)
+}
+
+// Merge adds the counts of other, a profile of the same rom
+func (p *CoverageProfile) Merge(other *CoverageProfile) error {
+	if other.RomHash != p.RomHash {
+		return fmt.Errorf("coverage is of a different rom (sha1 %s, not %s)", other.RomHash, p.RomHash)
+	}
+
+	byAddr := map[uint16]*CoverageAddr{}
+	for i := range p.Addrs {
+		byAddr[p.Addrs[i].Addr] = &p.Addrs[i]
+	}
+
+	for _, a := range other.Addrs {
+		if mine, ok := byAddr[a.Addr]; ok {
+			mine.Hits += a.Hits
+			mine.Skipped += a.Skipped
+			mine.FellThru += a.FellThru
+		} else {
+			p.Addrs = append(p.Addrs, a)
+		}
+	}
+
+	sort.Slice(p.Addrs, func(i, j int) bool { return p.Addrs[i].Addr < p.Addrs[j].Addr })
+	p.Runs += other.Runs
+	return nil
+}
+
+// Lookup returns the counts for addr, zero if it never ran
+func (p *CoverageProfile) Lookup(addr uint16) CoverageAddr {
+	i := sort.Search(len(p.Addrs), func(i int) bool { return p.Addrs[i].Addr >= addr })
+	if i < len(p.Addrs) && p.Addrs[i].Addr == addr {
+		return p.Addrs[i]
+	}
+	return CoverageAddr{Addr: addr}
+}
@@ -0,0 +1,100 @@
+package chip8
+
+import "fmt"
+
+// Debugger support: execution breakpoints and
+// writing registers and memory from outside.
+
+// BreakpointHit is the error Step and RunFrame stop with when the
+// program counter reaches a breakpoint, before the instruction
+// there runs. Resuming runs it.
+type BreakpointHit struct {
+	PC uint16
+}
+
+func (b *BreakpointHit) Error() string {
+	return fmt.Sprintf("breakpoint at %03X", b.PC)
+}
+
+// AddBreakpoint stops execution whenever the program counter reaches addr
+func (vm *VM) AddBreakpoint(addr uint16) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.breakpoints == nil {
+		vm.breakpoints = map[uint16]bool{}
+	}
+	vm.breakpoints[addr&RAMEndAddr] = true
+}
+
+// RemoveBreakpoint clears the breakpoint at addr, reporting whether there was one
+func (vm *VM) RemoveBreakpoint(addr uint16) bool {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	addr &= RAMEndAddr
+	if !vm.breakpoints[addr] {
+		return false
+	}
+	delete(vm.breakpoints, addr)
+	return true
+}
+
+// ClearBreakpoints removes all breakpoints
+func (vm *VM) ClearBreakpoints() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.breakpoints = nil
+}
+
+// SetState overwrites the cpu registers, stack included
+func (vm *VM) SetState(s State) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	cpu := vm.cpu
+	cpu.register = s.V
+	cpu.registerI = s.I
+	cpu.programCounter = s.PC & RAMEndAddr
+	cpu.delay = s.DT
+	cpu.sound = s.ST
+
+	cpu.stackPointer = s.SP
+	if int(cpu.stackPointer) > len(cpu.stack) {
+		cpu.stackPointer = byte(len(cpu.stack))
+	}
+	copy(cpu.stack[:], s.Stack)
+
+	// a new PC means a new place to stop at
+	vm.resumeExecAt = -1
+	vm.restartHistory()
+}
+
+// WriteMemory stores data to ram from addr on,
+// whatever doesn't fit in ram is dropped
+func (vm *VM) WriteMemory(addr uint16, data []byte) int {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if int(addr) >= RAMSize {
+		return 0
+	}
+	n := copy(vm.memory.ram[addr:], data)
+	for i := 0; i < n; i++ {
+		vm.invalidateCode(addr + uint16(i))
+	}
+	vm.restartHistory()
+	return n
+}
+
+// SkipBreakpoint lets the instruction at the program counter run
+// on the next step even if it has a breakpoint or an execution
+// watchpoint, the way resuming after stopping there does.
+// Debuggers call it to continue from wherever they stepped to.
+func (vm *VM) SkipBreakpoint() {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.resumeExecAt = int(vm.cpu.programCounter)
+}
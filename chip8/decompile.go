@@ -0,0 +1,477 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Decompilation to pseudo-C. Each subroutine found by Analyze
+// becomes a function, its instructions in address order are turned
+// back into statements, and the skip+jump patterns CHIP-8 programs
+// branch with into structure:
+//
+//	SE v0, 1; JP else; <then>; JP end; <else>     if (v0 == 1) { } else { }
+//	SE v0, 1; <one instruction>                   if (v0 != 1) ...;
+//	head: <body>; JP head                         for (;;) { }
+//	head: SE v0, 1; JP exit; <body>; JP head      while (v0 == 1) { }
+//	head: <body>; SNE v0, 1; JP head              do { } while (v0 == 1)
+//
+// Jumps no pattern accounts for are left as gotos to labels.
+
+// stmt is a decompiled statement, simple or compound
+type stmt struct {
+	// heads are the instructions the statement starts with
+	heads []uint16
+
+	text string
+
+	// compound statements: kind is "if", "for", "while" or "do"
+	kind     string
+	cond     string
+	body     []stmt
+	elseBody []stmt
+}
+
+type loopContext struct {
+	head, exit uint16
+}
+
+type decompiler struct {
+	a       *Analysis
+	symbols *Symbols
+
+	// the function's instructions, in address order
+	addrs []uint16
+	index map[uint16]int
+
+	// how many edges lead into each block start
+	preds map[uint16]int
+
+	loops  []loopContext
+	labels map[uint16]bool
+}
+
+// Decompile writes every subroutine as a pseudo-C function,
+// naming them and their labels after symbols if given
+func (a *Analysis) Decompile(w io.Writer, symbols *Symbols) error {
+	preds := map[uint16]int{}
+	for _, b := range a.Blocks {
+		for _, e := range b.Succs {
+			preds[e.To]++
+		}
+	}
+
+	var b strings.Builder
+	for i, sub := range a.Subroutines {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+
+		d := &decompiler{
+			a:       a,
+			symbols: symbols,
+			index:   map[uint16]int{},
+			preds:   preds,
+			labels:  map[uint16]bool{},
+		}
+		for _, block := range sub.Blocks {
+			d.addrs = append(d.addrs, block.Instructions()...)
+		}
+		sortAddrs(d.addrs)
+		for i, addr := range d.addrs {
+			d.index[addr] = i
+		}
+
+		body := d.region(0, len(d.addrs))
+
+		fmt.Fprintf(&b, "// %03X-%03X\n", sub.Entry, d.addrs[len(d.addrs)-1]+1)
+		fmt.Fprintf(&b, "void %s() {\n", SubroutineName(sub.Entry, symbols))
+		d.write(&b, body, 1)
+		b.WriteString("}\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// region decompiles the instructions addrs[lo:hi]
+func (d *decompiler) region(lo, hi int) []stmt {
+	var out []stmt
+	for i := lo; i < hi; {
+		s, next := d.statement(i, hi)
+		out = append(out, s)
+		i = next
+	}
+	return out
+}
+
+// statement decompiles from addrs[i], not going past addrs[hi],
+// and returns where the next statement starts
+func (d *decompiler) statement(i, hi int) (stmt, int) {
+	pc := d.addrs[i]
+	opcode := d.a.Opcode(pc)
+
+	// a loop ends in the last jump back here
+	for j := hi - 1; j >= i; j-- {
+		if op := d.a.Opcode(d.addrs[j]); op>>12 == 0x1 && op&0xFFF == pc {
+			return d.loop(i, j), j + 1
+		}
+	}
+
+	if Decode(opcode).IsSkip() {
+		return d.skip(i, hi)
+	}
+	return stmt{heads: []uint16{pc}, text: d.simple(pc)}, i + 1
+}
+
+// loop decompiles addrs[i:j+1], addrs[j] jumping back to addrs[i]
+func (d *decompiler) loop(i, j int) stmt {
+	head, back := d.addrs[i], d.addrs[j]
+	d.loops = append(d.loops, loopContext{head: head, exit: back + 2})
+	defer func() { d.loops = d.loops[:len(d.loops)-1] }()
+
+	// do { } while: a skip right before the jump back decides
+	if j-1 > i && d.addrs[j-1] == back-2 && d.preds[back] <= 1 {
+		if op := d.a.Opcode(back - 2); Decode(op).IsSkip() {
+			return stmt{
+				heads: []uint16{head},
+				kind:  "do",
+				cond:  d.cond(op, false),
+				body:  d.region(i, j-1),
+			}
+		}
+	}
+
+	// while: the loop starts by skipping a jump out of it
+	if j > i+1 && d.addrs[i+1] == head+2 && d.preds[head+2] <= 1 {
+		op, next := d.a.Opcode(head), d.a.Opcode(head+2)
+		if Decode(op).IsSkip() && next>>12 == 0x1 && next&0xFFF == back+2 {
+			return stmt{
+				heads: []uint16{head, head + 2},
+				kind:  "while",
+				cond:  d.cond(op, true),
+				body:  d.continued(d.region(i+2, j), head, back),
+			}
+		}
+	}
+
+	return stmt{heads: []uint16{head}, kind: "for", body: d.continued(d.region(i, j), head, back)}
+}
+
+// continued ends a loop's body with a continue when
+// something jumps to the jump back to the loop head
+func (d *decompiler) continued(body []stmt, head, back uint16) []stmt {
+	if back != head && d.labels[back] {
+		body = append(body, stmt{heads: []uint16{back}, text: "continue;"})
+	}
+	return body
+}
+
+// skip decompiles a skip instruction at addrs[i] with what it skips
+func (d *decompiler) skip(i, hi int) (stmt, int) {
+	pc := d.addrs[i]
+	op := d.a.Opcode(pc)
+
+	// skipping to outside the region
+	if i+1 >= hi || d.addrs[i+1] != pc+2 {
+		return stmt{heads: []uint16{pc}, kind: "if", cond: d.cond(op, true),
+			body: []stmt{{text: d.jump(pc, pc+4)}}}, i + 1
+	}
+
+	// skipping a jump forward over a block: if, maybe with an else
+	next := d.a.Opcode(pc + 2)
+	if target := next & 0xFFF; next>>12 == 0x1 && d.preds[pc+2] <= 1 && !d.loopJump(target) {
+		if ti := d.position(target, hi); ti > i+1 {
+			s := stmt{heads: []uint16{pc, pc + 2}, kind: "if", cond: d.cond(op, true)}
+
+			if last := ti - 1; last > i+1 && d.preds[d.addrs[last]] <= 1 {
+				end := d.a.Opcode(d.addrs[last])
+				if ui := d.position(end&0xFFF, hi); end>>12 == 0x1 && end&0xFFF > target && ui > ti {
+					s.body = d.region(i+2, last)
+					s.elseBody = d.region(ti, ui)
+					return s, ui
+				}
+			}
+
+			s.body = d.region(i+2, ti)
+			return s, ti
+		}
+	}
+
+	// skipping the one instruction
+	s, _ := d.statement(i+1, i+2)
+	return stmt{heads: []uint16{pc}, kind: "if", cond: d.cond(op, false), body: []stmt{s}}, i + 2
+}
+
+// position returns the index of addr in the function, as long as
+// it's no further than addrs[hi], or -1
+func (d *decompiler) position(addr uint16, hi int) int {
+	if i, ok := d.index[addr]; ok && i <= hi {
+		return i
+	}
+	if hi == len(d.addrs) && addr == d.addrs[hi-1]+2 {
+		return hi
+	}
+	return -1
+}
+
+// loopJump reports whether jumping to addr is a continue or break
+func (d *decompiler) loopJump(addr uint16) bool {
+	if len(d.loops) == 0 {
+		return false
+	}
+	l := d.loops[len(d.loops)-1]
+	return addr == l.head || addr == l.exit
+}
+
+// jump is the statement for going from pc to addr
+func (d *decompiler) jump(pc, addr uint16) string {
+	if len(d.loops) > 0 {
+		switch l := d.loops[len(d.loops)-1]; addr {
+		case l.head:
+			return "continue;"
+		case l.exit:
+			return "break;"
+		}
+	}
+	if addr == pc+2 {
+		return ";"
+	}
+	return "goto " + d.label(addr) + ";"
+}
+
+func (d *decompiler) label(addr uint16) string {
+	d.labels[addr] = true
+	if label := d.symbols.Label(addr); label != "" {
+		return label
+	}
+	return fmt.Sprintf("label_%03X", addr)
+}
+
+// addr names a memory address, by label if there's one
+func (d *decompiler) addr(addr uint16) string {
+	if label := d.symbols.Label(addr); label != "" {
+		return label
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+// cond is the condition under which a skip instruction skips,
+// or, not skipping, under which it doesn't
+func (d *decompiler) cond(opcode uint16, skipping bool) string {
+	x := (opcode >> 8) & 0xF
+	y := (opcode >> 4) & 0xF
+	kk := opcode & 0xFF
+
+	eq, ne, key := "==", "!=", ""
+	if !skipping {
+		eq, ne, key = ne, eq, "!"
+	}
+	notKey := "!"
+	if !skipping {
+		notKey = ""
+	}
+
+	switch {
+	case opcode>>12 == 0x3:
+		return fmt.Sprintf("v%x %s 0x%02X", x, eq, kk)
+	case opcode>>12 == 0x4:
+		return fmt.Sprintf("v%x %s 0x%02X", x, ne, kk)
+	case opcode>>12 == 0x5:
+		return fmt.Sprintf("v%x %s v%x", x, eq, y)
+	case opcode>>12 == 0x9:
+		return fmt.Sprintf("v%x %s v%x", x, ne, y)
+	case opcode&0xF0FF == 0xE09E:
+		return fmt.Sprintf("%skey_down(v%x)", key, x)
+	}
+	return fmt.Sprintf("%skey_down(v%x)", notKey, x)
+}
+
+// simple is the statement for a non-skip instruction
+func (d *decompiler) simple(pc uint16) string {
+	opcode := d.a.Opcode(pc)
+	x := (opcode >> 8) & 0xF
+	y := (opcode >> 4) & 0xF
+	n := opcode & 0xF
+	kk := opcode & 0xFF
+	nnn := opcode & 0xFFF
+	quirks := d.a.Quirks
+
+	switch opcode >> 12 {
+	case 0x0:
+		switch opcode {
+		case 0x00E0:
+			return "clear();"
+		case 0x00EE:
+			return "return;"
+		}
+	case 0x1:
+		return d.jump(pc, nnn)
+	case 0x2:
+		return SubroutineName(nnn, d.symbols) + "();"
+	case 0x6:
+		return fmt.Sprintf("v%x = 0x%02X;", x, kk)
+	case 0x7:
+		return fmt.Sprintf("v%x += 0x%02X;", x, kk)
+	case 0x8:
+		vfReset := ""
+		if quirks.LogicResetsVF {
+			vfReset = " vf = 0;"
+		}
+		switch n {
+		case 0x0:
+			return fmt.Sprintf("v%x = v%x;", x, y)
+		case 0x1:
+			return fmt.Sprintf("v%x |= v%x;%s", x, y, vfReset)
+		case 0x2:
+			return fmt.Sprintf("v%x &= v%x;%s", x, y, vfReset)
+		case 0x3:
+			return fmt.Sprintf("v%x ^= v%x;%s", x, y, vfReset)
+		case 0x4:
+			return fmt.Sprintf("v%x += v%x; // vf = carry", x, y)
+		case 0x5:
+			return fmt.Sprintf("v%x -= v%x; // vf = no borrow", x, y)
+		case 0x7:
+			return fmt.Sprintf("v%x = v%x - v%x; // vf = no borrow", x, y, x)
+		case 0x6, 0xE:
+			op := ">>"
+			if n == 0xE {
+				op = "<<"
+			}
+			if quirks.ShiftUsesVY {
+				return fmt.Sprintf("v%x = v%x %s 1; // vf = bit shifted out", x, y, op)
+			}
+			return fmt.Sprintf("v%x %s= 1; // vf = bit shifted out", x, op)
+		}
+	case 0xA:
+		return "i = " + d.addr(nnn) + ";"
+	case 0xB:
+		return d.computedJump(pc, nnn)
+	case 0xC:
+		return fmt.Sprintf("v%x = random() & 0x%02X;", x, kk)
+	case 0xD:
+		return fmt.Sprintf("vf = draw(v%x, v%x, %d);", x, y, n)
+	case 0xF:
+		regs := fmt.Sprintf("v0..v%x", x)
+		if x == 0 {
+			regs = "v0"
+		}
+		step := ""
+		if quirks.LoadStoreIncrementsI {
+			step = fmt.Sprintf(" i += %d;", x+1)
+		}
+		switch kk {
+		case 0x07:
+			return fmt.Sprintf("v%x = delay;", x)
+		case 0x0A:
+			return fmt.Sprintf("v%x = wait_key();", x)
+		case 0x15:
+			return fmt.Sprintf("delay = v%x;", x)
+		case 0x18:
+			return fmt.Sprintf("sound = v%x;", x)
+		case 0x1E:
+			return fmt.Sprintf("i += v%x;", x)
+		case 0x29:
+			return fmt.Sprintf("i = font(v%x);", x)
+		case 0x33:
+			return fmt.Sprintf("bcd(v%x);", x)
+		case 0x55:
+			return fmt.Sprintf("save(%s);%s", regs, step)
+		case 0x65:
+			return fmt.Sprintf("load(%s);%s", regs, step)
+		}
+	}
+	return fmt.Sprintf("/* %s */", Disassemble(opcode))
+}
+
+// computedJump is a Bnnn, with the targets analysis found
+func (d *decompiler) computedJump(pc, nnn uint16) string {
+	reg := uint16(0)
+	if d.a.Quirks.JumpUsesVX {
+		reg = nnn >> 8
+	}
+
+	var targets []string
+	if b := d.a.Block(pc); b != nil {
+		for _, e := range b.Succs {
+			if e.Kind == EdgeComputed {
+				targets = append(targets, d.label(e.To))
+			}
+		}
+	} else {
+		// the jump is at the end of a block starting earlier
+		for _, b := range d.a.Blocks {
+			if b.Start <= pc && pc < b.End {
+				for _, e := range b.Succs {
+					if e.Kind == EdgeComputed {
+						targets = append(targets, d.label(e.To))
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(targets)
+
+	s := fmt.Sprintf("goto *(%s + v%x);", d.addr(nnn), reg)
+	if len(targets) > 0 {
+		s += " // " + strings.Join(targets, ", ")
+	}
+	return s
+}
+
+// write renders statements at the given depth of indentation
+func (d *decompiler) write(b *strings.Builder, stmts []stmt, depth int) {
+	indent := strings.Repeat("\t", depth)
+	for _, s := range stmts {
+		for _, addr := range s.heads {
+			if d.labels[addr] {
+				fmt.Fprintf(b, "%s%s:\n", strings.Repeat("\t", depth-1), d.label(addr))
+			}
+		}
+
+		switch s.kind {
+		case "":
+			fmt.Fprintf(b, "%s%s\n", indent, s.text)
+		case "if":
+			// a lone simple statement goes on the same line
+			if len(s.body) == 1 && s.body[0].kind == "" && len(s.elseBody) == 0 && !d.labelled(s.body[0]) {
+				fmt.Fprintf(b, "%sif (%s) %s\n", indent, s.cond, s.body[0].text)
+				continue
+			}
+			fmt.Fprintf(b, "%sif (%s) {\n", indent, s.cond)
+			d.write(b, s.body, depth+1)
+			if len(s.elseBody) > 0 {
+				fmt.Fprintf(b, "%s} else {\n", indent)
+				d.write(b, s.elseBody, depth+1)
+			}
+			fmt.Fprintf(b, "%s}\n", indent)
+		case "for":
+			if len(s.body) == 0 {
+				fmt.Fprintf(b, "%sfor (;;) {} // halt\n", indent)
+				continue
+			}
+			fmt.Fprintf(b, "%sfor (;;) {\n", indent)
+			d.write(b, s.body, depth+1)
+			fmt.Fprintf(b, "%s}\n", indent)
+		case "while":
+			fmt.Fprintf(b, "%swhile (%s) {\n", indent, s.cond)
+			d.write(b, s.body, depth+1)
+			fmt.Fprintf(b, "%s}\n", indent)
+		case "do":
+			fmt.Fprintf(b, "%sdo {\n", indent)
+			d.write(b, s.body, depth+1)
+			fmt.Fprintf(b, "%s} while (%s);\n", indent, s.cond)
+		}
+	}
+}
+
+func (d *decompiler) labelled(s stmt) bool {
+	for _, addr := range s.heads {
+		if d.labels[addr] {
+			return true
+		}
+	}
+	return false
+}
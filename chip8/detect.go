@@ -0,0 +1,137 @@
+package chip8
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// MinSignatureHits is how many platform specific opcodes the analyser
+// wants to see before trusting them. A single one is as likely to be
+// sprite data that happens to look like an instruction.
+const MinSignatureHits = 2
+
+// Detection is the analyser's guess at what a rom was written for
+type Detection struct {
+	// Platform is empty when nothing pointed away from plain CHIP-8
+	Platform string
+
+	// Hires is set for 64x64 hires CHIP-8 roms, which start with 1260
+	Hires bool
+
+	// Quirks the platform needs
+	Quirks Quirks
+
+	// Evidence, e.g. "00FF (SCHIP hires on) x3, first at 0x24A"
+	Reasons []string
+
+	// QuirkSensitive counts the instructions whose behaviour
+	// depends on the quirks, by quirk name
+	QuirkSensitive map[string]int
+}
+
+// signature is an opcode only some platform has
+type signature struct {
+	platform string
+	name     string
+	match    func(opcode uint16) bool
+}
+
+var signatures = []signature{
+	{PlatformSChip, "00FF (hires on)", func(op uint16) bool { return op == 0x00FF }},
+	{PlatformSChip, "00FE (hires off)", func(op uint16) bool { return op == 0x00FE }},
+	{PlatformSChip, "00Cn (scroll down)", func(op uint16) bool { return op&0xFFF0 == 0x00C0 && op&0xF != 0 }},
+	{PlatformSChip, "00FB (scroll right)", func(op uint16) bool { return op == 0x00FB }},
+	{PlatformSChip, "00FC (scroll left)", func(op uint16) bool { return op == 0x00FC }},
+	{PlatformSChip, "00FD (exit)", func(op uint16) bool { return op == 0x00FD }},
+	{PlatformSChip, "Fx30 (big font)", func(op uint16) bool { return op&0xF0FF == 0xF030 }},
+	{PlatformSChip, "Fx75 (save flags)", func(op uint16) bool { return op&0xF0FF == 0xF075 }},
+	{PlatformSChip, "Fx85 (load flags)", func(op uint16) bool { return op&0xF0FF == 0xF085 }},
+
+	{PlatformXOChip, "F000 (long I)", func(op uint16) bool { return op == 0xF000 }},
+	{PlatformXOChip, "5xy2 (save range)", func(op uint16) bool { return op&0xF00F == 0x5002 }},
+	{PlatformXOChip, "5xy3 (load range)", func(op uint16) bool { return op&0xF00F == 0x5003 }},
+	{PlatformXOChip, "Fn01 (plane)", func(op uint16) bool { return op&0xF0FF == 0xF001 }},
+	{PlatformXOChip, "F002 (audio)", func(op uint16) bool { return op == 0xF002 }},
+	{PlatformXOChip, "Fx3A (pitch)", func(op uint16) bool { return op&0xF0FF == 0xF03A }},
+	{PlatformXOChip, "00Dn (scroll up)", func(op uint16) bool { return op&0xFFF0 == 0x00D0 }},
+}
+
+// DetectPlatform statically scans the program bytes for opcodes that
+// only exist on some platforms, and reports the likeliest target.
+// It is a heuristic: instructions and data are not told apart.
+func DetectPlatform(rom []byte) Detection {
+	det := Detection{QuirkSensitive: map[string]int{}}
+
+	type hit struct {
+		count int
+		first int
+	}
+	hits := map[int]*hit{}
+	platformHits := map[string]int{}
+
+	for offset := 0; offset+1 < len(rom); offset += 2 {
+		opcode := binary.BigEndian.Uint16(rom[offset:])
+
+		for i, sig := range signatures {
+			if !sig.match(opcode) {
+				continue
+			}
+			if hits[i] == nil {
+				hits[i] = &hit{first: offset}
+			}
+			hits[i].count++
+			platformHits[sig.platform]++
+		}
+
+		countQuirkSensitive(opcode, det.QuirkSensitive)
+	}
+
+	// report in signature order
+	var found []int
+	for i := range hits {
+		found = append(found, i)
+	}
+	sort.Ints(found)
+	for _, i := range found {
+		det.Reasons = append(det.Reasons, fmt.Sprintf("%s x%d, first at 0x%03X",
+			signatures[i].name, hits[i].count, ProgramAreaStart+hits[i].first))
+	}
+
+	// XO-CHIP is a superset of SCHIP, so it wins when both show up
+	switch {
+	case platformHits[PlatformXOChip] >= MinSignatureHits:
+		det.Platform = PlatformXOChip
+	case platformHits[PlatformSChip] >= MinSignatureHits:
+		det.Platform = PlatformSChip
+	case len(rom) >= 2 && rom[0] == 0x12 && rom[1] == 0x60:
+		det.Platform = PlatformChip8
+		det.Hires = true
+		det.Reasons = append(det.Reasons, "starts with 1260 (hires CHIP-8 entry point)")
+	}
+
+	if det.Platform != "" {
+		det.Quirks, _ = PlatformQuirks(det.Platform)
+	}
+
+	return det
+}
+
+// countQuirkSensitive tallies instructions whose result depends on a quirk
+func countQuirkSensitive(opcode uint16, counts map[string]int) {
+	switch {
+	case opcode&0xF00F == 0x8006 || opcode&0xF00F == 0x800E:
+		// only matters when x and y differ
+		if (opcode>>8)&0xF != (opcode>>4)&0xF {
+			counts["shift"]++
+		}
+	case opcode&0xF0FF == 0xF055 || opcode&0xF0FF == 0xF065:
+		counts["loadstore"]++
+	case opcode&0xF000 == 0xB000:
+		counts["jump"]++
+	case opcode&0xF00F == 0x8001 || opcode&0xF00F == 0x8002 || opcode&0xF00F == 0x8003:
+		counts["vfreset"]++
+	case opcode&0xF000 == 0xD000:
+		counts["clip"]++
+	}
+}
@@ -0,0 +1,152 @@
+package chip8
+
+import "fmt"
+
+// Instruction is a decoded opcode, in the mnemonics of
+// Cowgod's technical reference (the ones in opcodes.go)
+type Instruction struct {
+	Opcode uint16
+
+	// Mnemonic is the instruction class, e.g. "LD" or "DRW",
+	// and "???" for opcodes the vm doesn't implement
+	Mnemonic string
+
+	// Args are the operands as written, e.g. "V3, 0x07"
+	Args string
+}
+
+// Unknown opcodes decode to this mnemonic
+const MnemonicUnknown = "???"
+
+// Valid reports whether the vm implements the opcode
+func (in Instruction) Valid() bool {
+	return in.Mnemonic != MnemonicUnknown
+}
+
+// IsSkip reports whether the instruction conditionally
+// skips the next one, CHIP-8's only kind of branch
+func (in Instruction) IsSkip() bool {
+	switch in.Mnemonic {
+	case "SE", "SNE", "SKP", "SKNP":
+		return true
+	}
+	return false
+}
+
+func (in Instruction) String() string {
+	if in.Args == "" {
+		return in.Mnemonic
+	}
+	return in.Mnemonic + " " + in.Args
+}
+
+// Disassemble returns opcode as assembly, e.g. "DRW V0, V1, 5"
+func Disassemble(opcode uint16) string {
+	return Decode(opcode).String()
+}
+
+// Decode splits opcode into mnemonic and operands,
+// following the same dispatch as executeOpcode
+func Decode(opcode uint16) Instruction {
+	x := (opcode >> 8) & 0xF
+	y := (opcode >> 4) & 0xF
+	n := opcode & 0xF
+	kk := opcode & 0xFF
+	nnn := opcode & 0xFFF
+
+	in := func(mnemonic, format string, args ...interface{}) Instruction {
+		return Instruction{Opcode: opcode, Mnemonic: mnemonic, Args: fmt.Sprintf(format, args...)}
+	}
+	unknown := Instruction{Opcode: opcode, Mnemonic: MnemonicUnknown, Args: fmt.Sprintf("0x%04X", opcode)}
+
+	switch opcode >> 12 {
+	case 0x0:
+		switch opcode {
+		case 0x0000:
+			return in("NOP", "")
+		case 0x00E0:
+			return in("CLS", "")
+		case 0x00EE:
+			return in("RET", "")
+		}
+		return unknown
+	case 0x1:
+		return in("JP", "0x%03X", nnn)
+	case 0x2:
+		return in("CALL", "0x%03X", nnn)
+	case 0x3:
+		return in("SE", "V%X, 0x%02X", x, kk)
+	case 0x4:
+		return in("SNE", "V%X, 0x%02X", x, kk)
+	case 0x5:
+		return in("SE", "V%X, V%X", x, y)
+	case 0x6:
+		return in("LD", "V%X, 0x%02X", x, kk)
+	case 0x7:
+		return in("ADD", "V%X, 0x%02X", x, kk)
+	case 0x8:
+		switch n {
+		case 0x0:
+			return in("LD", "V%X, V%X", x, y)
+		case 0x1:
+			return in("OR", "V%X, V%X", x, y)
+		case 0x2:
+			return in("AND", "V%X, V%X", x, y)
+		case 0x3:
+			return in("XOR", "V%X, V%X", x, y)
+		case 0x4:
+			return in("ADD", "V%X, V%X", x, y)
+		case 0x5:
+			return in("SUB", "V%X, V%X", x, y)
+		case 0x6:
+			return in("SHR", "V%X, V%X", x, y)
+		case 0x7:
+			return in("SUBN", "V%X, V%X", x, y)
+		case 0xE:
+			return in("SHL", "V%X, V%X", x, y)
+		}
+		return unknown
+	case 0x9:
+		return in("SNE", "V%X, V%X", x, y)
+	case 0xA:
+		return in("LD", "I, 0x%03X", nnn)
+	case 0xB:
+		return in("JP", "V0, 0x%03X", nnn)
+	case 0xC:
+		return in("RND", "V%X, 0x%02X", x, kk)
+	case 0xD:
+		return in("DRW", "V%X, V%X, %d", x, y, n)
+	case 0xE:
+		switch y {
+		case 0x9:
+			return in("SKP", "V%X", x)
+		case 0xA:
+			return in("SKNP", "V%X", x)
+		}
+		return unknown
+	case 0xF:
+		switch kk {
+		case 0x07:
+			return in("LD", "V%X, DT", x)
+		case 0x0A:
+			return in("LD", "V%X, K", x)
+		case 0x15:
+			return in("LD", "DT, V%X", x)
+		case 0x18:
+			return in("LD", "ST, V%X", x)
+		case 0x1E:
+			return in("ADD", "I, V%X", x)
+		case 0x29:
+			return in("LD", "F, V%X", x)
+		case 0x33:
+			return in("LD", "B, V%X", x)
+		case 0x55:
+			return in("LD", "[I], V%X", x)
+		case 0x65:
+			return in("LD", "V%X, [I]", x)
+		}
+		return unknown
+	}
+
+	return unknown
+}
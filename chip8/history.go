@@ -0,0 +1,424 @@
+package chip8
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Reverse execution. With history on the vm snapshots itself every
+// HistoryInterval instructions and logs the inputs which arrive in
+// between (key changes and timer ticks). That's all it takes to get
+// back to any instruction since the oldest snapshot: restore the
+// snapshot before it and execute forward, feeding the inputs back in.
+//
+// Going back and then executing normally again starts a new future,
+// the recorded one is dropped. So does changing the vm from outside
+// (LoadROM, Reset, SetState, WriteMemory, SetConfig): history then
+// starts over from there.
+
+// HistoryInterval is how many instructions apart snapshots are taken
+const HistoryInterval = 1000
+
+// HistorySnapshots is how many snapshots are kept, the oldest
+// go first. With HistoryInterval that's 600,000 instructions back,
+// nearly an hour at the default speed.
+const HistorySnapshots = 600
+
+// ErrHistoryStart is what going back stops with when the oldest
+// recorded instruction is reached first
+var ErrHistoryStart = errors.New("reached the start of the recorded history")
+
+// ErrNoHistory is returned going back without history, see RecordHistory
+var ErrNoHistory = errors.New("history is not being recorded")
+
+// HistoryRange is the stretch of instructions the vm can go back and
+// forth in, counted as in StepEvent.Index
+type HistoryRange struct {
+	// Oldest instruction the vm can go back to
+	Oldest uint64
+	// Now is the instruction the vm is about to execute
+	Now uint64
+	// End is how far execution got, Now is behind it after going back
+	End uint64
+}
+
+// snapshot is everything execution depends on
+type snapshot struct {
+	steps, frames uint64
+
+	cpu    CPU
+	memory Memory
+	screen Screen
+	keypad Keypad
+	rng    rng
+
+	waitingForKey  bool
+	keyWaitPresses uint64
+
+	// how many inputs were logged before it, counting dropped ones;
+	// inputs for its instruction can come after it, e.g. on LoadROM
+	events uint64
+}
+
+// historyEvent is an input which arrived once instruction at-1 ran
+type historyEvent struct {
+	at uint64
+
+	// a timer tick, or otherwise a key going up or down
+	tick bool
+	key  byte
+	down bool
+}
+
+type history struct {
+	// ring of snapshots, oldest at first
+	snapshots []snapshot
+	first     int
+	count     int
+
+	events []historyEvent
+	end    uint64
+
+	// inputs forgotten along with the oldest snapshots
+	dropped uint64
+
+	// set while re-executing, so nothing gets recorded twice
+	replaying bool
+}
+
+func (h *history) snapshot(i int) *snapshot {
+	return &h.snapshots[(h.first+i)%len(h.snapshots)]
+}
+
+func (h *history) oldest() uint64 {
+	return h.snapshot(0).steps
+}
+
+// before returns the index of the last snapshot at or before n, -1 if none is
+func (h *history) before(n uint64) int {
+	return sort.Search(h.count, func(i int) bool { return h.snapshot(i).steps > n }) - 1
+}
+
+// RecordHistory turns recording for reverse execution on or off,
+// it's off by default to save the memory. Turning it on starts
+// recording from the current instruction.
+func (vm *VM) RecordHistory(on bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if !on {
+		vm.history = nil
+		return
+	}
+	vm.history = &history{snapshots: make([]snapshot, HistorySnapshots)}
+	vm.restartHistory()
+}
+
+// History returns how far back and forth the vm can go
+func (vm *VM) History() (HistoryRange, error) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	h := vm.history
+	if h == nil {
+		return HistoryRange{}, ErrNoHistory
+	}
+	return HistoryRange{Oldest: h.oldest(), Now: vm.steps, End: h.end}, nil
+}
+
+// StepBack undoes the last instruction
+func (vm *VM) StepBack() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if vm.history == nil {
+		return ErrNoHistory
+	}
+	if vm.steps == 0 || vm.steps <= vm.history.oldest() {
+		return ErrHistoryStart
+	}
+	return vm.seek(vm.steps - 1)
+}
+
+// StepBackFrame goes back to the start of the current 60 Hz frame,
+// or of the one before when already at the start of one
+func (vm *VM) StepBackFrame() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	h := vm.history
+	if h == nil {
+		return ErrNoHistory
+	}
+
+	// the latest tick strictly before now
+	for i := len(h.events) - 1; i >= 0; i-- {
+		if e := h.events[i]; e.tick && e.at < vm.steps {
+			return vm.seek(e.at)
+		}
+	}
+
+	if vm.steps <= h.oldest() {
+		return ErrHistoryStart
+	}
+	return vm.seek(h.oldest())
+}
+
+// Goto takes the vm back, or forward again, to instruction n,
+// anywhere in its HistoryRange
+func (vm *VM) Goto(n uint64) error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	h := vm.history
+	if h == nil {
+		return ErrNoHistory
+	}
+	if n < h.oldest() {
+		return fmt.Errorf("%w: instruction %d is gone, history starts at %d", ErrHistoryStart, n, h.oldest())
+	}
+	if n > h.end {
+		return fmt.Errorf("instruction %d hasn't run yet, history ends at %d", n, h.end)
+	}
+	return vm.seek(n)
+}
+
+// ReverseContinue runs backwards to the last instruction before now
+// which would have stopped the vm on a breakpoint or watchpoint. It
+// returns the *BreakpointHit or *WatchpointHit it stopped at, or
+// ErrHistoryStart having found none. Execution watchpoints and
+// breakpoints stop before their instruction, reads and writes right
+// before the instruction making them, so stepping shows the access.
+func (vm *VM) ReverseContinue() error {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	h := vm.history
+	if h == nil {
+		return ErrNoHistory
+	}
+
+	now := vm.steps
+	for i := h.before(now); i >= 0; i-- {
+		// stretches between snapshots, latest first
+		from := h.snapshot(i)
+		if from.steps >= now {
+			continue
+		}
+		to := now
+		if i+1 < h.count && h.snapshot(i+1).steps < now {
+			to = h.snapshot(i + 1).steps
+		}
+
+		var last uint64
+		var lastHit error
+		vm.restore(from)
+		if err := vm.replay(from, to, func(at uint64, hit error) {
+			last, lastHit = at, hit
+		}); err != nil {
+			return err
+		}
+
+		if lastHit != nil {
+			if err := vm.seek(last); err != nil {
+				return err
+			}
+			// resuming runs the instruction instead of stopping again
+			vm.resumeExecAt = int(vm.cpu.programCounter)
+			return lastHit
+		}
+	}
+
+	if err := vm.seek(h.oldest()); err != nil {
+		return err
+	}
+	return ErrHistoryStart
+}
+
+// restartHistory forgets the recorded past, starting over from now
+func (vm *VM) restartHistory() {
+	h := vm.history
+	if h == nil {
+		return
+	}
+
+	h.first, h.count = 0, 0
+	h.events = h.events[:0]
+	h.dropped = 0
+	h.end = vm.steps
+	vm.takeSnapshot()
+}
+
+// takeSnapshot adds the current state to the ring
+func (vm *VM) takeSnapshot() {
+	h := vm.history
+
+	if h.count == len(h.snapshots) {
+		// forget the oldest, and the inputs only it needed
+		h.first = (h.first + 1) % len(h.snapshots)
+		h.count--
+
+		n := h.snapshot(0).events - h.dropped
+		h.events = h.events[n:]
+		h.dropped += n
+	}
+
+	*h.snapshot(h.count) = snapshot{
+		steps:          vm.steps,
+		frames:         vm.frames,
+		cpu:            *vm.cpu,
+		memory:         *vm.memory,
+		screen:         *vm.screen,
+		keypad:         *vm.keypad,
+		rng:            vm.rng,
+		waitingForKey:  vm.waitingForKey,
+		keyWaitPresses: vm.keyWaitPresses,
+		events:         h.dropped + uint64(len(h.events)),
+	}
+	h.count++
+}
+
+func (vm *VM) restore(s *snapshot) {
+	vm.steps = s.steps
+	vm.frames = s.frames
+	*vm.cpu = s.cpu
+	*vm.memory = s.memory
+	vm.flushBlocks()
+	*vm.screen = s.screen
+	*vm.keypad = s.keypad
+	vm.rng = s.rng
+	vm.waitingForKey = s.waitingForKey
+	vm.keyWaitPresses = s.keyWaitPresses
+	vm.resumeExecAt = -1
+}
+
+// diverge drops the recorded future once the vm, having
+// gone back, executes or gets input for real again
+func (vm *VM) diverge() {
+	h := vm.history
+	if vm.steps >= h.end {
+		return
+	}
+
+	for h.count > 1 && h.snapshot(h.count-1).steps > vm.steps {
+		h.count--
+	}
+	n := sort.Search(len(h.events), func(i int) bool { return h.events[i].at > vm.steps })
+	h.events = h.events[:n]
+	h.end = vm.steps
+}
+
+// recordStep is called as an instruction is about to run for real
+func (vm *VM) recordStep() {
+	h := vm.history
+	vm.diverge()
+
+	if vm.steps%HistoryInterval == 0 && h.snapshot(h.count-1).steps != vm.steps {
+		vm.takeSnapshot()
+	}
+}
+
+// recordInput logs a tick or key change happening for real
+func (vm *VM) recordInput(e historyEvent) {
+	vm.diverge()
+
+	e.at = vm.steps
+	vm.history.events = append(vm.history.events, e)
+}
+
+// tickTimers is the 60 Hz timer tick, recorded for replays
+func (vm *VM) tickTimers() {
+	if vm.history != nil && !vm.history.replaying {
+		vm.recordInput(historyEvent{tick: true})
+	}
+
+	vm.cpu.StepTimers()
+	vm.frames++
+}
+
+// seek rebuilds the state at instruction n from the snapshot before it
+func (vm *VM) seek(n uint64) error {
+	h := vm.history
+
+	i := h.before(n)
+	if i < 0 {
+		return ErrHistoryStart
+	}
+	vm.restore(h.snapshot(i))
+	return vm.replay(h.snapshot(i), n, nil)
+}
+
+// replay executes from the restored snapshot from up to instruction n
+// with the inputs logged after it. Breakpoints don't stop it, and tracers and
+// access counters don't see it. hits, when set, is told about every
+// instruction which would have stopped the vm, and why.
+func (vm *VM) replay(from *snapshot, n uint64, hits func(at uint64, hit error)) error {
+	h := vm.history
+	h.replaying = true
+
+	tracers, tracking, watchpoints := vm.tracers, vm.tracking, vm.watchpoints
+	vm.tracers, vm.tracking = nil, nil
+	if hits == nil {
+		vm.watchpoints = nil
+	}
+	defer func() {
+		h.replaying = false
+		vm.tracers, vm.tracking, vm.watchpoints = tracers, tracking, watchpoints
+	}()
+
+	next := int(from.events - h.dropped)
+	feed := func() {
+		for ; next < len(h.events) && h.events[next].at <= vm.steps; next++ {
+			if e := h.events[next]; e.tick {
+				vm.tickTimers()
+			} else {
+				vm.keypad.setKey(e.key, e.down)
+			}
+		}
+	}
+
+	feed()
+	for vm.steps < n {
+		at, pc := vm.steps, vm.cpu.programCounter
+		if hits != nil {
+			if vm.breakpoints[pc] {
+				hits(at, &BreakpointHit{PC: pc})
+			} else if hit := vm.execWatchpoint(pc); hit != nil {
+				hits(at, hit)
+			}
+		}
+
+		vm.resumeExecAt = int(pc)
+		err := vm.step()
+
+		var hit *WatchpointHit
+		if errors.As(err, &hit) {
+			if hits != nil {
+				hits(at, hit)
+			}
+		} else if err != nil {
+			return fmt.Errorf("replaying instruction %d: %w", at, err)
+		}
+		feed()
+	}
+
+	vm.resumeExecAt = -1
+	return nil
+}
+
+// execWatchpoint returns the hit executing at pc would trip
+func (vm *VM) execWatchpoint(pc uint16) *WatchpointHit {
+	if len(vm.watchpoints) == 0 {
+		return nil
+	}
+
+	opcode := uint16(vm.memory.ram[pc&RAMEndAddr])<<8 | uint16(vm.memory.ram[(pc+1)&RAMEndAddr])
+	vm.watchHit = nil
+	vm.watch(pc, AccessExec, byte(opcode>>8))
+	vm.watch(pc+1, AccessExec, byte(opcode))
+
+	hit := vm.watchHit
+	vm.watchHit = nil
+	return hit
+}
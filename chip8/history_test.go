@@ -0,0 +1,75 @@
+package chip8
+
+import (
+	"testing"
+)
+
+// 0x200: LD V5, 5; SKNP V5; LD V1, 1; JP 0x206
+var historyKeyRom = []byte{0x65, 0x05, 0xE5, 0xA1, 0x61, 0x01, 0x12, 0x06}
+
+func TestHistoryReplaysInputAtSnapshot(t *testing.T) {
+	vm := New(Config{})
+	vm.RecordHistory(true)
+	if err := vm.LoadROM(historyKeyRom); err != nil {
+		t.Fatal(err)
+	}
+
+	// pressed before the first instruction, the step the snapshot is of
+	vm.SetKey(5, true)
+	for i := 0; i < 5; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v1 := vm.State().V[1]; v1 != 1 {
+		t.Fatalf("running: V1 is %d, want 1", v1)
+	}
+
+	if err := vm.Goto(4); err != nil {
+		t.Fatal(err)
+	}
+	if v1 := vm.State().V[1]; v1 != 1 {
+		t.Errorf("after going back: V1 is %d, want 1", v1)
+	}
+}
+
+func TestHistoryReplaysAcrossSnapshots(t *testing.T) {
+	// 0x200: LD V5, 5; 0x202: LD V1, 0; SKNP V5; LD V1, 1; JP 0x202
+	rom := []byte{0x65, 0x05, 0x61, 0x00, 0xE5, 0xA1, 0x61, 0x01, 0x12, 0x02}
+
+	vm := New(Config{})
+	vm.RecordHistory(true)
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+
+	// long enough for the oldest snapshots and their inputs to go,
+	// with key changes right on snapshots and in between
+	steps := (HistorySnapshots + 50) * HistoryInterval
+	v1 := make([]byte, steps+1)
+	for i := 0; i < steps; i++ {
+		if i%HistoryInterval == 0 || i%HistoryInterval == 333 {
+			vm.SetKey(5, i%(3*HistoryInterval) < HistoryInterval)
+		}
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+		v1[i+1] = vm.State().V[1]
+	}
+
+	r, err := vm.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Oldest == 0 {
+		t.Fatal("the oldest snapshot was never dropped")
+	}
+	for n := r.End; n >= r.Oldest && n <= r.End; n -= 997 {
+		if err := vm.Goto(n); err != nil {
+			t.Fatal(err)
+		}
+		if got := vm.State().V[1]; got != v1[n] {
+			t.Fatalf("at %d: V1 is %d, want %d", n, got, v1[n])
+		}
+	}
+}
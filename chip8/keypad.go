@@ -0,0 +1,34 @@
+package chip8
+
+// Keypad holds the state of the 16 key hex keypad,
+// as fed by the frontend through VM.SetKey
+type Keypad struct {
+	// pressed state per key, 0x0-0xF
+	keys [16]bool
+
+	// last key to go down and a running count of key presses,
+	// used by Fx0A to notice a new press
+	lastPressed byte
+	presses     uint64
+}
+
+func newKeypad() *Keypad {
+	return &Keypad{}
+}
+
+func (k *Keypad) setKey(chip8Key byte, down bool) {
+	if chip8Key > 0xF {
+		return
+	}
+
+	if down && !k.keys[chip8Key] {
+		k.lastPressed = chip8Key
+		k.presses++
+	}
+
+	k.keys[chip8Key] = down
+}
+
+func (k *Keypad) isPressed(chip8Key byte) bool {
+	return k.keys[chip8Key&0xF]
+}
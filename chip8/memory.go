@@ -1,10 +1,7 @@
-package main
+package chip8
 
 import (
-	"bytes"
 	log "github.com/sirupsen/logrus"
-	"io"
-	"os"
 )
 
 // Memory util constants
@@ -41,6 +38,14 @@ var chip8Fontset = [80]byte{
 	0xF0, 0x80, 0xF0, 0x80, 0xF0, // E
 	0xF0, 0x80, 0xF0, 0x80, 0x80} // F
 
+// DigitSprite returns the 4x5 font sprite for the hex digit d,
+// one byte per row with the pixels in the high nibble
+func DigitSprite(d byte) [5]byte {
+	var sprite [5]byte
+	copy(sprite[:], chip8Fontset[int(d&0xF)*5:])
+	return sprite
+}
+
 // Memory module contains the RAM
 type Memory struct {
 	ram     [RAMSize]byte
@@ -54,35 +59,18 @@ func newMemory() *Memory {
 	return m
 }
 
-// LoadRomFile ...
-func (m *Memory) LoadRomFile(romFilePath string) {
-
-	// verify valid, readable file
-	f, err := os.Open(romFilePath)
-	if err != nil {
-		log.Fatalf("Not able to load the rom file: %v", err)
-		return
-	}
-
-	defer f.Close()
-
-	buf := bytes.NewBuffer(nil)
-	_, err = io.Copy(buf, f)
-
-	if err != nil {
-		log.Infof("Not able to read rom data into buffer")
-	}
+// LoadRom maps the rom data into the program area
+func (m *Memory) LoadRom(rom []byte) {
 
 	// Directly map the rom data at 0x200 in the memory.ram buffer
 	// and init the PC with 0x200 val
 	// This emulates the way the actual implementation works..
 	// The 0x0-0x1FF range is for actual CHIP-8 emulator logic.
-	copy(m.ram[ProgramAreaStart:], buf.Bytes()[:])
+	copy(m.ram[ProgramAreaStart:], rom)
 
-	m.romSize = buf.Len() // expressed as num of bytes
+	m.romSize = len(rom) // expressed as num of bytes
 
 	log.Infof("Rom buffer size is: %d", m.romSize)
-	log.Infoln("Successfully copied rom file into ram buffer")
 }
 
 // copy font-set into RAM
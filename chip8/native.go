@@ -0,0 +1,183 @@
+package chip8
+
+import (
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Ahead of time compiled roms. The transpile command turns a rom into
+// Go source, a NativeProgram running the rom's instructions as Go code
+// against a Runtime, which registers itself with RegisterNative. A vm
+// with the program's rom loaded, under the same quirks, then runs the
+// program instead of interpreting the rom, as long as nothing needs to
+// see single instructions (the same conditions as the recompiler).
+//
+// Whatever the transpiler didn't find as code is interpreted, and a rom
+// writing over its own code is interpreted from then on, until it's reset.
+
+// NativeProgram is a rom compiled to Go by the transpile command
+type NativeProgram struct {
+	// Name is the rom's file name
+	Name   string
+	Rom    []byte
+	Quirks Quirks
+
+	// Code holds the addresses of the compiled instructions
+	Code []uint16
+
+	// Run executes instructions from r.PC, no more than budget, and returns
+	// how many it executed. It stops early at an address it has no code for,
+	// at a return or call the stack can't take, or once r.Modified is set.
+	Run func(r *Runtime, budget int) int
+}
+
+// native programs by rom hash
+var natives = map[string]*NativeProgram{}
+
+// RegisterNative makes p run in place of the interpreter for its rom
+func RegisterNative(p *NativeProgram) {
+	natives[RomHash(p.Rom)] = p
+}
+
+// NativePrograms returns the registered native programs by name
+func NativePrograms() []*NativeProgram {
+	var programs []*NativeProgram
+	for _, p := range natives {
+		programs = append(programs, p)
+	}
+	sort.Slice(programs, func(i, j int) bool { return programs[i].Name < programs[j].Name })
+	return programs
+}
+
+// Runtime is what native programs run on: the registers, copied in and
+// out of the vm's cpu around each run, and the vm's memory and display
+type Runtime struct {
+	V      [16]byte
+	I      uint16
+	PC     uint16
+	SP     byte
+	Stack  [16]uint16
+	DT, ST byte
+
+	// RAM is the vm's memory, stored to through Store
+	RAM *[RAMSize]byte
+
+	// Modified is set once the program wrote over its own code
+	Modified bool
+
+	vm *VM
+}
+
+type nativeState struct {
+	program *NativeProgram
+	runtime Runtime
+
+	// the bytes of compiled instructions
+	code [RAMSize]bool
+}
+
+// Store writes v to ram at addr
+func (r *Runtime) Store(addr uint16, v byte) {
+	addr &= RAMEndAddr
+	r.RAM[addr] = v
+	r.vm.invalidateCode(addr)
+}
+
+// Clear clears the display
+func (r *Runtime) Clear() {
+	r.vm.screen.clearDisplay()
+}
+
+// Draw draws the n byte sprite at I at (Vx, Vy), setting VF on collision
+func (r *Runtime) Draw(x, y, n uint8) {
+	cpu := r.vm.cpu
+	cpu.register = r.V
+	cpu.registerI = r.I
+	r.vm.drw(x, y, n)
+	r.V[0xF] = cpu.register[0xF]
+}
+
+// Pressed reports whether key is down
+func (r *Runtime) Pressed(key byte) bool {
+	return r.vm.keypad.isPressed(key)
+}
+
+// WaitKey puts the key pressed since Fx0A started waiting in Vx,
+// false means it's still waiting and Fx0A has to run again
+func (r *Runtime) WaitKey(x uint8) bool {
+	cpu := r.vm.cpu
+	cpu.register = r.V
+	cpu.programCounter = r.PC
+	r.vm.ld_key(x)
+	r.V[x] = cpu.register[x]
+	return cpu.programCounter != r.PC
+}
+
+// Random returns the next random byte
+func (r *Runtime) Random() byte {
+	return r.vm.rng.nextByte()
+}
+
+// RunNative turns running the rom's native program, when one is
+// registered, on or off; it's on by default
+func (vm *VM) RunNative(on bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.nativeOff = !on
+}
+
+// lookupNative sets up the native program registered for rom, if any
+func (vm *VM) lookupNative(rom []byte) {
+	vm.native = nil
+	p, ok := natives[RomHash(rom)]
+	if !ok {
+		return
+	}
+
+	n := &nativeState{program: p}
+	n.runtime.vm = vm
+	for _, addr := range p.Code {
+		n.code[addr&RAMEndAddr] = true
+		n.code[(addr+1)&RAMEndAddr] = true
+	}
+	vm.native = n
+	log.Infof("Running %s compiled ahead of time", p.Name)
+}
+
+// runningNative reports whether RunFrame can run the native program
+func (vm *VM) runningNative() bool {
+	n := vm.native
+	return n != nil && !vm.nativeOff && !n.runtime.Modified && n.program.Quirks == vm.config.Quirks &&
+		len(vm.tracers) == 0 && len(vm.watchpoints) == 0 &&
+		len(vm.breakpoints) == 0 && vm.tracking == nil && vm.history == nil
+}
+
+// runNative runs the native program for no more than budget
+// instructions, and returns how many were executed
+func (vm *VM) runNative(budget int) (int, error) {
+	n := vm.native
+	r := &n.runtime
+	cpu := vm.cpu
+
+	r.V, r.I, r.PC, r.SP, r.Stack = cpu.register, cpu.registerI, cpu.programCounter, cpu.stackPointer, cpu.stack
+	r.DT, r.ST = cpu.delay, cpu.sound
+	r.RAM = &vm.memory.ram
+	vm.resumeExecAt = -1
+
+	executed := n.program.Run(r, budget)
+
+	cpu.register, cpu.registerI, cpu.programCounter, cpu.stackPointer, cpu.stack = r.V, r.I, r.PC, r.SP, r.Stack
+	cpu.delay, cpu.sound = r.DT, r.ST
+	vm.steps += uint64(executed)
+
+	if r.Modified {
+		log.Warnf("%s writes over its own code, interpreting it from here on", n.program.Name)
+	}
+	if executed == 0 {
+		// no code compiled here
+		return 1, vm.step()
+	}
+	return executed, nil
+}
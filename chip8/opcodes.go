@@ -1,11 +1,9 @@
-package main
+package chip8
 
 import (
-	"time"
+	"fmt"
 
 	log "github.com/sirupsen/logrus"
-
-	"golang.org/x/mobile/event/key"
 )
 
 // Contains CHIP-8 instruction set of 36 instructions
@@ -31,21 +29,26 @@ func (vm *VM) cls() {
 	scr := vm.screen
 	log.Debug("Clearing display")
 	scr.clearDisplay()
-	BufferToScreen(scr)
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 00EE - RET
 // Return from sub-routine
-func (vm *VM) ret() {
+func (vm *VM) ret() error {
 
 	cpu := vm.cpu
-	log.Debugf("Returning from sub-routine: PC: %d and SP: %d", cpu.programCounter, cpu.stackPointer)
+	if cpu.stackPointer == 0 {
+		return fmt.Errorf("%w: RET at %s", ErrStackUnderflow, vm.describe(cpu.programCounter))
+	}
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("RET from %s to %s, SP: %d", vm.describe(cpu.programCounter), vm.describe(cpu.stack[cpu.stackPointer-1]), cpu.stackPointer)
+	}
 	cpu.stackPointer--
 	cpu.programCounter = cpu.stack[cpu.stackPointer]
 
-	vm.IncrementPC()
+	vm.incrementPC()
+	return nil
 }
 
 // 1nnn - JP addr
@@ -53,23 +56,31 @@ func (vm *VM) ret() {
 func (vm *VM) jp(nnn uint16) {
 	// @discuss: should we validate the addr before setting it?
 	cpu := vm.cpu
-	log.Debugf("JMP to addr %d and PC: %d and SP: %d", nnn, cpu.programCounter, cpu.stackPointer)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("JMP to %s from %s, SP: %d", vm.describe(nnn), vm.describe(cpu.programCounter), cpu.stackPointer)
+	}
 	cpu.programCounter = nnn
 }
 
 // 2nnn - CALL addr
 // Puts the current PC on the top of the stack. The PC is then
 // set to nnn.
-func (vm *VM) call(nnn uint16) {
+func (vm *VM) call(nnn uint16) error {
 	// should we validate the addr before setting it
 	cpu := vm.cpu
 
-	log.Debugf("CALL %d and PC: %d and SP: %d", nnn, cpu.programCounter, cpu.stackPointer)
+	if int(cpu.stackPointer) >= len(cpu.stack) {
+		return fmt.Errorf("%w: CALL %s at %s", ErrStackOverflow, vm.describe(nnn), vm.describe(cpu.programCounter))
+	}
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("CALL %s from %s, SP: %d", vm.describe(nnn), vm.describe(cpu.programCounter), cpu.stackPointer)
+	}
 
 	cpu.stack[cpu.stackPointer] = cpu.programCounter
 	cpu.stackPointer++
 
 	cpu.programCounter = nnn
+	return nil
 }
 
 // 3xkk - SE Vx, byte
@@ -78,12 +89,14 @@ func (vm *VM) se(x uint8, kk byte) {
 
 	cpu := vm.cpu
 
-	log.Debugf("SKIP NXT INS if Vx == kk, Vx: %d and kk: %d", cpu.register[x], kk)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("SKIP NXT INS if Vx == kk, Vx: %d and kk: %d", cpu.register[x], kk)
+	}
 
 	if cpu.register[x] == kk {
-		vm.SkipInstruction()
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 
 }
@@ -94,12 +107,14 @@ func (vm *VM) se_not(x uint8, kk byte) {
 
 	cpu := vm.cpu
 
-	log.Debugf("SKIP NXT INS if Vx != kk, Vx: %d and kk: %d", cpu.register[x], kk)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("SKIP NXT INS if Vx != kk, Vx: %d and kk: %d", cpu.register[x], kk)
+	}
 
 	if cpu.register[x] != kk {
-		vm.SkipInstruction()
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 }
 
@@ -108,13 +123,15 @@ func (vm *VM) se_not(x uint8, kk byte) {
 func (vm *VM) se_reg(x, y uint8) {
 	cpu := vm.cpu
 
-	log.Debugf("SKIP NXT INS if Vx == Vy, Vx: %d and kk: %d", cpu.register[x], cpu.register[y])
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("SKIP NXT INS if Vx == Vy, Vx: %d and kk: %d", cpu.register[x], cpu.register[y])
+	}
 
 	if cpu.register[x] == cpu.register[y] {
 
-		vm.SkipInstruction()
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 }
 
@@ -122,10 +139,12 @@ func (vm *VM) se_reg(x, y uint8) {
 // Set Vx = kk.
 func (vm *VM) ld(vx uint8, data byte) {
 	cpu := vm.cpu
-	log.Debugf("LD byte: %d to Vx: %d", data, vx)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("LD byte: %d to Vx: %d", data, vx)
+	}
 	cpu.register[vx] = data
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 7xkk - ADD Vx, byte
@@ -135,17 +154,19 @@ func (vm *VM) add(vx uint8, data byte) {
 	// log.Debugf("ADD-ING byte: %d to Vx: %d", data, cpu.register[vx])
 	cpu.register[vx] += data
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 8xy0 - LD Vx, Vy
 // Set Vx = Vy.
 func (vm *VM) ld_reg(vx, vy uint8) {
 	cpu := vm.cpu
-	log.Debugf("LD data from Vy: %d to Vx: %d", vy, vx)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("LD data from Vy: %d to Vx: %d", vy, vx)
+	}
 	cpu.register[vx] = cpu.register[vy]
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 8xy1 - OR Vx, Vy
@@ -155,7 +176,11 @@ func (vm *VM) or(vx, vy uint8) {
 
 	cpu.register[vx] |= cpu.register[vy]
 
-	vm.IncrementPC()
+	if vm.config.Quirks.LogicResetsVF {
+		cpu.register[0xF] = 0
+	}
+
+	vm.incrementPC()
 }
 
 // 8xy2 - AND Vx, Vy
@@ -165,7 +190,11 @@ func (vm *VM) and(vx, vy uint8) {
 
 	cpu.register[vx] &= cpu.register[vy]
 
-	vm.IncrementPC()
+	if vm.config.Quirks.LogicResetsVF {
+		cpu.register[0xF] = 0
+	}
+
+	vm.incrementPC()
 }
 
 // 8xy3 - XOR Vx, Vy
@@ -175,7 +204,11 @@ func (vm *VM) xor(vx, vy uint8) {
 
 	cpu.register[vx] ^= cpu.register[vy]
 
-	vm.IncrementPC()
+	if vm.config.Quirks.LogicResetsVF {
+		cpu.register[0xF] = 0
+	}
+
+	vm.incrementPC()
 }
 
 // 8xy4 - ADD Vx, Vy
@@ -187,38 +220,35 @@ func (vm *VM) add_reg(vx, vy uint8) {
 	cpu := vm.cpu
 	tmp := uint16(cpu.register[vx]) + uint16(cpu.register[vy])
 
-	MAX := uint16(255)
-
-	if tmp > MAX {
-		cpu.register[0xF] = 1
-	} else {
-		cpu.register[0xF] = 0
-	}
-
 	// 8bits are kept(?!) or modulo 256 happens
-	cpu.register[vx] += cpu.register[vy]
+	cpu.register[vx] = byte(tmp)
 
-	vm.IncrementPC()
+	// VF last, in case x is F
+	cpu.register[0xF] = byte(tmp >> 8)
+
+	vm.incrementPC()
 }
 
 // 8xy5 - SUB Vx, Vy
 // Set Vx = Vx - Vy, set VF = NOT borrow.
 //
-// If Vx > Vy, then VF is set to 1, otherwise 0. Then Vy is subtracted from Vx,
+// If Vx >= Vy, then VF is set to 1, otherwise 0. Then Vy is subtracted from Vx,
 // and the results stored in Vx.
 // @verify @check
 func (vm *VM) sub_reg(vx, vy uint8) {
 	cpu := vm.cpu
 
-	if cpu.register[vx] >= cpu.register[vy] {
+	noBorrow := cpu.register[vx] >= cpu.register[vy]
+	cpu.register[vx] -= cpu.register[vy]
+
+	// VF last, in case x is F
+	if noBorrow {
 		cpu.register[0xF] = 1
 	} else {
 		cpu.register[0xF] = 0
 	}
 
-	cpu.register[vx] -= cpu.register[vy]
-
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 8xy6 - SHR Vx {, Vy}
@@ -228,28 +258,36 @@ func (vm *VM) sub_reg(vx, vy uint8) {
 func (vm *VM) shr(vx, vy uint8) {
 	cpu := vm.cpu
 
-	cpu.register[0xF] = cpu.register[vx] & 1
-	cpu.register[vx] = cpu.register[vx] >> 1
+	src := cpu.register[vx]
+	if vm.config.Quirks.ShiftUsesVY {
+		src = cpu.register[vy]
+	}
+
+	// VF last, in case x is F
+	cpu.register[vx] = src >> 1
+	cpu.register[0xF] = src & 1
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 8xy7 - SUBN Vx, Vy
 // Set Vx = Vy - Vx, set VF = NOT borrow.
 
-// If Vy > Vx, then VF is set to 1, otherwise 0. Then Vx is subtracted from Vy, and the results stored in Vx.
+// If Vy >= Vx, then VF is set to 1, otherwise 0. Then Vx is subtracted from Vy, and the results stored in Vx.
 func (vm *VM) subn(x, y uint8) {
 	cpu := vm.cpu
 
-	if cpu.register[y] > cpu.register[x] {
+	noBorrow := cpu.register[y] >= cpu.register[x]
+	cpu.register[x] = cpu.register[y] - cpu.register[x]
+
+	// VF last, in case x is F
+	if noBorrow {
 		cpu.register[0xF] = 1
 	} else {
 		cpu.register[0xF] = 0
 	}
 
-	cpu.register[x] = cpu.register[y] - cpu.register[x]
-
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // 8xyE - SHL Vx {, Vy}
@@ -259,13 +297,18 @@ func (vm *VM) subn(x, y uint8) {
 func (vm *VM) shl(vx, vy uint8) {
 	cpu := vm.cpu
 
-	// set VF to MSB of VX
-	cpu.register[0xF] = cpu.register[vx] >> 7
-	// x = y << 1 OR x = x << 1: both works
+	// x = y << 1 OR x = x << 1: both works, depending on the interpreter
 	// check 8xyE notes at https://massung.github.io/CHIP-8/
-	cpu.register[vx] = cpu.register[vx] << 1
+	src := cpu.register[vx]
+	if vm.config.Quirks.ShiftUsesVY {
+		src = cpu.register[vy]
+	}
 
-	vm.IncrementPC()
+	// set VF to MSB of the source, last in case x is F
+	cpu.register[vx] = src << 1
+	cpu.register[0xF] = src >> 7
+
+	vm.incrementPC()
 }
 
 // 9xy0 - SNE Vx, Vy
@@ -274,9 +317,9 @@ func (vm *VM) sne(vx, vy uint8) {
 	cpu := vm.cpu
 
 	if cpu.register[vx] != cpu.register[vy] {
-		vm.SkipInstruction()
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 }
 
@@ -284,20 +327,26 @@ func (vm *VM) sne(vx, vy uint8) {
 // Set I = nnn.
 func (vm *VM) ld_i(addr uint16) {
 	cpu := vm.cpu
-	log.Debugf("LD: Loading addr %d into register I", addr)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("LD: Loading addr %d into register I", addr)
+	}
 	cpu.registerI = addr
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Bnnn - JP V0, addr
 // Jump to location nnn + V0.
+// With the jump quirk (SCHIP) this is Bxnn, a jump to xnn + Vx instead.
 func (vm *VM) jp_add(addr uint16) {
 	cpu := vm.cpu
 
-	cpu.programCounter = addr + uint16(cpu.register[0])
+	reg := uint16(0)
+	if vm.config.Quirks.JumpUsesVX {
+		reg = (addr >> 8) & 0xF
+	}
 
-	vm.IncrementPC()
+	cpu.programCounter = addr + uint16(cpu.register[reg])
 }
 
 // Cxkk - RND Vx, byte
@@ -306,11 +355,10 @@ func (vm *VM) jp_add(addr uint16) {
 func (vm *VM) rnd(vx uint8, kk byte) {
 	cpu := vm.cpu
 
-	// @improve: can we improve the rand here
-	// also, need to @test this
-	cpu.register[vx] = byte(RandInRange(0, 256)%0xFF) & kk
+	// per vm generator, see rand.go
+	cpu.register[vx] = vm.rng.nextByte() & kk
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Dxyn - DRW Vx, Vy, nibble
@@ -320,42 +368,61 @@ func (vm *VM) rnd(vx uint8, kk byte) {
 func (vm *VM) drw(vx, vy uint8, n uint8) {
 
 	cpu := vm.cpu
-	memory := vm.memory
 
 	x := cpu.register[vx]
 	y := cpu.register[vy]
 	height := n
 
-	log.Debugf("Drawing sprite at x: %d, y:%d", x, y)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("Drawing sprite at x: %d, y:%d", x, y)
+	}
 
-	// @refactor: Take a slice of underlying memory instead?
-	buf := make([]byte, n)
+	// read the n byte sprite starting at I, into an array
+	// so drawing doesn't allocate (n is at most 15)
+	var rows [16]byte
 	startAddr := cpu.registerI
-
-	// read N byte sprite data into buf starting from startAddr
 	for i := uint16(0); i < uint16(height); i++ {
-		buf[i] = memory.ram[startAddr+i]
+		rows[i] = vm.readRAM(startAddr + i)
 	}
 
 	scr := vm.screen
+	clip := vm.config.Quirks.ClipSprites
+
+	// the starting position always wraps, only the parts
+	// running off the edge are clipped with the clip quirk
+	startX := int(x) % EmuWidth
+	startY := int(y) % EmuHeight
 
 	// reset collision register
 	cpu.register[0xF] = 0
 
 	// display and update collision flag
 	// j for height of the buffer
-	for j := uint8(0); j < height; j++ {
+	for j := 0; j < int(height); j++ {
+
+		yLine := startY + j
+		if yLine >= EmuHeight {
+			if clip {
+				break
+			}
+			yLine %= EmuHeight
+		}
 
 		// spread each byte as 8 bits @test
-		for i := uint8(0); i < 8; i++ {
+		for i := 0; i < 8; i++ {
 
-			res := int((buf[j] >> i) & 1)
+			res := (rows[j] >> uint(8-i-1)) & 1
 
-			// wrap around if required
-			yLine := (y + j) % EmuHeight
-			xLine := (x + (8 - i - 1)) % EmuWidth
+			xLine := startX + i
+			if xLine >= EmuWidth {
+				if clip {
+					break
+				}
+				xLine %= EmuWidth
+			}
 
-			if scr.display[yLine][xLine] == 1 && res == 0 {
+			// a lit pixel getting erased is a collision
+			if scr.display[yLine][xLine] == 1 && res == 1 {
 				cpu.register[0xF] = 1
 			}
 
@@ -363,9 +430,7 @@ func (vm *VM) drw(vx, vy uint8, n uint8) {
 		}
 	}
 
-	BufferToScreen(scr)
-
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Ex9E - SKP Vx
@@ -373,17 +438,15 @@ func (vm *VM) drw(vx, vy uint8, n uint8) {
 // Checks the keyboard, and if the key corresponding to the value of Vx is currently in the down position, PC is increased by 2.
 func (vm *VM) skp(vx uint8) {
 	cpu := vm.cpu
-	k := vm.keyboard
+	k := vm.keypad
 
 	vxData := cpu.register[vx]
-	keyState := k.GetKeyState(vxData)
-
-	log.Printf("Invoking ExA1: vxData: %d and mapped key: %s", vxData, k.reverseKeyboardMap[vxData])
+	pressed := k.isPressed(vxData)
 
-	if keyState == key.DirPress {
-		vm.SkipInstruction()
+	if pressed {
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 }
 
@@ -392,17 +455,15 @@ func (vm *VM) skp(vx uint8) {
 // Checks the keyboard, and if the key corresponding to the value of Vx is currently in the up position, PC is increased by 2.
 func (vm *VM) sknp(vx uint8) {
 	cpu := vm.cpu
-	k := vm.keyboard
+	k := vm.keypad
 
 	vxData := cpu.register[vx]
-	keyState := k.GetKeyState(vxData)
+	pressed := k.isPressed(vxData)
 
-	log.Printf("Invoking ExA1: vxData: %d and mapped key: %s", vxData, k.reverseKeyboardMap[vxData])
-
-	if keyState != key.DirPress {
-		vm.SkipInstruction()
+	if !pressed {
+		vm.skipInstruction()
 	} else {
-		vm.IncrementPC()
+		vm.incrementPC()
 	}
 }
 
@@ -413,44 +474,36 @@ func (vm *VM) ld_dt_in_vx(vx uint8) {
 
 	cpu.register[vx] = cpu.delay
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx0A - LD Vx, K
 // Wait for a key press, store the value of the key in Vx.
 // All execution stops until a key is pressed, then the value of that key is stored in Vx.
 func (vm *VM) ld_key(vx uint8) {
-	currTime := time.Now()
-
-	k := vm.keyboard
+	k := vm.keypad
 
-	var pressedKeyCode key.Code
-
-	log.Debugf("BEFORE: LastPressedKeyTime: %t and currTime: %t", k.lastPressedKey.time, currTime)
+	if !vm.waitingForKey {
+		vm.waitingForKey = true
+		vm.keyWaitPresses = k.presses
+		log.Debug("Waiting for key press")
+	}
 
-	// Blocked until we get a correct input
-	for {
-		if k.lastPressedKey.time.After(currTime) {
-			pressedKeyCode = k.lastPressedKey.code
-			break
-		}
-		// add 1 cycle sleep b/w polling
-		time.Sleep(CPUTickerSpeed)
-		// TODO: in future, can this be an event?
+	if k.presses == vm.keyWaitPresses {
+		// PC is left untouched so this instruction runs again
+		// on the next step, rather than blocking the cpu loop
+		return
 	}
 
-	log.Debugf("AFTER: LastPressedKeyTime: %t and currTime: %t", k.lastPressedKey.time, currTime)
+	if log.IsLevelEnabled(log.DebugLevel) {
+		log.Debugf("Got key press: %d", k.lastPressed)
+	}
+	vm.waitingForKey = false
 
 	cpu := vm.cpu
+	cpu.register[vx] = k.lastPressed
 
-	val, _ := k.keyboardMap[pressedKeyCode]
-
-	// reset pressed key
-	k.keyboardState[pressedKeyCode] = key.DirRelease
-
-	cpu.register[vx] = val
-
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx15 - LD DT, Vx
@@ -460,7 +513,7 @@ func (vm *VM) ld_dt(vx uint8) {
 
 	cpu.delay = cpu.register[vx]
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx18 - LD ST, Vx
@@ -470,7 +523,7 @@ func (vm *VM) ld_st(vx uint8) {
 
 	cpu.sound = cpu.register[vx]
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx1E - ADD I, Vx
@@ -481,7 +534,7 @@ func (vm *VM) add_i(vx uint8) {
 
 	cpu.registerI = uint16(cpu.register[vx]) + cpu.registerI
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx29 - LD F, Vx
@@ -494,7 +547,7 @@ func (vm *VM) ld_font(x uint8) {
 	// offset of 5 bytes per digit, refer to chip8Fontset in memory.go
 	cpu.registerI = uint16(DigitSpriteDataStart) + uint16(0x5*digit)
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx33 - LD B, Vx
@@ -506,55 +559,57 @@ func (vm *VM) bcd_ld(x uint8) {
 	cpu := vm.cpu
 	vxData := cpu.register[x]
 
-	memory := vm.memory
-
 	I := cpu.registerI
 
-	memory.ram[I] = vxData / 100
-	memory.ram[I+1] = (vxData / 10) % 10
-	memory.ram[I+2] = vxData % 10
+	vm.writeRAM(I, vxData/100)
+	vm.writeRAM(I+1, (vxData/10)%10)
+	vm.writeRAM(I+2, vxData%10)
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx55 - LD [I], Vx
 // Store registers V0 through Vx in memory starting at location I.
 func (vm *VM) ld_i_to_vx(vx uint8) {
 	cpu := vm.cpu
-	memory := vm.memory
 
 	for reg := uint8(0); reg <= vx; reg++ {
-		// reading each byte into the register
-		memory.ram[cpu.registerI+uint16(reg)] = cpu.register[reg]
+		// writing each register out to ram
+		vm.writeRAM(cpu.registerI+uint16(reg), cpu.register[reg])
+	}
+
+	if vm.config.Quirks.LoadStoreIncrementsI {
+		cpu.registerI += uint16(vx) + 1
 	}
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
 // Fx65 - LD Vx, [I]
 // Read registers V0 through Vx from memory starting at location I.
 func (vm *VM) ld_vx(vx uint8) {
 	cpu := vm.cpu
-	memory := vm.memory
 	addr := cpu.registerI
 
 	for i := uint16(0); i <= uint16(vx); i++ {
 		// reading each byte into the register
-		cpu.register[i] = memory.ram[addr+i]
+		cpu.register[i] = vm.readRAM(addr + i)
 	}
 
-	// cpu.registerI += uint16(vx) + 1
+	if vm.config.Quirks.LoadStoreIncrementsI {
+		cpu.registerI += uint16(vx) + 1
+	}
 
-	vm.IncrementPC()
+	vm.incrementPC()
 }
 
-// IncrementPC makes PC point to next instruction
-func (vm *VM) IncrementPC() {
+// incrementPC makes PC point to next instruction
+func (vm *VM) incrementPC() {
 	cpu := vm.cpu
 	cpu.programCounter += uint16(2)
 }
 
-func (vm *VM) SkipInstruction() {
+func (vm *VM) skipInstruction() {
 	// skipping two because the instruction is of 2
 	// bytes size i.e. incrementing program counter by 2
 	cpu := vm.cpu
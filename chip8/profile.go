@@ -0,0 +1,276 @@
+package chip8
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/pprof/profile"
+)
+
+// Profiler is a Tracer counting where a program spends its
+// instructions: per address, per opcode class and per call stack.
+// WriteProfile turns the counts into a pprof profile, so
+//
+//	go tool pprof -http : rom.pb.gz
+//
+// shows subroutine flame graphs and hot spots of the rom.
+// Subroutines are named after their entry point, e.g. sub_2A0,
+// the code reached from the rom entry point is "start".
+type Profiler struct {
+	mu sync.Mutex
+
+	romName string
+
+	total   uint64
+	byPC    [RAMSize]uint64
+	byClass map[string]uint64
+
+	// call site -> subroutine it called, learnt from executed CALLs
+	callTargets map[uint16]uint16
+
+	samples map[string]*profileSample
+	key     []byte
+}
+
+// profileSample counts instructions run under one call stack
+type profileSample struct {
+	// pc and function entry per frame, innermost first
+	pcs   []uint16
+	funcs []uint16
+	class string
+	count uint64
+}
+
+// Subroutine entry standing in for call sites the profiler never saw execute
+const unknownSubroutine = 0xFFFF
+
+// NewProfiler returns an empty profile, romName ends up as its file name
+func NewProfiler(romName string) *Profiler {
+	return &Profiler{
+		romName:     romName,
+		byClass:     map[string]uint64{},
+		callTargets: map[uint16]uint16{},
+		samples:     map[string]*profileSample{},
+	}
+}
+
+// TraceStep counts ev, implements Tracer
+func (p *Profiler) TraceStep(ev *StepEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	class := Decode(ev.Opcode).Mnemonic
+
+	p.total++
+	p.byPC[ev.PC&RAMEndAddr]++
+	p.byClass[class]++
+
+	if ev.Opcode>>12 == 0x2 {
+		p.callTargets[ev.PC] = ev.Opcode & 0xFFF
+	}
+
+	// key the sample on its call stack, pc and class
+	key := p.key[:0]
+	for _, site := range ev.Stack {
+		key = append(key, byte(site>>8), byte(site))
+	}
+	key = append(key, byte(ev.PC>>8), byte(ev.PC))
+	key = append(key, class...)
+	p.key = key
+
+	if s, ok := p.samples[string(key)]; ok {
+		s.count++
+		return
+	}
+
+	s := &profileSample{class: class, count: 1}
+	s.pcs = append(s.pcs, ev.PC)
+	s.funcs = append(s.funcs, p.entryOf(ev.Stack, len(ev.Stack)))
+	for i := len(ev.Stack) - 1; i >= 0; i-- {
+		s.pcs = append(s.pcs, ev.Stack[i])
+		s.funcs = append(s.funcs, p.entryOf(ev.Stack, i))
+	}
+	p.samples[string(key)] = s
+}
+
+// entryOf returns the entry point of the subroutine running
+// at call depth depth, the rom entry point at depth 0
+func (p *Profiler) entryOf(stack []uint16, depth int) uint16 {
+	if depth == 0 {
+		return ProgramAreaStart
+	}
+	if target, ok := p.callTargets[stack[depth-1]]; ok {
+		return target
+	}
+	return unknownSubroutine
+}
+
+// Instructions returns the number of instructions counted
+func (p *Profiler) Instructions() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.total
+}
+
+// ProfileCount is the number of instructions counted against a name
+type ProfileCount struct {
+	Name  string
+	Count uint64
+}
+
+// Classes returns the instructions counted per opcode class, busiest first
+func (p *Profiler) Classes() []ProfileCount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var counts []ProfileCount
+	for class, n := range p.byClass {
+		counts = append(counts, ProfileCount{Name: class, Count: n})
+	}
+	sortProfileCounts(counts)
+	return counts
+}
+
+// HotSpots returns the n addresses most instructions were executed at
+func (p *Profiler) HotSpots(n int) []ProfileCount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var counts []ProfileCount
+	for pc, count := range p.byPC {
+		if count > 0 {
+			counts = append(counts, ProfileCount{Name: fmt.Sprintf("%03X", pc), Count: count})
+		}
+	}
+	sortProfileCounts(counts)
+
+	if len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+func sortProfileCounts(counts []ProfileCount) {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+}
+
+// subroutineName is what pprof calls the subroutine starting at entry
+func subroutineName(entry uint16) string {
+	switch entry {
+	case ProgramAreaStart:
+		return "start"
+	case unknownSubroutine:
+		return "sub_unknown"
+	}
+	return fmt.Sprintf("sub_%03X", entry)
+}
+
+// WriteProfile writes the counts so far to w as a gzipped pprof
+// profile. Sample values are instructions executed, every sample
+// is labelled with the "class" of its opcode (LD, DRW, ...).
+// Source lines are ram addresses.
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	mapping := &profile.Mapping{
+		ID:             1,
+		Start:          0,
+		Limit:          RAMSize,
+		File:           p.romName,
+		HasFunctions:   true,
+		HasLineNumbers: true,
+	}
+
+	prof := &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "instructions", Unit: "count"}},
+		PeriodType:        &profile.ValueType{Type: "instructions", Unit: "count"},
+		Period:            1,
+		DefaultSampleType: "instructions",
+		Mapping:           []*profile.Mapping{mapping},
+	}
+
+	functions := map[uint16]*profile.Function{}
+	function := func(entry uint16) *profile.Function {
+		if f, ok := functions[entry]; ok {
+			return f
+		}
+		name := subroutineName(entry)
+		f := &profile.Function{
+			ID:         uint64(len(prof.Function) + 1),
+			Name:       name,
+			SystemName: name,
+			Filename:   p.romName,
+			StartLine:  int64(entry),
+		}
+		functions[entry] = f
+		prof.Function = append(prof.Function, f)
+		return f
+	}
+
+	type locationKey struct{ pc, entry uint16 }
+	locations := map[locationKey]*profile.Location{}
+	location := func(pc, entry uint16) *profile.Location {
+		key := locationKey{pc, entry}
+		if loc, ok := locations[key]; ok {
+			return loc
+		}
+		loc := &profile.Location{
+			ID:      uint64(len(prof.Location) + 1),
+			Mapping: mapping,
+			Address: uint64(pc),
+			Line:    []profile.Line{{Function: function(entry), Line: int64(pc)}},
+		}
+		locations[key] = loc
+		prof.Location = append(prof.Location, loc)
+		return loc
+	}
+
+	// stable output for the same counts
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := p.samples[key]
+
+		sample := &profile.Sample{
+			Value: []int64{int64(s.count)},
+			Label: map[string][]string{"class": {s.class}},
+		}
+		for i := range s.pcs {
+			sample.Location = append(sample.Location, location(s.pcs[i], s.funcs[i]))
+		}
+		prof.Sample = append(prof.Sample, sample)
+	}
+
+	if err := prof.CheckValid(); err != nil {
+		return fmt.Errorf("building profile: %w", err)
+	}
+	return prof.Write(w)
+}
+
+// String summarises the profile, for logging
+func (p *Profiler) String() string {
+	classes := p.Classes()
+	if len(classes) > 5 {
+		classes = classes[:5]
+	}
+
+	parts := make([]string, len(classes))
+	for i, c := range classes {
+		parts[i] = fmt.Sprintf("%s %d", c.Name, c.Count)
+	}
+	return fmt.Sprintf("%d instructions (%s)", p.Instructions(), strings.Join(parts, ", "))
+}
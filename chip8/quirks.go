@@ -0,0 +1,112 @@
+package chip8
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Platforms a rom can target. The instruction set implemented here
+// is plain CHIP-8 for all of them, the platform only picks the quirks.
+const (
+	PlatformChip8  = "chip-8"
+	PlatformSChip  = "schip"
+	PlatformXOChip = "xo-chip"
+)
+
+// Quirks are the behaviours CHIP-8 interpreters historically disagree on.
+// The zero value is how this emulator has always behaved.
+type Quirks struct {
+	// 8xy6/8xyE shift Vy into Vx instead of shifting Vx in place
+	ShiftUsesVY bool `json:"shift"`
+
+	// Fx55/Fx65 leave I pointing past the last register stored/loaded
+	LoadStoreIncrementsI bool `json:"loadstore"`
+
+	// Bnnn jumps to nnn + Vx, x being the high nibble of nnn, instead of nnn + V0
+	JumpUsesVX bool `json:"jump"`
+
+	// 8xy1/8xy2/8xy3 reset VF to 0
+	LogicResetsVF bool `json:"vfreset"`
+
+	// Sprites get cut off at the screen edges instead of wrapping around
+	ClipSprites bool `json:"clip"`
+}
+
+// quirk names as used by ParseQuirks and String
+var quirkNames = map[string]func(q *Quirks) *bool{
+	"shift":     func(q *Quirks) *bool { return &q.ShiftUsesVY },
+	"loadstore": func(q *Quirks) *bool { return &q.LoadStoreIncrementsI },
+	"jump":      func(q *Quirks) *bool { return &q.JumpUsesVX },
+	"vfreset":   func(q *Quirks) *bool { return &q.LogicResetsVF },
+	"clip":      func(q *Quirks) *bool { return &q.ClipSprites },
+}
+
+var platformQuirks = map[string]Quirks{
+	// original COSMAC VIP interpreter
+	PlatformChip8: {
+		ShiftUsesVY:          true,
+		LoadStoreIncrementsI: true,
+		LogicResetsVF:        true,
+		ClipSprites:          true,
+	},
+	PlatformSChip: {
+		JumpUsesVX:  true,
+		ClipSprites: true,
+	},
+	PlatformXOChip: {
+		ShiftUsesVY:          true,
+		LoadStoreIncrementsI: true,
+	},
+}
+
+// PlatformQuirks returns the quirks a platform's interpreter has
+func PlatformQuirks(platform string) (Quirks, bool) {
+	q, ok := platformQuirks[strings.ToLower(platform)]
+	return q, ok
+}
+
+// ParseQuirks reads a comma separated list of quirk names,
+// e.g. "shift,loadstore,clip". "none" turns them all off.
+func ParseQuirks(s string) (Quirks, error) {
+	var q Quirks
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "none" {
+			continue
+		}
+
+		field, ok := quirkNames[name]
+		if !ok {
+			return q, fmt.Errorf("unknown quirk %q, expected one of %s", name, strings.Join(quirkList(), ", "))
+		}
+		*field(&q) = true
+	}
+
+	return q, nil
+}
+
+// String lists the enabled quirks in the format ParseQuirks reads
+func (q Quirks) String() string {
+	var enabled []string
+	for _, name := range quirkList() {
+		if *quirkNames[name](&q) {
+			enabled = append(enabled, name)
+		}
+	}
+
+	if len(enabled) == 0 {
+		return "none"
+	}
+	return strings.Join(enabled, ",")
+}
+
+func quirkList() []string {
+	names := make([]string, 0, len(quirkNames))
+	for name := range quirkNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,41 @@
+package chip8
+
+import "time"
+
+// rng is a small xorshift64* generator. Every vm owns one, so
+// instances in the same process never share random state and
+// two vms given the same seed produce the same numbers.
+type rng struct {
+	state uint64
+}
+
+// newRNG seeds the generator, a seed of 0 picks one off the clock
+func newRNG(seed int64) rng {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	// splitmix64 the seed so neighbouring seeds don't start out alike,
+	// and xorshift state must never be zero
+	z := uint64(seed) + 0x9E3779B97F4A7C15
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	if z == 0 {
+		z = 1
+	}
+
+	return rng{state: z}
+}
+
+func (r *rng) next() uint64 {
+	r.state ^= r.state >> 12
+	r.state ^= r.state << 25
+	r.state ^= r.state >> 27
+	return r.state * 0x2545F4914F6CDD1D
+}
+
+// nextByte returns a random number from 0 to 255
+func (r *rng) nextByte() byte {
+	return byte(r.next() >> 56)
+}
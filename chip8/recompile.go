@@ -0,0 +1,410 @@
+package chip8
+
+// Dynamic recompilation. With Recompile on, RunFrame doesn't decode
+// every instruction again each time it runs: the first time execution
+// reaches an address the basic block starting there is compiled into
+// a chain of closures, one per instruction with its operands already
+// decoded and its quirks picked, and from then on the chain runs.
+//
+// Compiled code is invalidated when the program writes over it, and
+// everything the closures don't cover (unknown opcodes, NOP) still
+// goes through the interpreter, as does code written over again and
+// again, which would only be compiled to be thrown away. So does all execution while anything
+// needs to see single instructions: tracers, breakpoints, watchpoints,
+// memory tracking and history.
+
+// longest block compiled, in instructions
+const maxBlockLength = 32
+
+// times the block at an address may be invalidated before
+// that address is left to the interpreter
+const maxInvalidations = 8
+
+// compiledOp executes one instruction. Only the last op of a block
+// moves the program counter, and only if it's a jump, call, return,
+// skip or Fx0A; the others leave it to runBlock.
+type compiledOp func(vm *VM, cpu *CPU)
+
+type compiledBlock struct {
+	start uint16
+	ops   []compiledOp
+
+	// the last op sets the program counter itself
+	branches bool
+
+	// nothing compiled, the instruction at start is interpreted
+	interpret bool
+}
+
+func (b *compiledBlock) length() int {
+	if b.interpret {
+		return 1
+	}
+	return len(b.ops)
+}
+
+type recompiler struct {
+	blocks [RAMSize]*compiledBlock
+
+	// how many compiled blocks cover each ram byte
+	cover [RAMSize]uint16
+
+	// how often the block starting at each address was invalidated
+	invalidations [RAMSize]uint8
+
+	// set when a write invalidated code, so a block
+	// writing over itself stops running its old ops
+	invalidated bool
+
+	// set by an op which can't run, a return with an empty stack
+	// or a call with a full one, for the interpreter to report
+	fault bool
+}
+
+// Recompile turns the block recompiler on or off, it's off by default
+func (vm *VM) Recompile(on bool) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	if on {
+		vm.recompiler = &recompiler{}
+	} else {
+		vm.recompiler = nil
+	}
+}
+
+// flushBlocks drops all compiled code, when ram or quirks changed
+func (vm *VM) flushBlocks() {
+	if vm.recompiler != nil {
+		vm.recompiler = &recompiler{}
+	}
+}
+
+// invalidateCode drops the compiled blocks covering addr,
+// and stops the native program if addr is in its code
+func (vm *VM) invalidateCode(addr uint16) {
+	if n := vm.native; n != nil && n.code[addr] {
+		n.runtime.Modified = true
+	}
+
+	r := vm.recompiler
+	if r == nil || r.cover[addr] == 0 {
+		return
+	}
+
+	// blocks starting up to a whole block before addr can cover it
+	first := int(addr) - 2*maxBlockLength
+	if first < 0 {
+		first = 0
+	}
+	for start := first; start <= int(addr); start++ {
+		b := r.blocks[start]
+		if b == nil || int(addr) >= start+2*b.length() {
+			continue
+		}
+
+		r.blocks[start] = nil
+		if r.invalidations[start] < maxInvalidations {
+			r.invalidations[start]++
+		}
+		for i := start; i < start+2*b.length() && i < RAMSize; i++ {
+			r.cover[i]--
+		}
+	}
+	r.invalidated = true
+}
+
+// recompiling reports whether RunFrame can run compiled blocks
+func (vm *VM) recompiling() bool {
+	return vm.recompiler != nil && len(vm.tracers) == 0 && len(vm.watchpoints) == 0 &&
+		len(vm.breakpoints) == 0 && vm.tracking == nil && vm.history == nil
+}
+
+// runBlock executes the block at the program counter, no more than
+// budget instructions of it, and returns how many it executed
+func (vm *VM) runBlock(budget int) (int, error) {
+	r := vm.recompiler
+	cpu := vm.cpu
+	pc := cpu.programCounter
+
+	if pc > RAMEndAddr-1 {
+		return 0, vm.step()
+	}
+	b := r.blocks[pc]
+	if b == nil {
+		if r.invalidations[pc] >= maxInvalidations {
+			// self modifying code, compiling it is wasted work
+			return 1, vm.step()
+		}
+		b = vm.compileBlock(pc)
+		r.blocks[pc] = b
+		for i := int(pc); i < int(pc)+2*b.length() && i < RAMSize; i++ {
+			r.cover[i]++
+		}
+	}
+	if b.interpret {
+		return 1, vm.step()
+	}
+
+	ops := b.ops
+	if len(ops) > budget {
+		ops = ops[:budget]
+	}
+	vm.resumeExecAt = -1
+
+	r.invalidated = false
+	for i, op := range ops {
+		op(vm, cpu)
+
+		if r.fault {
+			// the interpreter runs it again and returns the error
+			r.fault = false
+			cpu.programCounter = pc + uint16(2*i)
+			vm.steps += uint64(i)
+			return i + 1, vm.step()
+		}
+		if r.invalidated {
+			// the rest of the block may have been written over,
+			// writes are never the branching last op
+			r.invalidated = false
+			cpu.programCounter = pc + uint16(2*(i+1))
+			vm.steps += uint64(i + 1)
+			return i + 1, nil
+		}
+	}
+
+	if !b.branches || len(ops) < len(b.ops) {
+		cpu.programCounter = pc + uint16(2*len(ops))
+	}
+	vm.steps += uint64(len(ops))
+	return len(ops), nil
+}
+
+// compileBlock compiles the instructions from pc up to the first
+// which branches or can't be compiled
+func (vm *VM) compileBlock(pc uint16) *compiledBlock {
+	b := &compiledBlock{start: pc}
+	mem := &vm.memory.ram
+
+	for addr := pc; len(b.ops) < maxBlockLength && addr < RAMEndAddr; addr += 2 {
+		opcode := uint16(mem[addr])<<8 | uint16(mem[addr+1])
+		op, branches := vm.compileOp(addr, opcode)
+		if op == nil {
+			break
+		}
+
+		b.ops = append(b.ops, op)
+		if branches {
+			b.branches = true
+			break
+		}
+	}
+
+	b.interpret = len(b.ops) == 0
+	return b
+}
+
+// compileOp returns the closure executing opcode at pc, nil for the
+// ones left to the interpreter, and whether it sets the program counter
+func (vm *VM) compileOp(pc, opcode uint16) (compiledOp, bool) {
+	x := uint8(opcode>>8) & 0xF
+	y := uint8(opcode>>4) & 0xF
+	n := uint8(opcode) & 0xF
+	kk := byte(opcode)
+	nnn := opcode & 0xFFF
+	quirks := vm.config.Quirks
+
+	// instructions using the vm's methods, which expect
+	// the program counter at the instruction and move it on
+	method := func(f func(vm *VM)) compiledOp {
+		return func(vm *VM, cpu *CPU) {
+			cpu.programCounter = pc
+			f(vm)
+		}
+	}
+	skip := func(cond func(cpu *CPU) bool) (compiledOp, bool) {
+		return func(vm *VM, cpu *CPU) {
+			if cond(cpu) {
+				cpu.programCounter = pc + 4
+			} else {
+				cpu.programCounter = pc + 2
+			}
+		}, true
+	}
+
+	switch opcode >> 12 {
+	case 0x0:
+		switch opcode {
+		case 0x00E0:
+			return method((*VM).cls), false
+		case 0x00EE:
+			return func(vm *VM, cpu *CPU) {
+				if cpu.stackPointer == 0 {
+					vm.recompiler.fault = true
+					return
+				}
+				cpu.stackPointer--
+				cpu.programCounter = cpu.stack[cpu.stackPointer] + 2
+			}, true
+		}
+	case 0x1:
+		return func(vm *VM, cpu *CPU) { cpu.programCounter = nnn }, true
+	case 0x2:
+		return func(vm *VM, cpu *CPU) {
+			if int(cpu.stackPointer) >= len(cpu.stack) {
+				vm.recompiler.fault = true
+				return
+			}
+			cpu.stack[cpu.stackPointer] = pc
+			cpu.stackPointer++
+			cpu.programCounter = nnn
+		}, true
+	case 0x3:
+		return skip(func(cpu *CPU) bool { return cpu.register[x] == kk })
+	case 0x4:
+		return skip(func(cpu *CPU) bool { return cpu.register[x] != kk })
+	case 0x5:
+		return skip(func(cpu *CPU) bool { return cpu.register[x] == cpu.register[y] })
+	case 0x6:
+		return func(vm *VM, cpu *CPU) { cpu.register[x] = kk }, false
+	case 0x7:
+		return func(vm *VM, cpu *CPU) { cpu.register[x] += kk }, false
+	case 0x8:
+		return compileALU(x, y, n, quirks), false
+	case 0x9:
+		return skip(func(cpu *CPU) bool { return cpu.register[x] != cpu.register[y] })
+	case 0xA:
+		return func(vm *VM, cpu *CPU) { cpu.registerI = nnn }, false
+	case 0xB:
+		reg := uint8(0)
+		if quirks.JumpUsesVX {
+			reg = x
+		}
+		return func(vm *VM, cpu *CPU) { cpu.programCounter = nnn + uint16(cpu.register[reg]) }, true
+	case 0xC:
+		return func(vm *VM, cpu *CPU) { cpu.register[x] = vm.rng.nextByte() & kk }, false
+	case 0xD:
+		return method(func(vm *VM) { vm.drw(x, y, n) }), false
+	case 0xE:
+		switch kk {
+		case 0x9E:
+			return func(vm *VM, cpu *CPU) {
+				if vm.keypad.isPressed(cpu.register[x]) {
+					cpu.programCounter = pc + 4
+				} else {
+					cpu.programCounter = pc + 2
+				}
+			}, true
+		case 0xA1:
+			return func(vm *VM, cpu *CPU) {
+				if !vm.keypad.isPressed(cpu.register[x]) {
+					cpu.programCounter = pc + 4
+				} else {
+					cpu.programCounter = pc + 2
+				}
+			}, true
+		}
+	case 0xF:
+		switch kk {
+		case 0x07:
+			return func(vm *VM, cpu *CPU) { cpu.register[x] = cpu.delay }, false
+		case 0x0A:
+			// leaves the program counter alone while waiting
+			return method(func(vm *VM) { vm.ld_key(x) }), true
+		case 0x15:
+			return func(vm *VM, cpu *CPU) { cpu.delay = cpu.register[x] }, false
+		case 0x18:
+			return func(vm *VM, cpu *CPU) { cpu.sound = cpu.register[x] }, false
+		case 0x1E:
+			return func(vm *VM, cpu *CPU) { cpu.registerI += uint16(cpu.register[x]) }, false
+		case 0x29:
+			return func(vm *VM, cpu *CPU) {
+				cpu.registerI = uint16(DigitSpriteDataStart) + uint16(0x5*cpu.register[x])
+			}, false
+		case 0x33:
+			return method(func(vm *VM) { vm.bcd_ld(x) }), false
+		case 0x55:
+			return method(func(vm *VM) { vm.ld_i_to_vx(x) }), false
+		case 0x65:
+			return method(func(vm *VM) { vm.ld_vx(x) }), false
+		}
+	}
+
+	return nil, false
+}
+
+// compileALU compiles the 8xyn register arithmetic
+func compileALU(x, y, n uint8, quirks Quirks) compiledOp {
+	resetVF := quirks.LogicResetsVF
+
+	switch n {
+	case 0x0:
+		return func(vm *VM, cpu *CPU) { cpu.register[x] = cpu.register[y] }
+	case 0x1:
+		return func(vm *VM, cpu *CPU) {
+			cpu.register[x] |= cpu.register[y]
+			if resetVF {
+				cpu.register[0xF] = 0
+			}
+		}
+	case 0x2:
+		return func(vm *VM, cpu *CPU) {
+			cpu.register[x] &= cpu.register[y]
+			if resetVF {
+				cpu.register[0xF] = 0
+			}
+		}
+	case 0x3:
+		return func(vm *VM, cpu *CPU) {
+			cpu.register[x] ^= cpu.register[y]
+			if resetVF {
+				cpu.register[0xF] = 0
+			}
+		}
+	// VF is set after Vx as in the interpreter, which matters when x is F
+	case 0x4:
+		return func(vm *VM, cpu *CPU) {
+			sum := uint16(cpu.register[x]) + uint16(cpu.register[y])
+			cpu.register[x] = byte(sum)
+			cpu.register[0xF] = byte(sum >> 8)
+		}
+	case 0x5:
+		return func(vm *VM, cpu *CPU) {
+			noBorrow := cpu.register[x] >= cpu.register[y]
+			cpu.register[x] -= cpu.register[y]
+			if noBorrow {
+				cpu.register[0xF] = 1
+			} else {
+				cpu.register[0xF] = 0
+			}
+		}
+	case 0x6, 0xE:
+		src := x
+		if quirks.ShiftUsesVY {
+			src = y
+		}
+		if n == 0x6 {
+			return func(vm *VM, cpu *CPU) {
+				v := cpu.register[src]
+				cpu.register[x] = v >> 1
+				cpu.register[0xF] = v & 1
+			}
+		}
+		return func(vm *VM, cpu *CPU) {
+			v := cpu.register[src]
+			cpu.register[x] = v << 1
+			cpu.register[0xF] = v >> 7
+		}
+	case 0x7:
+		return func(vm *VM, cpu *CPU) {
+			noBorrow := cpu.register[y] >= cpu.register[x]
+			cpu.register[x] = cpu.register[y] - cpu.register[x]
+			if noBorrow {
+				cpu.register[0xF] = 1
+			} else {
+				cpu.register[0xF] = 0
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package chip8
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// selfModifyingRom patches the instruction at 0x20E, in the block it
+// runs in, to ADD V2, n with a bigger n every time round the loop, and
+// draws V2's digit. Its subroutine stores V2's decimal digits right
+// behind its own code.
+var selfModifyingRom = []byte{
+	0x60, 0x72, // 200: LD V0, 0x72
+	0x61, 0x00, // 202: LD V1, 0
+	0xA2, 0x0E, // 204: LD I, 0x20E
+	0x71, 0x01, // 206: ADD V1, 1
+	0xF1, 0x55, // 208: LD [I], V1
+	0x63, 0x08, // 20A: LD V3, 8
+	0x64, 0x04, // 20C: LD V4, 4
+	0x00, 0x00, // 20E: patched
+	0x22, 0x24, // 210: CALL 0x224
+	0xF2, 0x29, // 212: LD F, V2
+	0x00, 0xE0, // 214: CLS
+	0xD3, 0x45, // 216: DRW V3, V4, 5
+	0xA2, 0x0E, // 218: LD I, 0x20E
+	0x12, 0x06, // 21A: JP 0x206
+	0x00, 0x00, // 21C
+	0x00, 0x00, // 21E
+	0x00, 0x00, // 220
+	0x00, 0x00, // 222
+	0xA2, 0x2C, // 224: LD I, 0x22C
+	0xF2, 0x33, // 226: LD B, V2, over 22C-22E
+	0xA2, 0x0E, // 228: LD I, 0x20E
+	0x00, 0xEE, // 22A: RET
+	0x00, 0x00, // 22C: digits
+	0x00, 0x00, // 22E
+}
+
+// loopRom counts and draws without writing to ram, what the
+// recompiler is best at
+var loopRom = []byte{
+	0x70, 0x01, // 200: ADD V0, 1
+	0x81, 0x04, // 202: ADD V1, V0
+	0xF0, 0x29, // 204: LD F, V0
+	0xD0, 0x15, // 206: DRW V0, V1, 5
+	0x30, 0x00, // 208: SE V0, 0
+	0x12, 0x00, // 20A: JP 0x200
+	0x00, 0xE0, // 20C: CLS
+	0x12, 0x00, // 20E: JP 0x200
+}
+
+type vmResult struct {
+	state State
+	ram   []byte
+	fb    Framebuffer
+	err   error
+}
+
+func runFrames(t *testing.T, rom []byte, ipf, frames int, recompile bool) vmResult {
+	vm := New(Config{InstructionsPerFrame: ipf, Seed: 1})
+	if err := vm.LoadROM(rom); err != nil {
+		t.Fatal(err)
+	}
+	vm.Recompile(recompile)
+
+	var err error
+	for i := 0; i < frames && err == nil; i++ {
+		err = vm.RunFrame()
+	}
+	return vmResult{vm.State(), vm.ReadMemory(0, RAMSize), vm.Framebuffer(), err}
+}
+
+func TestRecompileMatchesInterpreter(t *testing.T) {
+	roms := []struct {
+		name string
+		rom  []byte
+	}{
+		{"self modifying", selfModifyingRom},
+		{"loop", loopRom},
+	}
+
+	for _, r := range roms {
+		// budgets cutting blocks at every point
+		for _, ipf := range []int{1, 3, 7, 100} {
+			a := runFrames(t, r.rom, ipf, 300, false)
+			b := runFrames(t, r.rom, ipf, 300, true)
+
+			if a.err != nil || b.err != nil {
+				t.Errorf("%s ipf %d: errors %v, %v", r.name, ipf, a.err, b.err)
+			}
+			if !reflect.DeepEqual(a.state, b.state) {
+				t.Errorf("%s ipf %d: state %+v, recompiled %+v", r.name, ipf, a.state, b.state)
+			}
+			if !bytes.Equal(a.ram, b.ram) {
+				t.Errorf("%s ipf %d: ram differs", r.name, ipf)
+			}
+			if a.fb != b.fb {
+				t.Errorf("%s ipf %d: framebuffer differs", r.name, ipf)
+			}
+		}
+	}
+
+	// and the patching did happen
+	if s := runFrames(t, selfModifyingRom, 100, 1, true).state; s.V[2] == 0 {
+		t.Error("self modifying rom never ran its patched instruction")
+	}
+}
+
+func TestRecompileSelfModifyingAllocs(t *testing.T) {
+	vm := New(Config{InstructionsPerFrame: 7})
+	if err := vm.LoadROM(selfModifyingRom); err != nil {
+		t.Fatal(err)
+	}
+	vm.Recompile(true)
+
+	// long enough for the patched code to be left to the interpreter
+	for i := 0; i < 100; i++ {
+		if err := vm.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n := testing.AllocsPerRun(100, func() {
+		if err := vm.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if n != 0 {
+		t.Fatalf("a frame allocates %g times, want 0", n)
+	}
+}
+
+func benchmarkRunFrame(b *testing.B, rom []byte, ipf int, recompile bool) {
+	vm := New(Config{InstructionsPerFrame: ipf})
+	if err := vm.LoadROM(rom); err != nil {
+		b.Fatal(err)
+	}
+	vm.Recompile(recompile)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := vm.RunFrame(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRunFrameInterpreted(b *testing.B) { benchmarkRunFrame(b, loopRom, 1000, false) }
+func BenchmarkRunFrameRecompiled(b *testing.B)  { benchmarkRunFrame(b, loopRom, 1000, true) }
+
+func BenchmarkRunFrameSelfModifyingInterpreted(b *testing.B) {
+	benchmarkRunFrame(b, selfModifyingRom, 7, false)
+}
+
+func BenchmarkRunFrameSelfModifyingRecompiled(b *testing.B) {
+	benchmarkRunFrame(b, selfModifyingRom, 7, true)
+}
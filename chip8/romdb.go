@@ -0,0 +1,102 @@
+package chip8
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// RomInfo is what the rom database knows about a rom
+type RomInfo struct {
+	Title    string `json:"title"`
+	Author   string `json:"author,omitempty"`
+	Platform string `json:"platform,omitempty"`
+
+	// Quirks, when set, win over the platform's quirks
+	Quirks *Quirks `json:"quirks,omitempty"`
+
+	// Recommended cpu speed, 0 for the default
+	InstructionsPerFrame int `json:"ipf,omitempty"`
+
+	// Keymap maps host keys (a single letter or digit, e.g. "W")
+	// onto keypad keys, on top of the default 0-9/A-F layout
+	Keymap map[string]byte `json:"keymap,omitempty"`
+
+	// Palette holds the background and foreground colour,
+	// e.g. ["#000000", "#FFFFFF"]
+	Palette []string `json:"palette,omitempty"`
+}
+
+// RomDB maps the hex encoded SHA-1 of the rom bytes to its RomInfo
+type RomDB map[string]RomInfo
+
+// RomHash returns the key a rom is stored under in a RomDB
+func RomHash(rom []byte) string {
+	sum := sha1.Sum(rom)
+	return hex.EncodeToString(sum[:])
+}
+
+// DefaultRomDB returns the database built into the emulator
+func DefaultRomDB() RomDB {
+	db, err := ReadRomDB(strings.NewReader(embeddedRomDB))
+	if err != nil {
+		// only reachable by shipping a broken romdb_data.go
+		panic(err)
+	}
+	return db
+}
+
+// ReadRomDB parses a json rom database, a single object
+// of SHA-1 to RomInfo, as found in romdb_data.go
+func ReadRomDB(r io.Reader) (RomDB, error) {
+	db := RomDB{}
+	if err := json.NewDecoder(r).Decode(&db); err != nil {
+		return nil, fmt.Errorf("reading rom database: %w", err)
+	}
+
+	// hashes are compared lower case
+	normalised := make(RomDB, len(db))
+	for hash, info := range db {
+		if info.Platform != "" {
+			if _, ok := PlatformQuirks(info.Platform); !ok {
+				return nil, fmt.Errorf("rom database entry %s: unknown platform %q", hash, info.Platform)
+			}
+		}
+		normalised[strings.ToLower(hash)] = info
+	}
+
+	return normalised, nil
+}
+
+// Merge adds the entries of other to db, replacing any it already had
+func (db RomDB) Merge(other RomDB) {
+	for hash, info := range other {
+		db[hash] = info
+	}
+}
+
+// Lookup finds the rom in the database
+func (db RomDB) Lookup(rom []byte) (RomInfo, bool) {
+	info, ok := db[RomHash(rom)]
+	return info, ok
+}
+
+// Apply sets the platform, quirks and speed recommended
+// for the rom on config
+func (info RomInfo) Apply(config *Config) {
+	if info.Platform != "" {
+		config.Platform = info.Platform
+		config.Quirks, _ = PlatformQuirks(info.Platform)
+	}
+
+	if info.Quirks != nil {
+		config.Quirks = *info.Quirks
+	}
+
+	if info.InstructionsPerFrame > 0 {
+		config.InstructionsPerFrame = info.InstructionsPerFrame
+	}
+}
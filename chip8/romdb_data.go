@@ -0,0 +1,23 @@
+package chip8
+
+// embeddedRomDB is the rom database shipped with the emulator,
+// in the format ReadRomDB reads. Entries are keyed by the SHA-1
+// of the rom bytes, only roms whose hash has been checked against
+// the actual file belong here. Anything else goes in the user's
+// local database file, which is merged over this one.
+//
+// No entries have been verified yet, so it is empty and only
+// the local database identifies roms.
+//
+// An entry looks like:
+//
+//	"<sha1>": {
+//		"title": "Some Game",
+//		"author": "Someone",
+//		"platform": "chip-8",
+//		"quirks": {"shift": true, "loadstore": true},
+//		"ipf": 10,
+//		"keymap": {"W": 5, "S": 8},
+//		"palette": ["#000000", "#33FF66"]
+//	}
+const embeddedRomDB = `{}`
@@ -0,0 +1,40 @@
+package chip8
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestDefaultRomDB(t *testing.T) {
+	for hash, info := range DefaultRomDB() {
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != 20 {
+			t.Errorf("entry %q (%s) isn't keyed by a SHA-1", hash, info.Title)
+		}
+	}
+}
+
+func TestReadRomDB(t *testing.T) {
+	tests := []struct {
+		name, json string
+		wantErr    bool
+	}{
+		{"empty", `{}`, false},
+		{"entry", `{"0123456789ABCDEF0123456789abcdef01234567": {"title": "T", "platform": "schip", "quirks": {"shift": true}}}`, false},
+		{"unknown platform", `{"0123456789abcdef0123456789abcdef01234567": {"platform": "chip-9"}}`, true},
+		{"not json", `{"0123`, true},
+	}
+
+	for _, tt := range tests {
+		db, err := ReadRomDB(strings.NewReader(tt.json))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		for hash := range db {
+			if hash != strings.ToLower(hash) {
+				t.Errorf("%s: hash %s isn't lower cased", tt.name, hash)
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package chip8
+
+// A sprite is a group of bytes which are a binary representation of the desired picture.
+// Chip-8 sprites can be up to 15 bytes, for a possible sprite size of 8x15
+const (
+	EmuHeight = 32
+	EmuWidth  = 64
+)
+
+// Framebuffer holds one byte per pixel, 1 for lit and 0 for dark.
+// y for height, x for row
+type Framebuffer [EmuHeight][EmuWidth]byte
+
+// Screen encapsulates our display arr, the ground truth
+// of what's drawn, independent of any frontend.
+type Screen struct {
+	display Framebuffer
+}
+
+func newScreen() *Screen {
+	return &Screen{}
+}
+
+func (scr *Screen) clearDisplay() {
+	scr.display = Framebuffer{}
+}
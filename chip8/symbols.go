@@ -0,0 +1,209 @@
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbols names rom addresses, so debug output can
+// say draw_player+4 where it would say 2A4
+type Symbols struct {
+	byName map[string]uint16
+	byAddr map[uint16]string
+
+	// sorted, for finding the label an address falls under
+	addrs []uint16
+}
+
+// NewSymbols returns an empty symbol table, see Add
+func NewSymbols() *Symbols {
+	return &Symbols{byName: map[string]uint16{}, byAddr: map[uint16]string{}}
+}
+
+// ReadSymbols reads a symbol file, one label per line in any of
+//
+//	2A4 draw_player
+//	draw_player 0x2A4
+//	draw_player = 0x2A4
+//	:const draw_player 0x2A4
+//
+// that is plain "addr name" lines with hex addresses, or Octo style
+// listings naming the label first. An address written with 0x is
+// always taken as the address; # and ; start comments.
+func ReadSymbols(r io.Reader) (*Symbols, error) {
+	s := NewSymbols()
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		var fields []string
+		for _, f := range strings.Fields(line) {
+			if f != "=" && f != ":const" && f != ":" {
+				fields = append(fields, f)
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("symbols line %d: want an address and a name", n)
+		}
+
+		name, addr, ok := splitSymbol(fields[0], fields[1])
+		if !ok {
+			return nil, fmt.Errorf("symbols line %d: no address in %q", n, strings.TrimSpace(line))
+		}
+		s.Add(name, addr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading symbols: %w", err)
+	}
+
+	return s, nil
+}
+
+// splitSymbol works out which of a and b is the address
+func splitSymbol(a, b string) (string, uint16, bool) {
+	hasPrefix := func(f string) bool { return strings.HasPrefix(f, "0x") || strings.HasPrefix(f, "0X") }
+	parse := func(f string) (uint16, bool) {
+		addr, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(f, "0x"), "0X"), 16, 16)
+		return uint16(addr), err == nil && addr <= RAMEndAddr
+	}
+
+	if hasPrefix(b) && !hasPrefix(a) {
+		a, b = b, a
+	}
+	if addr, ok := parse(a); ok {
+		return b, addr, true
+	}
+	if hasPrefix(a) {
+		return "", 0, false
+	}
+
+	// "name addr" without the 0x
+	addr, ok := parse(b)
+	return a, addr, ok
+}
+
+// Add names addr, the first name given to an address is the one shown
+func (s *Symbols) Add(name string, addr uint16) {
+	s.byName[name] = addr
+	if _, ok := s.byAddr[addr]; ok {
+		return
+	}
+
+	s.byAddr[addr] = name
+	i := sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] > addr })
+	s.addrs = append(s.addrs, 0)
+	copy(s.addrs[i+1:], s.addrs[i:])
+	s.addrs[i] = addr
+}
+
+// Len returns the number of labelled addresses
+func (s *Symbols) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.addrs)
+}
+
+// Lookup returns the address of a label
+func (s *Symbols) Lookup(name string) (uint16, bool) {
+	if s == nil {
+		return 0, false
+	}
+	addr, ok := s.byName[name]
+	return addr, ok
+}
+
+// Label returns the label right at addr, if there is one
+func (s *Symbols) Label(addr uint16) string {
+	if s == nil {
+		return ""
+	}
+	return s.byAddr[addr]
+}
+
+// Name returns addr relative to the closest label at or before it,
+// e.g. "draw_player+4", or "" when there's no label that low
+func (s *Symbols) Name(addr uint16) string {
+	if s == nil {
+		return ""
+	}
+
+	i := sort.Search(len(s.addrs), func(i int) bool { return s.addrs[i] > addr }) - 1
+	if i < 0 {
+		return ""
+	}
+	base := s.addrs[i]
+	if base == addr {
+		return s.byAddr[base]
+	}
+	return fmt.Sprintf("%s+%d", s.byAddr[base], addr-base)
+}
+
+// Describe is Name, falling back to the bare address
+func (s *Symbols) Describe(addr uint16) string {
+	if name := s.Name(addr); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%03X", addr)
+}
+
+// SetSymbols labels the vm's debug output, fault messages and
+// CallStack, nil goes back to bare addresses
+func (vm *VM) SetSymbols(s *Symbols) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.symbols = s
+}
+
+// Symbols returns the symbols set with SetSymbols
+func (vm *VM) Symbols() *Symbols {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	return vm.symbols
+}
+
+// CallStack describes where the program is, innermost first:
+// the program counter and then the CALL of every subroutine
+//
+//	#0 20A  sub+4
+//	#1 200  start
+func (vm *VM) CallStack() string {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	cpu := vm.cpu
+	pcs := []uint16{cpu.programCounter}
+	for i := int(cpu.stackPointer) - 1; i >= 0; i-- {
+		pcs = append(pcs, cpu.stack[i])
+	}
+
+	lines := make([]string, len(pcs))
+	for i, pc := range pcs {
+		lines[i] = fmt.Sprintf("#%d %03X", i, pc)
+		if name := vm.symbols.Name(pc); name != "" {
+			lines[i] += "  " + name
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// describe is how messages show addresses, with a label when there's one
+func (vm *VM) describe(addr uint16) string {
+	if name := vm.symbols.Name(addr); name != "" {
+		return fmt.Sprintf("%03X (%s)", addr, name)
+	}
+	return fmt.Sprintf("%03X", addr)
+}
@@ -0,0 +1,300 @@
+package chip8
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Binary execution trace, as written by TraceWriter.
+//
+// The file starts with the magic "C8TR" and a version byte,
+// followed by one record per executed instruction:
+//
+//	uvarint  instruction index delta from the previous record
+//	uvarint  frame delta from the previous record
+//	uint16   PC, big endian
+//	uint16   opcode, big endian
+//	uvarint  changed register mask: bits 0-15 V0-VF,
+//	         16 I, 17 SP, 18 DT, 19 ST
+//	...      the new value of every changed register, in mask
+//	         order, one byte each except I which takes two
+//	uvarint  number of memory writes
+//	...      per write: uint16 address, byte value
+//
+// Registers are diffed against the previous record (all zero
+// before the first), so timer countdowns between instructions
+// show up on the instruction after them.
+
+const (
+	traceMagic   = "C8TR"
+	traceVersion = 1
+)
+
+// Bits of TraceRecord.Changed past the V registers
+const (
+	TraceChangedI  = 1 << 16
+	TraceChangedSP = 1 << 17
+	TraceChangedDT = 1 << 18
+	TraceChangedST = 1 << 19
+)
+
+// ErrNotATrace is returned by NewTraceReader for files without the trace header
+var ErrNotATrace = errors.New("not a chip-8 trace file")
+
+// TraceRecord is one instruction read back from a trace
+type TraceRecord struct {
+	Index  uint64
+	Frame  uint64
+	PC     uint16
+	Opcode uint16
+
+	// Changed has a bit set for every register the
+	// instruction changed, see the TraceChanged bits
+	Changed uint32
+
+	// Regs are all registers after the instruction
+	Regs Registers
+
+	Writes []MemoryWrite
+}
+
+// RegisterChanged reports whether Vx changed
+func (r *TraceRecord) RegisterChanged(x uint8) bool {
+	return r.Changed&(1<<(x&0xF)) != 0
+}
+
+// TraceWriter is a Tracer which records every instruction
+// to w in the compact binary format above
+type TraceWriter struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	err error
+
+	last      Registers
+	lastIndex uint64
+	lastFrame uint64
+
+	buf []byte
+}
+
+// NewTraceWriter writes the trace header to w, Flush when done
+func NewTraceWriter(w io.Writer) (*TraceWriter, error) {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	if err := bw.WriteByte(traceVersion); err != nil {
+		return nil, err
+	}
+
+	return &TraceWriter{w: bw}, nil
+}
+
+// TraceStep records ev, implements Tracer
+func (t *TraceWriter) TraceStep(ev *StepEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return
+	}
+
+	b := t.buf[:0]
+	b = appendUvarint(b, ev.Index-t.lastIndex)
+	b = appendUvarint(b, ev.Frame-t.lastFrame)
+	b = append(b, byte(ev.PC>>8), byte(ev.PC), byte(ev.Opcode>>8), byte(ev.Opcode))
+
+	regs := &ev.Regs
+	var changed uint32
+	for i := range regs.V {
+		if regs.V[i] != t.last.V[i] {
+			changed |= 1 << uint(i)
+		}
+	}
+	if regs.I != t.last.I {
+		changed |= TraceChangedI
+	}
+	if regs.SP != t.last.SP {
+		changed |= TraceChangedSP
+	}
+	if regs.DT != t.last.DT {
+		changed |= TraceChangedDT
+	}
+	if regs.ST != t.last.ST {
+		changed |= TraceChangedST
+	}
+
+	b = appendUvarint(b, uint64(changed))
+	for i := range regs.V {
+		if changed&(1<<uint(i)) != 0 {
+			b = append(b, regs.V[i])
+		}
+	}
+	if changed&TraceChangedI != 0 {
+		b = append(b, byte(regs.I>>8), byte(regs.I))
+	}
+	if changed&TraceChangedSP != 0 {
+		b = append(b, regs.SP)
+	}
+	if changed&TraceChangedDT != 0 {
+		b = append(b, regs.DT)
+	}
+	if changed&TraceChangedST != 0 {
+		b = append(b, regs.ST)
+	}
+
+	b = appendUvarint(b, uint64(len(ev.Writes)))
+	for _, w := range ev.Writes {
+		b = append(b, byte(w.Addr>>8), byte(w.Addr), w.Value)
+	}
+
+	_, t.err = t.w.Write(b)
+	t.buf = b
+
+	t.last = *regs
+	t.lastIndex = ev.Index
+	t.lastFrame = ev.Frame
+}
+
+// Flush writes out anything buffered, returning the
+// first error hit while recording
+func (t *TraceWriter) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+// TraceReader reads back a trace written by TraceWriter
+type TraceReader struct {
+	r    *bufio.Reader
+	last TraceRecord
+}
+
+// NewTraceReader checks the trace header on r
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(traceMagic)]) != traceMagic {
+		return nil, ErrNotATrace
+	}
+	if header[len(traceMagic)] != traceVersion {
+		return nil, fmt.Errorf("unsupported trace version %d", header[len(traceMagic)])
+	}
+
+	return &TraceReader{r: br}, nil
+}
+
+// Next returns the next instruction in the trace, io.EOF at the end.
+// The record's Writes are only valid until the following call.
+func (t *TraceReader) Next() (*TraceRecord, error) {
+	rec := &t.last
+
+	indexDelta, err := binary.ReadUvarint(t.r)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	frameDelta, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	var fixed [4]byte
+	if _, err := io.ReadFull(t.r, fixed[:]); err != nil {
+		return nil, t.truncated(err)
+	}
+
+	changed, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	// V registers, I takes up two bytes, SP/DT/ST one
+	values := make([]byte, 0, 21)
+	for bit := uint(0); bit < 20; bit++ {
+		if changed&(1<<bit) == 0 {
+			continue
+		}
+		n := 1
+		if bit == 16 {
+			n = 2
+		}
+		for i := 0; i < n; i++ {
+			b, err := t.r.ReadByte()
+			if err != nil {
+				return nil, t.truncated(err)
+			}
+			values = append(values, b)
+		}
+	}
+
+	writes, err := binary.ReadUvarint(t.r)
+	if err != nil {
+		return nil, t.truncated(err)
+	}
+
+	rec.Index += indexDelta
+	rec.Frame += frameDelta
+	rec.PC = binary.BigEndian.Uint16(fixed[0:])
+	rec.Opcode = binary.BigEndian.Uint16(fixed[2:])
+	rec.Changed = uint32(changed)
+
+	regs := &rec.Regs
+	for bit := uint(0); bit < 16; bit++ {
+		if changed&(1<<bit) != 0 {
+			regs.V[bit] = values[0]
+			values = values[1:]
+		}
+	}
+	if changed&TraceChangedI != 0 {
+		regs.I = binary.BigEndian.Uint16(values)
+		values = values[2:]
+	}
+	if changed&TraceChangedSP != 0 {
+		regs.SP = values[0]
+		values = values[1:]
+	}
+	if changed&TraceChangedDT != 0 {
+		regs.DT = values[0]
+		values = values[1:]
+	}
+	if changed&TraceChangedST != 0 {
+		regs.ST = values[0]
+	}
+
+	rec.Writes = rec.Writes[:0]
+	for i := uint64(0); i < writes; i++ {
+		var w [3]byte
+		if _, err := io.ReadFull(t.r, w[:]); err != nil {
+			return nil, t.truncated(err)
+		}
+		rec.Writes = append(rec.Writes, MemoryWrite{Addr: binary.BigEndian.Uint16(w[:]), Value: w[2]})
+	}
+
+	return rec, nil
+}
+
+func (t *TraceReader) truncated(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("trace after instruction %d: %w", t.last.Index, err)
+}
@@ -0,0 +1,127 @@
+package chip8
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// traceEvents are a few instructions touching every part of a record
+var traceEvents = []StepEvent{
+	{Index: 0, Frame: 0, PC: 0x200, Opcode: 0x6A05, Regs: Registers{V: [16]byte{0xA: 5}}},
+	{Index: 1, Frame: 0, PC: 0x202, Opcode: 0xA300, Regs: Registers{V: [16]byte{0xA: 5}, I: 0x300}},
+	{Index: 2, Frame: 0, PC: 0x204, Opcode: 0xFA33, Regs: Registers{V: [16]byte{0xA: 5}, I: 0x300},
+		Writes: []MemoryWrite{{0x300, 0}, {0x301, 0}, {0x302, 5}}},
+	{Index: 3, Frame: 2, PC: 0x206, Opcode: 0x2400, Regs: Registers{V: [16]byte{0xA: 5}, I: 0x300, SP: 1, DT: 7, ST: 3}},
+	{Index: 300, Frame: 9, PC: 0x400, Opcode: 0x8FA6, Regs: Registers{V: [16]byte{0xA: 5, 0xF: 1}, I: 0x300, SP: 1}},
+	{Index: 301, Frame: 9, PC: 0x402, Opcode: 0xFF55, Regs: Registers{V: [16]byte{0xA: 5, 0xF: 1}, I: 0xFFF, SP: 1},
+		Writes: []MemoryWrite{{0xFFF, 0xFF}}},
+}
+
+// writeTrace returns the trace of events and the
+// file offset at which each record ends
+func writeTrace(t *testing.T, events []StepEvent) ([]byte, []int) {
+	var buf bytes.Buffer
+	w, err := NewTraceWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ends []int
+	for i := range events {
+		w.TraceStep(&events[i])
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	return buf.Bytes(), ends
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	data, _ := writeTrace(t, traceEvents)
+
+	r, err := NewTraceReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var last Registers
+	for i, ev := range traceEvents {
+		rec, err := r.Next()
+		if err != nil {
+			t.Fatalf("record %d: %v", i, err)
+		}
+
+		if rec.Index != ev.Index || rec.Frame != ev.Frame || rec.PC != ev.PC || rec.Opcode != ev.Opcode {
+			t.Errorf("record %d: got %d/%d %03X %04X, want %d/%d %03X %04X", i,
+				rec.Index, rec.Frame, rec.PC, rec.Opcode, ev.Index, ev.Frame, ev.PC, ev.Opcode)
+		}
+		if rec.Regs != ev.Regs {
+			t.Errorf("record %d: got registers %+v, want %+v", i, rec.Regs, ev.Regs)
+		}
+		if len(rec.Writes) != 0 || len(ev.Writes) != 0 {
+			if !reflect.DeepEqual(rec.Writes, ev.Writes) {
+				t.Errorf("record %d: got writes %v, want %v", i, rec.Writes, ev.Writes)
+			}
+		}
+		for x := uint8(0); x < 16; x++ {
+			if rec.RegisterChanged(x) != (ev.Regs.V[x] != last.V[x]) {
+				t.Errorf("record %d: V%X changed is %v", i, x, rec.RegisterChanged(x))
+			}
+		}
+		if (rec.Changed&TraceChangedI != 0) != (ev.Regs.I != last.I) {
+			t.Errorf("record %d: I changed is %v", i, rec.Changed&TraceChangedI != 0)
+		}
+		last = ev.Regs
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("after the last record got %v, want io.EOF", err)
+	}
+}
+
+func TestTraceTruncated(t *testing.T) {
+	data, ends := writeTrace(t, traceEvents)
+
+	boundary := map[int]bool{len(traceMagic) + 1: true}
+	for _, end := range ends {
+		boundary[end] = true
+	}
+
+	// cut the trace everywhere past the header
+	for cut := len(traceMagic) + 1; cut < len(data); cut++ {
+		r, err := NewTraceReader(bytes.NewReader(data[:cut]))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for {
+			_, err = r.Next()
+			if err != nil {
+				break
+			}
+		}
+
+		if boundary[cut] {
+			if err != io.EOF {
+				t.Errorf("cut at %d, a record boundary: got %v, want io.EOF", cut, err)
+			}
+		} else if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("cut at %d: got %v, want io.ErrUnexpectedEOF", cut, err)
+		}
+	}
+}
+
+func TestTraceHeader(t *testing.T) {
+	for _, data := range []string{"", "C8T", "C9TR\x01", "not a trace"} {
+		if _, err := NewTraceReader(bytes.NewReader([]byte(data))); err != ErrNotATrace {
+			t.Errorf("%q: got %v, want ErrNotATrace", data, err)
+		}
+	}
+	if _, err := NewTraceReader(bytes.NewReader([]byte("C8TR\x02"))); err == nil {
+		t.Error("version 2: got no error")
+	}
+}
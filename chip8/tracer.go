@@ -0,0 +1,82 @@
+package chip8
+
+// Registers is the cpu state an instruction can change,
+// everything in State bar the program counter and stack
+type Registers struct {
+	V  [16]byte
+	I  uint16
+	SP byte
+	DT byte
+	ST byte
+}
+
+// MemoryWrite is a byte an instruction stored to ram
+type MemoryWrite struct {
+	Addr  uint16
+	Value byte
+}
+
+// StepEvent describes one executed instruction
+type StepEvent struct {
+	// Index counts instructions executed since the vm was created
+	Index uint64
+
+	// Frame counts 60 Hz frames, see RunFrame and StepTimers
+	Frame uint64
+
+	// PC and Opcode of the instruction
+	PC     uint16
+	Opcode uint16
+
+	// NextPC is where the program counter went afterwards
+	NextPC uint16
+
+	// Stack holds the addresses of the CALLs the instruction
+	// ran under, outermost first
+	Stack []uint16
+
+	// Regs are the registers once the instruction ran
+	Regs Registers
+
+	// Writes lists the bytes the instruction stored to ram
+	Writes []MemoryWrite
+}
+
+// Tracer is handed every instruction the vm executes, see AddTracer.
+// It's called with the vm locked so it must not call back into
+// the vm, and the event is only valid for the duration of the call.
+type Tracer interface {
+	TraceStep(ev *StepEvent)
+}
+
+// AddTracer starts passing executed instructions to t
+func (vm *VM) AddTracer(t Tracer) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	vm.tracers = append(vm.tracers, t)
+}
+
+// RemoveTracer stops passing executed instructions to t
+func (vm *VM) RemoveTracer(t Tracer) {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+
+	for i, tracer := range vm.tracers {
+		if tracer == t {
+			vm.tracers = append(vm.tracers[:i], vm.tracers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (vm *VM) registers() Registers {
+	cpu := vm.cpu
+	return Registers{
+		V:  cpu.register,
+		I:  cpu.registerI,
+		SP: cpu.stackPointer,
+		DT: cpu.delay,
+		ST: cpu.sound,
+	}
+}
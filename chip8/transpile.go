@@ -0,0 +1,304 @@
+package chip8
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Transpile writes the rom as Go source for package pkg, a NativeProgram
+// registering itself on init (see native.go). The program is one switch
+// on the program counter with a case per instruction the analysis found,
+// each falling through to the next; jumps, calls, returns and computed
+// jumps go back through the switch, which is their dispatch table.
+// name is the rom's file name and ident what the Go names start with.
+func (a *Analysis) Transpile(w io.Writer, pkg, name, ident string, symbols *Symbols) error {
+	var addrs []uint16
+	for _, b := range a.Blocks {
+		for _, addr := range b.Instructions() {
+			if transpileOp(addr, a.Opcode(addr), a.Quirks) != nil {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+
+	var src bytes.Buffer
+	p := func(format string, args ...interface{}) { fmt.Fprintf(&src, format+"\n", args...) }
+
+	p("// Code generated by chip8-emulator transpile from %s. DO NOT EDIT.", name)
+	p("")
+	p("// Built into the emulator this runs %s compiled ahead of time,", name)
+	p("// and the emulator starts on it when no rom is given.")
+	p("")
+	p("//go:build !js")
+	p("// +build !js")
+	p("")
+	p("package %s", pkg)
+	p("")
+	p("import %q", "chip8-emulator/chip8")
+	p("")
+	p("func init() {")
+	p("chip8.RegisterNative(&chip8.NativeProgram{")
+	p("Name: %q,", name)
+	p("Rom: %sRom,", ident)
+	p("Quirks: %#v,", a.Quirks)
+	p("Code: %sCode,", ident)
+	p("Run: %sRun,", ident)
+	p("})")
+	p("}")
+	p("")
+	p("var %sRom = []byte{", ident)
+	writeHexLines(&src, len(a.Rom), 12, func(i int) string { return fmt.Sprintf("0x%02x", a.Rom[i]) })
+	p("}")
+	p("")
+	p("var %sCode = []uint16{", ident)
+	writeHexLines(&src, len(addrs), 8, func(i int) string { return fmt.Sprintf("0x%03X", addrs[i]) })
+	p("}")
+	p("")
+	p("// %sRun runs the instructions from r.PC until budget runs out", ident)
+	p("func %sRun(r *chip8.Runtime, budget int) int {", ident)
+	p("n := 0")
+	p("for {")
+	p("switch r.PC {")
+	for i, addr := range addrs {
+		if label := symbols.Label(addr); label != "" {
+			p("// %s:", label)
+		}
+		p("case 0x%03X: // %s", addr, Disassemble(a.Opcode(addr)))
+		p("if n == budget {")
+		p("r.PC = 0x%03X", addr)
+		p("return n")
+		p("}")
+		p("n++")
+
+		lines, branches := transpileOp(addr, a.Opcode(addr), a.Quirks)()
+		for _, line := range lines {
+			p("%s", line)
+		}
+		if branches {
+			continue
+		}
+		next := addr + 2
+		if i+1 < len(addrs) && addrs[i+1] == next {
+			p("fallthrough")
+		} else {
+			p("r.PC = 0x%03X", next)
+			p("continue")
+		}
+	}
+	p("default:")
+	p("// not compiled, over to the interpreter")
+	p("return n")
+	p("}")
+	p("}")
+	p("}")
+
+	out, err := format.Source(src.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated code doesn't parse: %w", err)
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// writeHexLines writes n comma separated values, perLine to a line
+func writeHexLines(w io.Writer, n, perLine int, value func(i int) string) {
+	for i := 0; i < n; i += perLine {
+		var values []string
+		for j := i; j < n && j < i+perLine; j++ {
+			values = append(values, value(j))
+		}
+		fmt.Fprintf(w, "%s,\n", strings.Join(values, ", "))
+	}
+}
+
+// transpileOp returns what generates the Go code for opcode at pc,
+// nil for the ones left to the interpreter. The code runs with r.PC
+// left at some earlier instruction unless it branches, which sets
+// r.PC and continues to the dispatch switch.
+func transpileOp(pc, opcode uint16, quirks Quirks) func() ([]string, bool) {
+	x := (opcode >> 8) & 0xF
+	y := (opcode >> 4) & 0xF
+	n := opcode & 0xF
+	kk := opcode & 0xFF
+	nnn := opcode & 0xFFF
+
+	vx := fmt.Sprintf("r.V[0x%X]", x)
+	vy := fmt.Sprintf("r.V[0x%X]", y)
+
+	code := func(lines ...string) func() ([]string, bool) {
+		return func() ([]string, bool) { return lines, false }
+	}
+	branch := func(lines ...string) func() ([]string, bool) {
+		return func() ([]string, bool) { return append(lines, "continue"), true }
+	}
+	skip := func(cond string) func() ([]string, bool) {
+		return code(fmt.Sprintf("if %s {", cond), fmt.Sprintf("r.PC = 0x%03X", pc+4), "continue", "}")
+	}
+	// after storing to ram, in case that was over the program's own code
+	stores := func(lines ...string) func() ([]string, bool) {
+		return code(append(lines, "if r.Modified {", fmt.Sprintf("r.PC = 0x%03X", pc+2), "return n", "}")...)
+	}
+	// a stack error is left to the interpreter, which reports it
+	unless := func(cond string) []string {
+		return []string{fmt.Sprintf("if %s {", cond), fmt.Sprintf("r.PC = 0x%03X", pc), "return n - 1", "}"}
+	}
+
+	switch opcode >> 12 {
+	case 0x0:
+		switch opcode {
+		case 0x00E0:
+			return code("r.Clear()")
+		case 0x00EE:
+			return branch(append(unless("r.SP == 0"), "r.SP--", "r.PC = r.Stack[r.SP] + 2")...)
+		}
+	case 0x1:
+		return branch(fmt.Sprintf("r.PC = 0x%03X", nnn))
+	case 0x2:
+		return branch(append(unless("int(r.SP) >= len(r.Stack)"),
+			fmt.Sprintf("r.Stack[r.SP] = 0x%03X", pc), "r.SP++", fmt.Sprintf("r.PC = 0x%03X", nnn))...)
+	case 0x3:
+		return skip(fmt.Sprintf("%s == 0x%02X", vx, kk))
+	case 0x4:
+		return skip(fmt.Sprintf("%s != 0x%02X", vx, kk))
+	case 0x5:
+		if n == 0 {
+			return skip(fmt.Sprintf("%s == %s", vx, vy))
+		}
+	case 0x6:
+		return code(fmt.Sprintf("%s = 0x%02X", vx, kk))
+	case 0x7:
+		return code(fmt.Sprintf("%s += 0x%02X", vx, kk))
+	case 0x8:
+		if lines := transpileALU(vx, vy, n, quirks); lines != nil {
+			return code(lines...)
+		}
+	case 0x9:
+		if n == 0 {
+			return skip(fmt.Sprintf("%s != %s", vx, vy))
+		}
+	case 0xA:
+		return code(fmt.Sprintf("r.I = 0x%03X", nnn))
+	case 0xB:
+		reg := "r.V[0x0]"
+		if quirks.JumpUsesVX {
+			reg = vx
+		}
+		return branch(fmt.Sprintf("r.PC = 0x%03X + uint16(%s)", nnn, reg))
+	case 0xC:
+		return code(fmt.Sprintf("%s = r.Random() & 0x%02X", vx, kk))
+	case 0xD:
+		return code(fmt.Sprintf("r.Draw(0x%X, 0x%X, %d)", x, y, n))
+	case 0xE:
+		switch kk {
+		case 0x9E:
+			return skip(fmt.Sprintf("r.Pressed(%s)", vx))
+		case 0xA1:
+			return skip(fmt.Sprintf("!r.Pressed(%s)", vx))
+		}
+	case 0xF:
+		switch kk {
+		case 0x07:
+			return code(fmt.Sprintf("%s = r.DT", vx))
+		case 0x0A:
+			// runs again until a key comes
+			return code(fmt.Sprintf("r.PC = 0x%03X", pc), fmt.Sprintf("if !r.WaitKey(0x%X) {", x), "continue", "}")
+		case 0x15:
+			return code(fmt.Sprintf("r.DT = %s", vx))
+		case 0x18:
+			return code(fmt.Sprintf("r.ST = %s", vx))
+		case 0x1E:
+			return code(fmt.Sprintf("r.I += uint16(%s)", vx))
+		case 0x29:
+			return code(fmt.Sprintf("r.I = 0x%03X + uint16(5*%s)", DigitSpriteDataStart, vx))
+		case 0x33:
+			return stores(
+				fmt.Sprintf("r.Store(r.I, %s/100)", vx),
+				fmt.Sprintf("r.Store(r.I+1, %s/10%%10)", vx),
+				fmt.Sprintf("r.Store(r.I+2, %s%%10)", vx))
+		case 0x55:
+			var lines []string
+			for i := uint16(0); i <= x; i++ {
+				lines = append(lines, fmt.Sprintf("r.Store(r.I+%d, r.V[0x%X])", i, i))
+			}
+			if quirks.LoadStoreIncrementsI {
+				lines = append(lines, fmt.Sprintf("r.I += %d", x+1))
+			}
+			return stores(lines...)
+		case 0x65:
+			var lines []string
+			for i := uint16(0); i <= x; i++ {
+				lines = append(lines, fmt.Sprintf("r.V[0x%X] = r.RAM[(r.I+%d)&0x%03X]", i, i, RAMEndAddr))
+			}
+			if quirks.LoadStoreIncrementsI {
+				lines = append(lines, fmt.Sprintf("r.I += %d", x+1))
+			}
+			return code(lines...)
+		}
+	}
+
+	return nil
+}
+
+// transpileALU returns the Go code for the 8xyn register arithmetic,
+// setting VF after Vx as the interpreter does
+func transpileALU(vx, vy string, n uint16, quirks Quirks) []string {
+	const vf = "r.V[0xF]"
+	logic := func(op string) []string {
+		lines := []string{fmt.Sprintf("%s %s= %s", vx, op, vy)}
+		if quirks.LogicResetsVF {
+			lines = append(lines, vf+" = 0")
+		}
+		return lines
+	}
+	src := vx
+	if quirks.ShiftUsesVY {
+		src = vy
+	}
+
+	switch n {
+	case 0x0:
+		return []string{fmt.Sprintf("%s = %s", vx, vy)}
+	case 0x1:
+		return logic("|")
+	case 0x2:
+		return logic("&")
+	case 0x3:
+		return logic("^")
+	case 0x4:
+		return []string{
+			fmt.Sprintf("sum := uint16(%s) + uint16(%s)", vx, vy),
+			fmt.Sprintf("%s = byte(sum)", vx),
+			vf + " = byte(sum >> 8)",
+		}
+	case 0x5:
+		return []string{
+			fmt.Sprintf("noBorrow := %s >= %s", vx, vy),
+			fmt.Sprintf("%s -= %s", vx, vy),
+			"if noBorrow {", vf + " = 1", "} else {", vf + " = 0", "}",
+		}
+	case 0x6:
+		return []string{
+			fmt.Sprintf("v := %s", src),
+			fmt.Sprintf("%s = v >> 1", vx),
+			vf + " = v & 1",
+		}
+	case 0x7:
+		return []string{
+			fmt.Sprintf("noBorrow := %s >= %s", vy, vx),
+			fmt.Sprintf("%s = %s - %s", vx, vy, vx),
+			"if noBorrow {", vf + " = 1", "} else {", vf + " = 0", "}",
+		}
+	case 0xE:
+		return []string{
+			fmt.Sprintf("v := %s", src),
+			fmt.Sprintf("%s = v << 1", vx),
+			vf + " = v >> 7",
+		}
+	}
+	return nil
+}
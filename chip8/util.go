@@ -0,0 +1,47 @@
+package chip8
+
+import (
+	"fmt"
+)
+
+// MinOf bytes array
+func MinOf(vars ...byte) byte {
+	mini := vars[0]
+	for _, i := range vars {
+		if mini > i {
+			mini = i
+		}
+	}
+
+	return mini
+}
+
+// MaxOf bytes array
+func MaxOf(vars ...byte) byte {
+	maxi := vars[0]
+	for _, i := range vars {
+		if i > maxi {
+			maxi = i
+		}
+	}
+
+	return maxi
+}
+
+// HexOf ...
+func HexOf(num uint16) string {
+	return fmt.Sprintf("%x", num)
+}
+
+// HexOfByte ...
+func HexOfByte(num byte) string {
+	return fmt.Sprintf("%x", num)
+}
+
+// Reverse a string
+func Reverse(s string) (result string) {
+	for _, v := range s {
+		result = string(v) + result
+	}
+	return
+}
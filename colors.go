@@ -0,0 +1,10 @@
+package main
+
+import "image/color"
+
+// Colors
+var (
+	Black = color.RGBA{A: 1}
+	White = color.RGBA{R: 255, G: 255, B: 255, A: 1}
+	Blue  = color.RGBA{B: 255, A: 1}
+)
@@ -0,0 +1,118 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Subcommands, run as `chip8-emulator <command> [flags] <args>`.
+// Without one the emulator starts as usual.
+var commands = map[string]func(args []string){
+	"info":      infoCommand,
+	"trace":     traceCommand,
+	"coverage":  coverageCommand,
+	"dap":       dapCommand,
+	"analyze":   analyzeCommand,
+	"decompile": decompileCommand,
+	"sprites":   spritesCommand,
+	"transpile": transpileCommand,
+	"bench":     benchCommand,
+}
+
+// runCommand runs the subcommand named by args[0], if there is one
+func runCommand(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	// keep the report readable
+	log.SetLevel(log.WarnLevel)
+
+	command(args[1:])
+	return true
+}
+
+// infoCommand reports what the rom database and the
+// platform analyser make of the given roms
+func infoCommand(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to look at")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator info [flags] rom...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db := loadRomDB(*romDBPath)
+
+	for i, romFilePath := range fs.Args() {
+		if i > 0 {
+			fmt.Println()
+		}
+		printRomInfo(romFilePath, LoadRomFile(romFilePath, *romEntry), db)
+	}
+}
+
+func printRomInfo(romFilePath string, rom []byte, db chip8.RomDB) {
+	fmt.Printf("File:       %s\n", romFilePath)
+	fmt.Printf("Size:       %d bytes\n", len(rom))
+	fmt.Printf("SHA-1:      %s\n", chip8.RomHash(rom))
+
+	if info, ok := db.Lookup(rom); ok {
+		config := chip8.Config{}
+		info.Apply(&config)
+
+		fmt.Printf("Database:   %q by %q\n", info.Title, info.Author)
+		fmt.Printf("            platform %s, quirks %s, ipf %d\n", orDash(info.Platform), config.Quirks, info.InstructionsPerFrame)
+	} else {
+		fmt.Println("Database:   not found")
+	}
+
+	det := chip8.DetectPlatform(rom)
+	switch {
+	case det.Hires:
+		fmt.Printf("Detected:   hires %s (64x64), quirks %s\n", det.Platform, det.Quirks)
+	case det.Platform != "":
+		fmt.Printf("Detected:   %s, quirks %s\n", det.Platform, det.Quirks)
+	default:
+		fmt.Println("Detected:   plain CHIP-8, no platform specific opcodes found")
+	}
+	for _, reason := range det.Reasons {
+		fmt.Printf("            - %s\n", reason)
+	}
+
+	var sensitive []string
+	for name, count := range det.QuirkSensitive {
+		sensitive = append(sensitive, fmt.Sprintf("%s x%d", name, count))
+	}
+	sort.Strings(sensitive)
+	fmt.Printf("Quirk sensitive instructions: %s\n", orDash(strings.Join(sensitive, ", ")))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
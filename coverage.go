@@ -0,0 +1,247 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// coverageFile merges an instance's coverage into a file on exit
+type coverageFile struct {
+	path string
+	c    *chip8.Coverage
+}
+
+func (cf *coverageFile) Close() error {
+	p := cf.c.Profile()
+
+	// merge with earlier runs
+	if f, err := os.Open(cf.path); err == nil {
+		earlier, err := chip8.ReadCoverage(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", cf.path, err)
+		}
+		if err := earlier.Merge(p); err != nil {
+			return fmt.Errorf("%s: %w", cf.path, err)
+		}
+		p = earlier
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	f, err := os.Create(cf.path)
+	if err != nil {
+		return err
+	}
+	if err := p.WriteCoverage(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	log.Infof("Wrote the coverage of %d runs to %s", p.Runs, cf.path)
+	return f.Close()
+}
+
+// attachCoverage records the coverage of every instance,
+// adding it to what's in path (numbered when there are several)
+func attachCoverage(instances []instance, path string) []io.Closer {
+	var closers []io.Closer
+
+	for i, inst := range instances {
+		c := chip8.NewCoverage(inst.rom)
+		inst.vm.AddTracer(c)
+
+		closers = append(closers, &coverageFile{
+			path: instanceOutputPath(path, i, len(instances)),
+			c:    c})
+	}
+
+	return closers
+}
+
+// coverageCommand merges coverage files and reports on them
+func coverageCommand(args []string) {
+	fs := flag.NewFlagSet("coverage", flag.ExitOnError)
+	htmlPath := fs.String("html", "", "Write an annotated disassembly to this html file")
+	outPath := fs.String("o", "", "Write the merged coverage to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator coverage [flags] coverage.json...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var merged *chip8.CoverageProfile
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p, err := chip8.ReadCoverage(f)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+
+		if merged == nil {
+			merged = p
+		} else if err := merged.Merge(p); err != nil {
+			log.Fatalf("%s: %v", path, err)
+		}
+	}
+
+	report := newCoverageReport(merged)
+	fmt.Printf("Rom:          sha1 %s, %d bytes\n", merged.RomHash, len(merged.Rom))
+	fmt.Printf("Runs:         %d\n", merged.Runs)
+	fmt.Printf("Instructions: %d of %d executed (%.1f%%)\n", report.Executed, report.Words, percent(report.Executed, report.Words))
+	fmt.Printf("Branches:     %d of %d skip instructions went both ways (%.1f%%)\n", report.BothWays, report.Branches, percent(report.BothWays, report.Branches))
+
+	if *outPath != "" {
+		writeFile(*outPath, merged.WriteCoverage)
+	}
+	if *htmlPath != "" {
+		writeFile(*htmlPath, func(w io.Writer) error {
+			return coverageTemplate.Execute(w, report)
+		})
+	}
+}
+
+func writeFile(path string, write func(io.Writer) error) {
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := write(f); err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func percent(n, of int) float64 {
+	if of == 0 {
+		return 0
+	}
+	return float64(n) * 100 / float64(of)
+}
+
+// coverageReport is the annotated disassembly the html shows
+type coverageReport struct {
+	RomHash string
+	Runs    int
+
+	Words, Executed    int
+	Branches, BothWays int
+
+	Lines []coverageLine
+}
+
+type coverageLine struct {
+	Addr  string
+	Bytes string
+	Asm   string
+	Hits  uint64
+	Note  string
+
+	// css class: hit, miss, partial (a branch only taken
+	// one way) or data (odd bytes between instructions)
+	Class string
+}
+
+// newCoverageReport disassembles the rom two bytes at a time,
+// stepping a single byte where the program jumped to an odd address
+func newCoverageReport(p *chip8.CoverageProfile) *coverageReport {
+	r := &coverageReport{RomHash: p.RomHash, Runs: p.Runs}
+	rom := p.Rom
+
+	for i := 0; i < len(rom); {
+		addr := uint16(chip8.ProgramAreaStart + i)
+		cov := p.Lookup(addr)
+
+		if cov.Hits == 0 && (i+1 >= len(rom) || p.Lookup(addr+1).Hits > 0) {
+			r.Lines = append(r.Lines, coverageLine{
+				Addr:  fmt.Sprintf("%03X", addr),
+				Bytes: fmt.Sprintf("%02X", rom[i]),
+				Class: "data"})
+			i++
+			continue
+		}
+
+		opcode := uint16(rom[i])<<8 | uint16(rom[i+1])
+		in := chip8.Decode(opcode)
+		line := coverageLine{
+			Addr:  fmt.Sprintf("%03X", addr),
+			Bytes: fmt.Sprintf("%04X", opcode),
+			Asm:   in.String(),
+			Hits:  cov.Hits,
+			Class: "miss",
+		}
+
+		r.Words++
+		if cov.Hits > 0 {
+			r.Executed++
+			line.Class = "hit"
+
+			if in.IsSkip() {
+				r.Branches++
+				switch {
+				case cov.Skipped == 0:
+					line.Class, line.Note = "partial", "never skipped"
+				case cov.FellThru == 0:
+					line.Class, line.Note = "partial", "always skipped"
+				default:
+					r.BothWays++
+					line.Note = fmt.Sprintf("skipped %d, fell through %d", cov.Skipped, cov.FellThru)
+				}
+			}
+		}
+
+		r.Lines = append(r.Lines, line)
+		i += 2
+	}
+
+	return r
+}
+
+var coverageTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Coverage {{.RomHash}}</title>
+<style>
+  body { background: #111; color: #ddd; font-family: monospace; }
+  table { border-collapse: collapse; }
+  td { padding: 0 10px; }
+  tr.hit td.hits { color: #6c6; }
+  tr.miss { color: #c66; }
+  tr.partial { background: #553; }
+  tr.data { color: #666; }
+</style>
+</head>
+<body>
+<p>rom sha1 {{.RomHash}}, {{.Runs}} runs<br>
+{{.Executed}} of {{.Words}} instructions executed, {{.BothWays}} of {{.Branches}} skips went both ways.
+Red lines never ran, yellow skips only ever went one way.</p>
+<table>
+<tr><th>addr</th><th>opcode</th><th>instruction</th><th>hits</th><th></th></tr>
+{{range .Lines}}<tr class="{{.Class}}"><td>{{.Addr}}</td><td>{{.Bytes}}</td><td>{{.Asm}}</td><td class="hits">{{if .Hits}}{{.Hits}}{{end}}</td><td>{{.Note}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
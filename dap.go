@@ -0,0 +1,938 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Debug Adapter Protocol server, for debugging roms from editors
+// such as VS Code. The editor either starts the adapter and talks
+// to it over stdin/stdout:
+//
+//	chip8-emulator dap
+//
+// or connects to one already listening (a "debugServer"):
+//
+//	chip8-emulator dap -listen localhost:4711
+//
+// The launch request takes "program" (the rom), "romEntry",
+// "symbols" (a file of "addr name" lines) and "stopOnEntry".
+// Breakpoints are set on the disassembly or as function
+// breakpoints naming a label or an address.
+
+// Variable references of the scopes every frame shows
+const (
+	dapScopeRegisters = 1 + iota
+	dapScopeTimers
+	dapScopeStack
+)
+
+// The one thread a vm has
+const dapThreadID = 1
+
+// Most instructions disassembled at once, all of ram
+const dapMaxInstructions = chip8.RAMSize / 2
+
+// Largest message taken from the editor, far more than any request needs
+const dapMaxMessage = 1 << 20
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+type dapLaunchArguments struct {
+	Program     string `json:"program"`
+	RomEntry    string `json:"romEntry"`
+	Symbols     string `json:"symbols"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+}
+
+type dapBreakpoint struct {
+	ID                   int    `json:"id,omitempty"`
+	Verified             bool   `json:"verified"`
+	Message              string `json:"message,omitempty"`
+	InstructionReference string `json:"instructionReference,omitempty"`
+}
+
+// dapCommand runs the debug adapter
+func dapCommand(args []string) {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := fs.String("listen", "", "Accept editors on this address (e.g. localhost:4711) instead of talking over stdin/stdout")
+	serveAddr := fs.String("serve", "", "Also serve the screen and keypad of the debugged rom to browsers on this address")
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	seed := fs.Int64("seed", 0, "Seed for the random number generator, 0 picks one at random")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator dap [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	// one vm for the whole run, so the browser view stays put across launches
+	vm := chip8.New(chip8.Config{Seed: *seed})
+	vm.SetPaused(true)
+
+	target := &dapTarget{dbg: newDebugger("dap", vm), db: loadRomDB(*romDBPath), seed: *seed}
+
+	if *serveAddr != "" {
+		go ServeVMs([]instance{{name: "dap", vm: vm, palette: DefaultPalette}}, *serveAddr)
+	}
+
+	if *listen == "" {
+		newDAPSession(target, os.Stdin, os.Stdout).run()
+		return
+	}
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatalf("Not able to listen for editors: %v", err)
+	}
+	log.Warnf("Debug adapter listening on %s", ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("Not able to accept an editor: %v", err)
+		}
+		newDAPSession(target, conn, conn).run()
+		conn.Close()
+	}
+}
+
+// dapTarget is what the adapter debugs, shared by its sessions
+type dapTarget struct {
+	dbg  *debugger
+	db   chip8.RomDB
+	seed int64
+}
+
+// dapSession is one editor talking to the adapter
+type dapSession struct {
+	*dapTarget
+
+	r   *bufio.Reader
+	w   io.Writer
+	seq int
+
+	symbols     *chip8.Symbols
+	launched    bool
+	stopOnEntry bool
+
+	// set while the vm runs
+	stopped <-chan stopReason
+
+	// breakpoint next or stepOut runs to, -1 without one;
+	// reaching it reports a finished step
+	stepBreak int
+
+	// the two kinds of breakpoint the editor sets independently
+	instructionBreaks []uint16
+	functionBreaks    []uint16
+
+	done bool
+}
+
+func newDAPSession(target *dapTarget, r io.Reader, w io.Writer) *dapSession {
+	return &dapSession{dapTarget: target, r: bufio.NewReader(r), w: w, stepBreak: -1}
+}
+
+func (s *dapSession) run() {
+	requests := make(chan *dapMessage)
+	go s.read(requests)
+
+	for !s.done {
+		select {
+		case req, ok := <-requests:
+			if !ok {
+				// the editor went away, leave the vm stopped for the next one
+				s.dbg.interrupt()
+				return
+			}
+			s.handle(req)
+
+		case reason := <-s.stopped:
+			s.stopped = nil
+			s.reportStop(reason)
+		}
+	}
+}
+
+// read decodes the Content-Length framed requests
+func (s *dapSession) read(requests chan<- *dapMessage) {
+	defer close(requests)
+
+	for {
+		length := -1
+		for {
+			line, err := s.r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+				length, _ = strconv.Atoi(strings.TrimSpace(v))
+			}
+		}
+		if length < 0 {
+			log.Warn("dap: message without a Content-Length")
+			continue
+		}
+		if length > dapMaxMessage {
+			// the stream can't be trusted to be in step any more
+			log.Errorf("dap: message of %d bytes, more than the %d allowed, closing the session", length, dapMaxMessage)
+			return
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(s.r, body); err != nil {
+			return
+		}
+
+		msg := &dapMessage{}
+		if err := json.Unmarshal(body, msg); err != nil {
+			log.Warnf("dap: bad message: %v", err)
+			continue
+		}
+		if msg.Type == "request" {
+			requests <- msg
+		}
+	}
+}
+
+func (s *dapSession) write(msg interface{}) {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		log.Errorf("dap: %v", err)
+		return
+	}
+
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body); err != nil {
+		log.Debugf("dap: write failed: %v", err)
+	}
+}
+
+func (s *dapSession) respond(req *dapMessage, body interface{}) {
+	s.seq++
+	s.write(&dapResponse{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *dapSession) fail(req *dapMessage, format string, args ...interface{}) {
+	s.seq++
+	s.write(&dapResponse{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *dapSession) event(name string, body interface{}) {
+	s.seq++
+	s.write(&dapEvent{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+func (s *dapSession) stoppedEvent(reason, description string) {
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThreadID,
+		"allThreadsStopped": true,
+	}
+	if description != "" {
+		body["description"] = description
+		body["text"] = description
+	}
+	s.event("stopped", body)
+}
+
+// reportStop tells the editor why the vm stopped
+func (s *dapSession) reportStop(reason stopReason) {
+	hitStepBreak := false
+	if hit, ok := reason.breakpoint(); ok && int(hit.PC) == s.stepBreak {
+		hitStepBreak = true
+	}
+	if s.stepBreak >= 0 {
+		s.stepBreak = -1
+		s.applyBreakpoints()
+	}
+
+	switch {
+	case reason.interrupted:
+		s.stoppedEvent("pause", "")
+	case hitStepBreak:
+		s.stoppedEvent("step", "")
+	case reason.err == nil:
+		s.stoppedEvent("step", "")
+	case reason.historyStart():
+		s.stoppedEvent("step", "Reached the start of the recorded history")
+	default:
+		if hit, ok := reason.breakpoint(); ok {
+			s.stoppedEvent("breakpoint", fmt.Sprintf("Breakpoint at %s", s.symbols.Describe(hit.PC)))
+		} else if _, ok := reason.watchpoint(); ok {
+			s.stoppedEvent("data breakpoint", reason.err.Error())
+		} else {
+			s.event("output", map[string]string{"category": "stderr", "output": reason.err.Error() + "\n"})
+			s.stoppedEvent("exception", reason.err.Error())
+		}
+	}
+}
+
+func (s *dapSession) handle(req *dapMessage) {
+	if s.stopped != nil {
+		switch req.Command {
+		case "pause", "disconnect", "terminate", "threads":
+		default:
+			s.fail(req, "%s needs the rom paused", req.Command)
+			return
+		}
+	}
+
+	vm := s.dbg.vm
+
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsFunctionBreakpoints":      true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsReadMemoryRequest":        true,
+			"supportsWriteMemoryRequest":       true,
+			"supportsDisassembleRequest":       true,
+			"supportsSetVariable":              true,
+			"supportsSteppingGranularity":      true,
+			"supportsTerminateRequest":         true,
+			"supportsStepBack":                 true,
+		})
+		s.event("initialized", nil)
+
+	case "launch":
+		if err := s.launch(req.Arguments); err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		s.respond(req, nil)
+
+	case "configurationDone":
+		s.respond(req, nil)
+		if !s.launched {
+			return
+		}
+		if s.stopOnEntry {
+			s.stoppedEvent("entry", "")
+		} else {
+			s.stopped = s.dbg.resume()
+		}
+
+	case "setBreakpoints":
+		// roms have no source lines to break on
+		var args struct {
+			Breakpoints []struct{} `json:"breakpoints"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		bps := make([]dapBreakpoint, len(args.Breakpoints))
+		for i := range bps {
+			bps[i].Message = "set breakpoints in the disassembly or by function name"
+		}
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+
+	case "setInstructionBreakpoints":
+		var args struct {
+			Breakpoints []struct {
+				InstructionReference string `json:"instructionReference"`
+				Offset               int    `json:"offset"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+
+		s.instructionBreaks = s.instructionBreaks[:0]
+		bps := make([]dapBreakpoint, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			addr, err := s.resolveAddress(bp.InstructionReference)
+			if err == nil {
+				addr += bp.Offset
+			}
+			bps[i] = s.breakpoint(addr, err, &s.instructionBreaks)
+		}
+		s.applyBreakpoints()
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+
+	case "setFunctionBreakpoints":
+		var args struct {
+			Breakpoints []struct {
+				Name string `json:"name"`
+			} `json:"breakpoints"`
+		}
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+
+		s.functionBreaks = s.functionBreaks[:0]
+		bps := make([]dapBreakpoint, len(args.Breakpoints))
+		for i, bp := range args.Breakpoints {
+			addr, err := s.resolveAddress(bp.Name)
+			bps[i] = s.breakpoint(addr, err, &s.functionBreaks)
+		}
+		s.applyBreakpoints()
+		s.respond(req, map[string]interface{}{"breakpoints": bps})
+
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": dapThreadID, "name": s.dbg.name}},
+		})
+
+	case "stackTrace":
+		s.respond(req, s.stackTrace(vm.State()))
+
+	case "scopes":
+		s.respond(req, map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Registers", "presentationHint": "registers", "variablesReference": dapScopeRegisters, "expensive": false},
+			{"name": "Timers", "variablesReference": dapScopeTimers, "expensive": false},
+			{"name": "Stack", "variablesReference": dapScopeStack, "expensive": false},
+		}})
+
+	case "variables":
+		var args struct {
+			VariablesReference int `json:"variablesReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		s.respond(req, map[string]interface{}{"variables": s.variables(vm.State(), args.VariablesReference)})
+
+	case "setVariable":
+		var args struct {
+			VariablesReference int    `json:"variablesReference"`
+			Name               string `json:"name"`
+			Value              string `json:"value"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+
+		v, err := s.setVariable(args.VariablesReference, args.Name, args.Value)
+		if err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		s.respond(req, map[string]string{"value": v})
+
+	case "evaluate":
+		var args struct {
+			Expression string `json:"expression"`
+			Context    string `json:"context"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+
+		if args.Context == "repl" {
+			if output, moved, ok := s.replCommand(args.Expression); ok {
+				s.respond(req, map[string]interface{}{"result": output, "variablesReference": 0})
+				if moved {
+					// so the editor refreshes its views
+					s.stoppedEvent("goto", "")
+				}
+				return
+			}
+		}
+
+		result, err := s.evaluate(strings.TrimSpace(args.Expression))
+		if err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		s.respond(req, result)
+
+	case "readMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Count           int    `json:"count"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.Count < 0 {
+			s.fail(req, "count %d is negative", args.Count)
+			return
+		}
+
+		addr, err := s.resolveAddress(args.MemoryReference)
+		if err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		addr += args.Offset
+
+		var data []byte
+		if addr >= 0 && addr < chip8.RAMSize && args.Count > 0 {
+			count := args.Count
+			if count > chip8.RAMSize-addr {
+				count = chip8.RAMSize - addr
+			}
+			data = vm.ReadMemory(uint16(addr), count)
+		}
+		s.respond(req, map[string]interface{}{
+			"address":         fmt.Sprintf("0x%03X", addr),
+			"data":            base64.StdEncoding.EncodeToString(data),
+			"unreadableBytes": args.Count - len(data),
+		})
+
+	case "writeMemory":
+		var args struct {
+			MemoryReference string `json:"memoryReference"`
+			Offset          int    `json:"offset"`
+			Data            string `json:"data"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+
+		addr, err := s.resolveAddress(args.MemoryReference)
+		if err == nil {
+			addr += args.Offset
+			if addr < 0 || addr >= chip8.RAMSize {
+				err = fmt.Errorf("0x%X is outside ram", addr)
+			}
+		}
+		data, derr := base64.StdEncoding.DecodeString(args.Data)
+		if err == nil {
+			err = derr
+		}
+		if err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		s.respond(req, map[string]int{"bytesWritten": vm.WriteMemory(uint16(addr), data)})
+
+	case "disassemble":
+		var args struct {
+			MemoryReference   string `json:"memoryReference"`
+			Offset            int    `json:"offset"`
+			InstructionOffset int    `json:"instructionOffset"`
+			InstructionCount  int    `json:"instructionCount"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.InstructionCount < 0 {
+			s.fail(req, "instructionCount %d is negative", args.InstructionCount)
+			return
+		}
+		if args.InstructionCount > dapMaxInstructions {
+			args.InstructionCount = dapMaxInstructions
+		}
+
+		addr, err := s.resolveAddress(args.MemoryReference)
+		if err != nil {
+			s.fail(req, "%v", err)
+			return
+		}
+		addr += args.Offset + 2*args.InstructionOffset
+		s.respond(req, map[string]interface{}{"instructions": s.disassemble(addr, args.InstructionCount)})
+
+	case "continue":
+		s.respond(req, map[string]bool{"allThreadsContinued": true})
+		s.stopped = s.dbg.resume()
+
+	case "next":
+		s.respond(req, nil)
+		s.stepOver()
+
+	case "stepIn":
+		s.respond(req, nil)
+		s.reportStop(s.dbg.step())
+
+	case "stepOut":
+		s.respond(req, nil)
+		s.stepOut()
+
+	case "stepBack":
+		s.respond(req, nil)
+		s.reportStop(s.dbg.stepBack())
+
+	case "reverseContinue":
+		s.respond(req, nil)
+		s.reportStop(s.dbg.reverseContinue())
+
+	case "pause":
+		s.respond(req, nil)
+		if s.stopped == nil {
+			s.stoppedEvent("pause", "")
+			return
+		}
+		s.dbg.interrupt()
+
+	case "disconnect", "terminate":
+		s.dbg.interrupt()
+		if s.stopped != nil {
+			<-s.stopped
+			s.stopped = nil
+		}
+		vm.ClearBreakpoints()
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.event("terminated", nil)
+			return
+		}
+		s.done = true
+
+	default:
+		s.fail(req, "%s is not supported", req.Command)
+	}
+}
+
+// launch loads the rom named in the launch request
+func (s *dapSession) launch(raw json.RawMessage) error {
+	var args dapLaunchArguments
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return fmt.Errorf("launch needs the rom as \"program\"")
+	}
+
+	rom, err := ReadRom(args.Program, args.RomEntry)
+	if err != nil {
+		return err
+	}
+
+	s.symbols = nil
+	if args.Symbols != "" {
+		if s.symbols, err = loadSymbols(args.Symbols); err != nil {
+			return err
+		}
+	}
+
+	settings := resolveRomSettings(rom, args.Program, s.db, &VMConfig{})
+	config := settings.config
+	config.Seed = s.seed
+
+	vm := s.dbg.vm
+	vm.SetConfig(config)
+	if err := vm.LoadROM(rom); err != nil {
+		return err
+	}
+	vm.HardReset()
+	vm.ClearBreakpoints()
+	vm.SetSymbols(s.symbols)
+
+	s.dbg.name = settings.title
+	s.launched = true
+	s.stopOnEntry = args.StopOnEntry
+	log.Infof("Debugging %s", settings.title)
+	return nil
+}
+
+// resolveAddress reads a label or an address
+func (s *dapSession) resolveAddress(ref string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if addr, ok := s.symbols.Lookup(ref); ok {
+		return int(addr), nil
+	}
+
+	addr, err := strconv.ParseInt(ref, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a label nor an address", ref)
+	}
+	return int(addr), nil
+}
+
+// breakpoint checks a requested breakpoint, adding it to set when good
+func (s *dapSession) breakpoint(addr int, err error, set *[]uint16) dapBreakpoint {
+	if err == nil && (addr < 0 || addr >= chip8.RAMSize) {
+		err = fmt.Errorf("0x%X is outside ram", addr)
+	}
+	if err != nil {
+		return dapBreakpoint{Message: err.Error()}
+	}
+
+	*set = append(*set, uint16(addr))
+	return dapBreakpoint{
+		ID:                   addr + 1,
+		Verified:             true,
+		InstructionReference: fmt.Sprintf("0x%03X", addr),
+	}
+}
+
+// applyBreakpoints sets the vm's breakpoints to what the editor asked for
+func (s *dapSession) applyBreakpoints() {
+	vm := s.dbg.vm
+
+	vm.ClearBreakpoints()
+	for _, addr := range s.instructionBreaks {
+		vm.AddBreakpoint(addr)
+	}
+	for _, addr := range s.functionBreaks {
+		vm.AddBreakpoint(addr)
+	}
+	if s.stepBreak >= 0 {
+		vm.AddBreakpoint(uint16(s.stepBreak))
+	}
+}
+
+// runTo resumes the vm until it comes back to addr
+func (s *dapSession) runTo(addr uint16) {
+	s.stepBreak = int(addr)
+	s.applyBreakpoints()
+	s.stopped = s.dbg.resume()
+}
+
+// stepOver steps a single instruction, running through CALLs
+func (s *dapSession) stepOver() {
+	vm := s.dbg.vm
+	pc := vm.PC()
+
+	opcode, err := vm.ReadOpcode()
+	if err != nil || opcode>>12 != 0x2 {
+		s.reportStop(s.dbg.step())
+		return
+	}
+
+	// breakpoints inside the subroutine still stop it
+	s.runTo(pc + 2)
+}
+
+// stepOut runs until the current subroutine returns
+func (s *dapSession) stepOut() {
+	state := s.dbg.vm.State()
+	if len(state.Stack) == 0 {
+		// nothing to return to
+		s.reportStop(s.dbg.step())
+		return
+	}
+	s.runTo(state.Stack[len(state.Stack)-1] + 2)
+}
+
+func (s *dapSession) stackTrace(state chip8.State) map[string]interface{} {
+	var frames []map[string]interface{}
+
+	// innermost first, each frame named after the label it's under
+	pcs := []uint16{state.PC}
+	for i := len(state.Stack) - 1; i >= 0; i-- {
+		pcs = append(pcs, state.Stack[i])
+	}
+	for i, pc := range pcs {
+		frames = append(frames, map[string]interface{}{
+			"id":                          i,
+			"name":                        s.symbols.Describe(pc),
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%03X", pc),
+		})
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+func (s *dapSession) variables(state chip8.State, ref int) []dapVariable {
+	var vars []dapVariable
+
+	switch ref {
+	case dapScopeRegisters:
+		for x, v := range state.V {
+			vars = append(vars, dapVariable{Name: fmt.Sprintf("V%X", x), Value: fmt.Sprintf("0x%02X", v), Type: "byte"})
+		}
+		vars = append(vars,
+			dapVariable{Name: "I", Value: s.addressValue(state.I), Type: "address", MemoryReference: fmt.Sprintf("0x%03X", state.I)},
+			dapVariable{Name: "PC", Value: s.addressValue(state.PC), Type: "address", MemoryReference: fmt.Sprintf("0x%03X", state.PC)},
+			dapVariable{Name: "SP", Value: fmt.Sprintf("%d", state.SP), Type: "byte"})
+
+	case dapScopeTimers:
+		vars = append(vars,
+			dapVariable{Name: "DT", Value: fmt.Sprintf("%d", state.DT), Type: "byte"},
+			dapVariable{Name: "ST", Value: fmt.Sprintf("%d", state.ST), Type: "byte"})
+
+	case dapScopeStack:
+		// innermost call first, like the call stack view
+		for i := len(state.Stack) - 1; i >= 0; i-- {
+			addr := state.Stack[i]
+			vars = append(vars, dapVariable{
+				Name:            fmt.Sprintf("[%d]", i),
+				Value:           s.addressValue(addr),
+				Type:            "address",
+				MemoryReference: fmt.Sprintf("0x%03X", addr)})
+		}
+	}
+
+	return vars
+}
+
+// addressValue shows an address with the label it's under
+func (s *dapSession) addressValue(addr uint16) string {
+	if name := s.symbols.Name(addr); name != "" {
+		return fmt.Sprintf("0x%03X (%s)", addr, name)
+	}
+	return fmt.Sprintf("0x%03X", addr)
+}
+
+func (s *dapSession) setVariable(ref int, name, value string) (string, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(value), 0, 16)
+	if err != nil {
+		return "", fmt.Errorf("bad value %q", value)
+	}
+
+	vm := s.dbg.vm
+	state := vm.State()
+
+	switch {
+	case ref == dapScopeRegisters && name == "I":
+		state.I = uint16(v)
+	case ref == dapScopeRegisters && name == "PC":
+		state.PC = uint16(v)
+	case ref == dapScopeTimers && name == "DT":
+		state.DT = byte(v)
+	case ref == dapScopeTimers && name == "ST":
+		state.ST = byte(v)
+	case ref == dapScopeRegisters && len(name) == 2 && name[0] == 'V':
+		x, err := strconv.ParseUint(name[1:], 16, 4)
+		if err != nil {
+			return "", fmt.Errorf("no register %s", name)
+		}
+		state.V[x] = byte(v)
+	default:
+		return "", fmt.Errorf("%s can't be changed", name)
+	}
+
+	vm.SetState(state)
+	for _, variable := range s.variables(vm.State(), ref) {
+		if variable.Name == name {
+			return variable.Value, nil
+		}
+	}
+	return value, nil
+}
+
+// evaluate looks up a register, a label or an address
+func (s *dapSession) evaluate(expr string) (map[string]interface{}, error) {
+	state := s.dbg.vm.State()
+
+	upper := strings.ToUpper(expr)
+	for _, ref := range []int{dapScopeRegisters, dapScopeTimers} {
+		for _, v := range s.variables(state, ref) {
+			if v.Name != upper {
+				continue
+			}
+			result := map[string]interface{}{"result": v.Value, "type": v.Type, "variablesReference": 0}
+			if v.MemoryReference != "" {
+				result["memoryReference"] = v.MemoryReference
+			}
+			return result, nil
+		}
+	}
+
+	addr, err := s.resolveAddress(expr)
+	if err != nil {
+		return nil, err
+	}
+	if addr < 0 || addr >= chip8.RAMSize {
+		return nil, fmt.Errorf("0x%X is outside ram", addr)
+	}
+	return map[string]interface{}{
+		"result":             s.addressValue(uint16(addr)),
+		"type":               "address",
+		"variablesReference": 0,
+		"memoryReference":    fmt.Sprintf("0x%03X", addr),
+	}, nil
+}
+
+// replCommand runs a history command typed into the debug console:
+// "history", "goto <instruction>" or "back-frame", reporting
+// whether the vm moved
+func (s *dapSession) replCommand(line string) (string, bool, bool) {
+	vm := s.dbg.vm
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false, false
+	}
+
+	var err error
+	switch {
+	case fields[0] == "history" && len(fields) == 1:
+		r, err := vm.History()
+		if err != nil {
+			return err.Error(), false, true
+		}
+		return fmt.Sprintf("at instruction %d, history goes from %d to %d", r.Now, r.Oldest, r.End), false, true
+	case fields[0] == "goto" && len(fields) == 2:
+		n, perr := strconv.ParseUint(fields[1], 10, 64)
+		if perr != nil {
+			return fmt.Sprintf("bad instruction number %q", fields[1]), false, true
+		}
+		err = vm.Goto(n)
+	case fields[0] == "back-frame" && len(fields) == 1:
+		err = vm.StepBackFrame()
+	default:
+		return "", false, false
+	}
+	if err != nil {
+		return err.Error(), false, true
+	}
+
+	r, _ := vm.History()
+	return fmt.Sprintf("at instruction %d, pc %s", r.Now, s.symbols.Describe(vm.PC())), true, true
+}
+
+// disassemble decodes count instructions from addr,
+// with placeholders for whatever lies outside ram
+func (s *dapSession) disassemble(addr, count int) []map[string]interface{} {
+	vm := s.dbg.vm
+
+	instructions := make([]map[string]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		a := addr + 2*i
+		if a < 0 || a+1 >= chip8.RAMSize {
+			instructions = append(instructions, map[string]interface{}{
+				"address":          fmt.Sprintf("0x%X", a),
+				"instruction":      "",
+				"presentationHint": "invalid",
+			})
+			continue
+		}
+
+		bytes := vm.ReadMemory(uint16(a), 2)
+		in := map[string]interface{}{
+			"address":          fmt.Sprintf("0x%03X", a),
+			"instructionBytes": fmt.Sprintf("%02X %02X", bytes[0], bytes[1]),
+			"instruction":      chip8.Disassemble(binary.BigEndian.Uint16(bytes)),
+		}
+		if label := s.symbols.Label(uint16(a)); label != "" {
+			in["symbol"] = label
+		}
+		instructions = append(instructions, in)
+	}
+	return instructions
+}
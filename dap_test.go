@@ -0,0 +1,44 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func dapFrame(body string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func TestDAPRead(t *testing.T) {
+	request := `{"seq":1,"type":"request","command":"initialize"}`
+
+	tests := []struct {
+		name  string
+		input string
+		want  int
+	}{
+		{"one request", dapFrame(request), 1},
+		{"event skipped", dapFrame(`{"seq":1,"type":"event"}`) + dapFrame(request), 1},
+		{"too long", "Content-Length: 4294967296\r\n\r\n" + request, 0},
+		{"too long after a request", dapFrame(request) + "Content-Length: 1048577\r\n\r\n" + dapFrame(request), 1},
+	}
+
+	for _, tt := range tests {
+		s := newDAPSession(nil, strings.NewReader(tt.input), io.Discard)
+		requests := make(chan *dapMessage)
+		go s.read(requests)
+
+		got := 0
+		for range requests {
+			got++
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d requests, want %d", tt.name, got, tt.want)
+		}
+	}
+}
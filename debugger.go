@@ -0,0 +1,145 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// debugger runs a vm for a debugger front end (gdb, an editor):
+// the vm sits stopped until told to step or resume, and once
+// resumed runs in real time until a breakpoint, watchpoint,
+// fault or interrupt stops it again.
+type debugger struct {
+	name string
+	vm   *chip8.VM
+
+	mu sync.Mutex
+	// cancels the running vm, nil while it's stopped
+	cancel context.CancelFunc
+}
+
+// stopReason says why the vm stopped
+type stopReason struct {
+	// a *chip8.BreakpointHit, *chip8.WatchpointHit or a
+	// program fault, nil when interrupted or done stepping
+	err error
+
+	interrupted bool
+}
+
+func (r stopReason) breakpoint() (*chip8.BreakpointHit, bool) {
+	var hit *chip8.BreakpointHit
+	return hit, errors.As(r.err, &hit)
+}
+
+func (r stopReason) watchpoint() (*chip8.WatchpointHit, bool) {
+	var hit *chip8.WatchpointHit
+	return hit, errors.As(r.err, &hit)
+}
+
+// historyStart reports going back as far as the recorded history goes
+func (r stopReason) historyStart() bool {
+	return errors.Is(r.err, chip8.ErrHistoryStart)
+}
+
+// fault reports the program crashing, as opposed to being stopped
+func (r stopReason) fault() bool {
+	_, bp := r.breakpoint()
+	_, wp := r.watchpoint()
+	return r.err != nil && !bp && !wp && !r.historyStart()
+}
+
+// newDebugger takes over vm, recording its history so it can go back
+func newDebugger(name string, vm *chip8.VM) *debugger {
+	vm.RecordHistory(true)
+	return &debugger{name: name, vm: vm}
+}
+
+// running reports whether the vm was resumed and hasn't stopped yet
+func (d *debugger) running() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.cancel != nil
+}
+
+// resume runs the vm until something stops it,
+// the reason arrives on the returned channel
+func (d *debugger) resume() <-chan stopReason {
+	stopped := make(chan stopReason, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel != nil {
+		// already running, its stop gets reported elsewhere
+		return stopped
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.vm.SkipBreakpoint()
+	d.vm.SetPaused(false)
+
+	go func() {
+		err := d.vm.Run(ctx)
+
+		d.mu.Lock()
+		d.cancel = nil
+		d.mu.Unlock()
+		cancel()
+
+		reason := stopReason{err: err}
+		if err == context.Canceled {
+			reason = stopReason{interrupted: true}
+		} else if reason.fault() {
+			log.Errorf("%s stopped: %v", d.name, err)
+		}
+		stopped <- reason
+	}()
+
+	return stopped
+}
+
+// interrupt stops a running vm, resume's channel reports when it has
+func (d *debugger) interrupt() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// step executes a single instruction of the stopped vm,
+// even one with a breakpoint on it
+func (d *debugger) step() stopReason {
+	d.vm.SkipBreakpoint()
+	return stopReason{err: d.vm.Step()}
+}
+
+// stepBack undoes the last instruction of the stopped vm
+func (d *debugger) stepBack() stopReason {
+	return stopReason{err: d.vm.StepBack()}
+}
+
+// reverseContinue runs the stopped vm backwards to the
+// last breakpoint or watchpoint it went past
+func (d *debugger) reverseContinue() stopReason {
+	return stopReason{err: d.vm.ReverseContinue()}
+}
+
+// release lets the vm run on freely, e.g. once the
+// debugger detaches, dropping its breakpoints
+func (d *debugger) release() {
+	d.vm.ClearBreakpoints()
+	d.resume()
+}
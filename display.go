@@ -1,203 +1,339 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
+	"fmt"
 	"image"
-	"image/color"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	"golang.org/x/exp/shiny/driver"
 	"golang.org/x/exp/shiny/screen"
-	"golang.org/x/image/draw"
 	"golang.org/x/mobile/event/key"
 	"golang.org/x/mobile/event/lifecycle"
 	"golang.org/x/mobile/event/paint"
+
+	"chip8-emulator/chip8"
 )
 
-// A sprite is a group of bytes which are a binary representation of the desired picture.
-// Chip-8 sprites can be up to 15 bytes, for a possible sprite size of 8x15
 const (
-	EmuHeight = 32
-	EmuWidth  = 64
-
 	// Scale of the main window relative to Emu dimensions
 	WinScale = 20
 
-	WinHeight = EmuHeight * WinScale
-	WinWidth  = EmuWidth * WinScale
+	WinHeight = chip8.EmuHeight * WinScale
+	WinWidth  = chip8.EmuWidth * WinScale
 
 	// Acutal Emu buffer scale
 	// TODO: document this better..
 	EmuScale = 20
 )
 
-// Colors
-var (
-	Black = color.RGBA{A: 1}
-	White = color.RGBA{R: 255, G: 255, B: 255, A: 1}
-	Blue  = color.RGBA{B: 255, A: 1}
-)
+// Rate at which the window polls the vm for a new frame
+const DisplayRefreshRate = time.Duration(16666) * time.Microsecond
 
-// Screen encapsulates our display arr, window
-// and the backBuffer we're using to upload to display
-// y for height, x for row
-type Screen struct {
-	// todo: display can be replaced with actual modification of backBuffer
-	display    [EmuHeight][EmuWidth]int // y for height, x for row
-	window     screen.Window
-	backBuffer screen.Buffer
-}
+// frameEvent has the window event loop look for a changed
+// framebuffer, it's empty so sending it doesn't allocate
+type frameEvent struct{}
 
-func (scr *Screen) clearDisplay() {
+// InitDisplays opens a window per vm instance and
+// blocks until all of them are closed
+func InitDisplays(instances []instance) {
 
-	img := scr.backBuffer.RGBA()
-	for j := 0; j < EmuHeight; j++ {
-		for i := 0; i < EmuWidth; i++ {
-			img.SetRGBA(j, i, Black)
-			scr.display[j][i] = 0
+	driver.Main(func(s screen.Screen) {
+		var wg sync.WaitGroup
+
+		for _, inst := range instances {
+			wg.Add(1)
+			go func(inst instance) {
+				defer wg.Done()
+				title := fmt.Sprintf("Chip-8 VM - %s (%s)", inst.name, inst.vm.SpeedLabel())
+				NewDisplay(s, inst, title)
+			}(inst)
 		}
-	}
+
+		wg.Wait()
+	})
 }
 
-// NewDisplay returns Screen struct instance
-// which obtain from the screen
-func (vm *VM) NewDisplay(keyboard *Keyboard) *Screen {
+// NewDisplay opens a window showing the vm framebuffer
+// and blocks running its event loop. Instances with a
+// launcher start out on the rom menu instead.
+func NewDisplay(s screen.Screen, inst instance, title string) {
+	vm := inst.vm
+	keyboard := newKeyboard(vm, inst.keymap)
+	palette := inst.palette
+	romFilePath, romEntry := inst.romFilePath, inst.romEntry
+
+	opts := screen.NewWindowOptions{
+		Height: WinHeight,
+		Width:  WinWidth,
+		Title:  title,
+	}
 
-	scr := &Screen{}
-	vm.screen = scr
+	window, err := s.NewWindow(&opts)
+	if err != nil {
+		log.Info("Unable to create display window: ")
+		log.Fatal(err)
+		return
+	}
 
-	// create a separate
-	driver.Main(func(s screen.Screen) {
-		opts := screen.NewWindowOptions{
-			Height: WinHeight,
-			Width:  WinWidth,
-			Title:  "Chip-8 VM",
-		}
+	defer window.Release()
+
+	// the window's pixels, painted when the frame changes
+	// and uploaded as they are on paint events
+	dim := image.Point{X: chip8.EmuWidth * EmuScale, Y: chip8.EmuHeight * EmuScale}
+	backBuffer, err := s.NewBuffer(dim)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer backBuffer.Release()
 
-		window, err := s.NewWindow(&opts)
+	// poll the vm for frame changes, the event loop below
+	// does the actual drawing
+	done := make(chan struct{})
+	defer close(done)
+	go pollFrames(vm, window, done)
+
+	log.Info("Window bounds: ", opts)
+	log.Infof("Buffer bounds: %s", backBuffer.Bounds())
+	log.Infof("Buffer size: %s", backBuffer.Size())
+
+	// default draw to buffer on init
+	defaultDrawToBuffer(backBuffer.RGBA(), palette)
+	window.Send(paint.Event{})
+
+	var shown shownFrame
+	var o osd
+
+	// showing the launcher menu rather than the vm
+	inMenu := inst.launcher != nil
+
+	startGame := func() {
+		rom, settings, path, err := inst.launcher.load()
+		if err == nil {
+			vm.SetConfig(settings.config)
+			err = vm.LoadROM(rom)
+		}
 		if err != nil {
-			log.Info("Unable to create display window: ")
-			log.Fatal(err)
+			log.Errorf("Not able to start the rom: %v", err)
+			o.show("can't load rom, see log")
 			return
 		}
 
-		defer window.Release()
-
-		dim := image.Point{X: EmuWidth, Y: EmuHeight}
-		drawBuff, err := s.NewBuffer(dim)
-		if err != nil {
-			log.Fatal(err)
+		for k := byte(0); k < 16; k++ {
+			vm.SetKey(k, false)
 		}
-		defer drawBuff.Release()
+		keyboard = newKeyboard(vm, settings.keymap)
+		palette = settings.palette
+		romFilePath, romEntry = path, ""
+		vm.SetPaused(false)
 
-		scr.window = window
-		scr.backBuffer = drawBuff
+		inMenu = false
+		shown.repaint = true
+	}
 
-		log.Info("Window bounds: ", opts)
-		log.Infof("Buffer bounds: %s", drawBuff.Bounds())
-		log.Infof("Buffer size: %s", drawBuff.Size())
+	// Listening for window events
+	for {
+		e := window.NextEvent()
+		switch e := e.(type) {
 
-		// default draw to buffer on init
-		defaultDrawToBuffer(drawBuff.RGBA())
-		window.Send(paint.Event{})
+		case lifecycle.Event:
+			if e.To == lifecycle.StageDead {
+				return
+			} else if e.To == lifecycle.StageFocused {
+				log.Info("Focus back on the screen!")
+			}
 
-		// Listening for window events
-		for {
-			e := window.NextEvent()
-			switch e := e.(type) {
+		case key.Event:
+			log.Info("pressed key: ", e.Code)
+			// TODO: graceful exit game,
+			// currently only shuts off the screen window
+			if e.Code == key.CodeEscape {
+				return
+			}
 
-			case lifecycle.Event:
-				if e.To == lifecycle.StageDead {
-					return
-				} else if e.To == lifecycle.StageFocused {
-					log.Info("Focus back on the screen!")
+			if inMenu {
+				if k, ok := launcherKey(e, keyboard); ok && inst.launcher.press(k) {
+					startGame()
 				}
+				continue
+			}
 
-			case key.Event:
-				log.Info("pressed key: ", e.Code)
-				// TODO: graceful exit game,
-				// currently only shuts off the screen window
-				if e.Code == key.CodeEscape {
-					return
+			if e.Code == HotkeyMenu && inst.launcher != nil {
+				if e.Direction == key.DirPress {
+					vm.SetPaused(true)
+					palette = DefaultPalette
+					inMenu = true
+					shown.repaint = true
 				}
+				continue
+			}
 
-				// todo: how can this design be improved?
-				keyboard.ProcessKeyEvent(e)
+			if e.Code == HotkeyReload {
+				// stdin can't be read a second time
+				if e.Direction == key.DirPress && romFilePath != "" && romFilePath != "-" {
+					if err := reloadRom(vm, romFilePath, romEntry); err != nil {
+						log.Errorf("Not able to reload the rom: %v", err)
+						o.show("can't reload rom, see log")
+					} else {
+						o.show("rom reloaded")
+					}
+				}
+				continue
+			}
 
-			case paint.Event:
-				log.Debugln("Paint event, re-painting the buffer..")
+			if handleHotkey(vm, e, &o) {
+				continue
+			}
+
+			// todo: how can this design be improved?
+			keyboard.ProcessKeyEvent(e)
 
-				scaledDim := image.Rectangle{
-					Max: image.Point{X: EmuWidth * EmuScale, Y: EmuHeight * EmuScale}}
+		case frameEvent:
+			fb := vm.Framebuffer()
+			text := o.current(vm)
+			if inMenu {
+				fb = inst.launcher.Framebuffer()
+				text = o.message()
+			}
 
-				drawBuff, err = s.NewBuffer(scaledDim.Max)
+			if shown.draw(backBuffer.RGBA(), &fb, text, palette) {
+				window.Send(paint.Event{})
+			}
 
-				// scale image
-				src := scr.backBuffer.RGBA()
-				dst := image.NewRGBA(scaledDim)
-				draw.NearestNeighbor.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		case paint.Event:
+			window.Upload(image.Point{}, backBuffer, backBuffer.Bounds())
+			window.Publish()
 
-				copyImageToBuffer(&drawBuff, dst)
+		case error:
+			log.Info(e)
+		}
 
-				window.Upload(image.Point{}, drawBuff, drawBuff.Bounds())
-				window.Publish()
+	}
+}
 
-			case error:
-				log.Info(e)
-			}
+// pollFrames sends the vm framebuffer to the window every
+// refresh, the event loop skips repainting when nothing changed
+func pollFrames(vm *chip8.VM, window screen.Window, done <-chan struct{}) {
+	refresh := time.NewTicker(DisplayRefreshRate)
+	defer refresh.Stop()
 
+	for {
+		select {
+		case <-done:
+			return
+		case <-refresh.C:
 		}
-	})
 
-	return scr
+		window.Send(frameEvent{})
+	}
 }
 
-func copyImageToBuffer(b *screen.Buffer, i *image.RGBA) {
+// reloadRom reads the rom file again and swaps
+// it in, e.g. after rebuilding it
+func reloadRom(vm *chip8.VM, romFilePath, romEntry string) error {
+	rom, err := ReadRom(romFilePath, romEntry)
+	if err != nil {
+		return err
+	}
 
-	buffImg := (*b).RGBA()
-	dim := buffImg.Bounds().Max
+	log.Infof("Reloading %s", romFilePath)
+	if err := vm.LoadROM(rom); err != nil {
+		return err
+	}
 
-	for y := 0; y < dim.Y; y++ {
-		for x := 0; x < dim.X; x++ {
-			col := i.At(x, y)
-			if col == Black {
-				buffImg.SetRGBA(x, y, Black)
-			} else {
-				buffImg.SetRGBA(x, y, White)
-			}
-		}
+	// a fault paused the old rom, the new one should run
+	vm.SetPaused(false)
+	return nil
+}
+
+// launcherKey turns a key press into a keypad key for the
+// menu, the arrow keys and enter work on top of the keypad
+func launcherKey(e key.Event, keyboard *Keyboard) (byte, bool) {
+	if e.Direction != key.DirPress {
+		return 0, false
+	}
+
+	switch e.Code {
+	case key.CodeUpArrow:
+		return 0x2, true
+	case key.CodeDownArrow:
+		return 0x8, true
+	case key.CodeLeftArrow:
+		return 0x4, true
+	case key.CodeRightArrow:
+		return 0x6, true
+	case key.CodeReturnEnter:
+		return 0x5, true
+	}
+
+	k, ok := keyboard.keyboardMap[e.Code]
+	return k, ok
+}
+
+// shownFrame remembers what the window shows, to skip
+// repainting frames which didn't change
+type shownFrame struct {
+	current chip8.Framebuffer
+	osdText string
+	// forces the next frame to be drawn, e.g. after the palette changed
+	repaint bool
+}
+
+// draw paints fb and the osd text onto img, unless that's what
+// it already holds, and reports whether it did
+func (s *shownFrame) draw(img *image.RGBA, fb *chip8.Framebuffer, text string, palette Palette) bool {
+	if *fb == s.current && text == s.osdText && !s.repaint {
+		return false
 	}
 
+	s.current = *fb
+	s.repaint = false
+	s.osdText = text
+	drawFramebuffer(fb, img, palette)
+	if text != "" {
+		drawOSD(img, text)
+	}
+	return true
 }
 
-// BufferToScreen puts the buffer to the window
-func BufferToScreen(scr *Screen) {
-	// This assumes that there has been updates to the current buffer
-	// and now we are ready to refresh the display
-
-	// copy ground truth to the buffer.
-	img := scr.backBuffer.RGBA()
-	for j := 0; j < EmuHeight; j++ {
-		for i := 0; i < EmuWidth; i++ {
-			if scr.display[j][i] == 0 {
-				img.SetRGBA(i, j, Black)
-			} else {
-				img.SetRGBA(i, j, White)
+// drawFramebuffer paints fb onto img, scaled up to fill it. It writes
+// img.Pix directly, a row of the display at a time, rather than going
+// through Set or At for every pixel.
+func drawFramebuffer(fb *chip8.Framebuffer, img *image.RGBA, palette Palette) {
+	size := img.Bounds().Size()
+	scaleX, scaleY := size.X/chip8.EmuWidth, size.Y/chip8.EmuHeight
+	width := 4 * chip8.EmuWidth * scaleX
+
+	for j := 0; j < chip8.EmuHeight; j++ {
+		line := img.Pix[j*scaleY*img.Stride:]
+		for i := 0; i < chip8.EmuWidth; i++ {
+			c := palette[fb[j][i]&1]
+			for k := i * scaleX; k < (i+1)*scaleX; k++ {
+				p := line[4*k : 4*k+4]
+				p[0], p[1], p[2], p[3] = c.R, c.G, c.B, c.A
 			}
 		}
-	}
 
-	scr.window.Send(paint.Event{})
+		// the rest of the display row is the same line again
+		for k := 1; k < scaleY; k++ {
+			copy(line[k*img.Stride:k*img.Stride+width], line[:width])
+		}
+	}
 }
 
-func defaultDrawToBuffer(img *image.RGBA) {
+func defaultDrawToBuffer(img *image.RGBA, palette Palette) {
 	b := img.Bounds()
 
 	log.Infof("Bounds: %s", b.String())
 
 	for x := b.Min.X; x < b.Max.X; x++ {
 		for y := b.Min.Y; y < b.Max.Y; y++ {
-			img.SetRGBA(x, y, Black)
+			img.SetRGBA(x, y, palette[0])
 		}
 	}
 }
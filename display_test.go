@@ -0,0 +1,64 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"image"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chip8-emulator/chip8"
+)
+
+func TestDrawAllocs(t *testing.T) {
+	vm := chip8.New(chip8.Config{})
+	if err := vm.LoadROM([]byte{0x12, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	// paused, the osd shows the speed on every frame
+	vm.SetPaused(true)
+
+	var fb chip8.Framebuffer
+	for y := 0; y < chip8.EmuHeight; y++ {
+		fb[y][(y*7)%chip8.EmuWidth] = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, chip8.EmuWidth*EmuScale, chip8.EmuHeight*EmuScale))
+
+	var s shownFrame
+	var o osd
+
+	// handling a frame event the way the window does
+	n := testing.AllocsPerRun(100, func() {
+		text := o.current(vm)
+		if text == "" {
+			t.Fatal("no osd text while paused")
+		}
+		s.repaint = true
+		if !s.draw(img, &fb, text, DefaultPalette) {
+			t.Fatal("frame not drawn")
+		}
+	})
+	if n != 0 {
+		t.Fatalf("drawing a frame allocates %g times, want 0", n)
+	}
+}
+
+func TestReloadRomUnpauses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rom.ch8")
+	if err := os.WriteFile(path, []byte{0x12, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// as the runner leaves it after a fault
+	vm := chip8.New(chip8.Config{})
+	vm.SetPaused(true)
+
+	if err := reloadRom(vm, path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if vm.Paused() {
+		t.Error("still paused after reloading")
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Recordings (traces and the like) are buffered, so they get
+// closed when the emulator exits, be it by closing the window
+// or with Ctrl-C.
+
+var (
+	exitClosers []io.Closer
+	exitOnce    sync.Once
+)
+
+// closeOnExit registers c to be closed before the process exits
+func closeOnExit(c ...io.Closer) {
+	exitClosers = append(exitClosers, c...)
+}
+
+// closeAll closes everything registered with closeOnExit, once
+func closeAll() {
+	exitOnce.Do(func() {
+		for _, c := range exitClosers {
+			if err := c.Close(); err != nil {
+				log.Errorf("Not able to finish writing a recording: %v", err)
+			}
+		}
+	})
+}
+
+// handleSignals runs closeAll on Ctrl-C or SIGTERM before exiting
+func handleSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		closeAll()
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,619 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// GDB remote serial protocol stub, so gdb (or anything else
+// speaking RSP) can debug a vm over TCP:
+//
+//	(gdb) target remote localhost:1234
+//
+// Registers are V0-VF, I, PC, SP, DT and ST (described to gdb by
+// gdbTargetXML), memory is the 4K of ram. Software and hardware
+// breakpoints, write/read/access watchpoints, single-step, continue
+// and Ctrl-C are supported. The vm waits stopped until gdb says go.
+
+// Registers in gdb's numbering, with their size in bytes.
+// Multi-byte registers go big endian, like everything on a CHIP-8.
+const (
+	gdbRegI  = 16
+	gdbRegPC = 17
+	gdbRegSP = 18
+	gdbRegDT = 19
+	gdbRegST = 20
+
+	gdbRegCount = 21
+)
+
+func gdbRegSize(n int) int {
+	if n == gdbRegI || n == gdbRegPC {
+		return 2
+	}
+	return 1
+}
+
+// gdbTargetXML describes the register set to gdb
+var gdbTargetXML = func() string {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.chip8.core">
+`)
+	for x := 0; x < 16; x++ {
+		fmt.Fprintf(&sb, "    <reg name=\"v%x\" bitsize=\"8\" type=\"uint8\" regnum=\"%d\"/>\n", x, x)
+	}
+	sb.WriteString(`    <reg name="i" bitsize="16" type="data_ptr" regnum="16"/>
+    <reg name="pc" bitsize="16" type="code_ptr" regnum="17"/>
+    <reg name="sp" bitsize="8" type="uint8" regnum="18"/>
+    <reg name="dt" bitsize="8" type="uint8" regnum="19"/>
+    <reg name="st" bitsize="8" type="uint8" regnum="20"/>
+  </feature>
+</target>
+`)
+	return sb.String()
+}()
+
+// ServeGDB waits for gdb on addr to drive dbg, one connection
+// at a time, and blocks until the listener fails
+func ServeGDB(dbg *debugger, addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Not able to listen for gdb: %v", err)
+	}
+
+	log.Infof("Waiting for gdb on %s: target remote %s", addr, ln.Addr())
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("Not able to accept gdb: %v", err)
+		}
+
+		log.Infof("gdb connected from %s", conn.RemoteAddr())
+		s := &gdbSession{dbg: dbg, conn: conn}
+		s.run()
+		conn.Close()
+		log.Infof("gdb disconnected")
+	}
+}
+
+// gdbSession is one gdb connection
+type gdbSession struct {
+	dbg  *debugger
+	conn net.Conn
+
+	wmu sync.Mutex
+
+	// set once gdb detached or killed the session
+	done bool
+}
+
+func (s *gdbSession) run() {
+	packets := make(chan string)
+	interrupts := make(chan struct{}, 1)
+	go s.read(packets, interrupts)
+
+	// set while the vm is running
+	var stopped <-chan stopReason
+
+	for !s.done {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				if stopped != nil {
+					// gdb went away, leave the vm stopped for the next one
+					s.dbg.interrupt()
+					<-stopped
+				}
+				return
+			}
+
+			if stopped != nil {
+				// gdb only interrupts while the target runs
+				log.Debugf("gdb: ignoring %q while running", packet)
+				continue
+			}
+
+			reply, resumed := s.handle(packet)
+			if resumed != nil {
+				stopped = resumed
+				continue
+			}
+			if s.done && reply == "" {
+				// killed, nothing to say
+				return
+			}
+			s.send(reply)
+
+		case <-interrupts:
+			s.dbg.interrupt()
+
+		case reason := <-stopped:
+			stopped = nil
+			s.send(gdbStopReply(reason))
+		}
+	}
+}
+
+// read splits the byte stream from gdb into packets and interrupts
+func (s *gdbSession) read(packets chan<- string, interrupts chan<- struct{}) {
+	defer close(packets)
+
+	noAck := false
+	r := bufio.NewReader(s.conn)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch b {
+		case 0x03:
+			select {
+			case interrupts <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			data = data[:len(data)-1]
+
+			var sum [2]byte
+			if _, err := r.Read(sum[:1]); err != nil {
+				return
+			}
+			if _, err := r.Read(sum[1:]); err != nil {
+				return
+			}
+
+			if !noAck {
+				want, _ := strconv.ParseUint(string(sum[:]), 16, 8)
+				if byte(want) != gdbChecksum(data) {
+					s.write("-")
+					continue
+				}
+				s.write("+")
+				noAck = data == "QStartNoAckMode"
+			}
+			packets <- gdbUnescape(data)
+		}
+		// '+' and '-' acks need no handling, nothing gets resent
+	}
+}
+
+func (s *gdbSession) write(data string) {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if _, err := s.conn.Write([]byte(data)); err != nil {
+		log.Debugf("gdb: write failed: %v", err)
+	}
+}
+
+func (s *gdbSession) send(reply string) {
+	escaped := gdbEscape(reply)
+	s.write(fmt.Sprintf("$%s#%02x", escaped, gdbChecksum(escaped)))
+}
+
+func gdbChecksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func gdbEscape(data string) string {
+	if !strings.ContainsAny(data, "#$}*") {
+		return data
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		switch c := data[i]; c {
+		case '#', '$', '}', '*':
+			sb.WriteByte('}')
+			sb.WriteByte(c ^ 0x20)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+func gdbUnescape(data string) string {
+	if !strings.Contains(data, "}") {
+		return data
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(data); i++ {
+		if data[i] == '}' && i+1 < len(data) {
+			i++
+			sb.WriteByte(data[i] ^ 0x20)
+		} else {
+			sb.WriteByte(data[i])
+		}
+	}
+	return sb.String()
+}
+
+// gdbStopReply tells gdb why the vm stopped
+func gdbStopReply(reason stopReason) string {
+	if reason.interrupted {
+		return "T02"
+	}
+	if _, ok := reason.breakpoint(); ok {
+		return "T05swbreak:;"
+	}
+	if hit, ok := reason.watchpoint(); ok {
+		kind := "awatch"
+		switch hit.Watchpoint.Kind {
+		case chip8.AccessWrite:
+			kind = "watch"
+		case chip8.AccessRead:
+			kind = "rwatch"
+		}
+		return fmt.Sprintf("T05%s:%x;", kind, hit.Addr)
+	}
+	if reason.historyStart() {
+		return "T05replaylog:begin;"
+	}
+	if reason.fault() {
+		// SIGILL, the program hit something it can't run
+		return "T04"
+	}
+	return "T05"
+}
+
+// handle answers a packet, or resumes the vm and
+// returns where its stop will be reported
+func (s *gdbSession) handle(packet string) (string, <-chan stopReason) {
+	vm := s.dbg.vm
+
+	if packet == "" {
+		return "", nil
+	}
+
+	switch packet[0] {
+	case '?':
+		return "T05", nil
+
+	case 'g':
+		var sb strings.Builder
+		state := vm.State()
+		for n := 0; n < gdbRegCount; n++ {
+			sb.WriteString(gdbRegHex(&state, n))
+		}
+		return sb.String(), nil
+
+	case 'G':
+		state := vm.State()
+		data := packet[1:]
+		for n := 0; n < gdbRegCount && data != ""; n++ {
+			size := gdbRegSize(n) * 2
+			if len(data) < size {
+				return "E01", nil
+			}
+			if err := gdbSetReg(&state, n, data[:size]); err != nil {
+				return "E01", nil
+			}
+			data = data[size:]
+		}
+		vm.SetState(state)
+		return "OK", nil
+
+	case 'p':
+		n, err := strconv.ParseUint(packet[1:], 16, 8)
+		if err != nil || n >= gdbRegCount {
+			return "E01", nil
+		}
+		state := vm.State()
+		return gdbRegHex(&state, int(n)), nil
+
+	case 'P':
+		parts := strings.SplitN(packet[1:], "=", 2)
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || n >= gdbRegCount || len(parts) != 2 {
+			return "E01", nil
+		}
+		state := vm.State()
+		if err := gdbSetReg(&state, int(n), parts[1]); err != nil {
+			return "E01", nil
+		}
+		vm.SetState(state)
+		return "OK", nil
+
+	case 'm':
+		addr, length, ok := gdbAddrLen(packet[1:])
+		if !ok || addr >= chip8.RAMSize {
+			return "E01", nil
+		}
+		// before converting, a huge length would wrap around
+		if length > chip8.RAMSize-addr {
+			length = chip8.RAMSize - addr
+		}
+		return hex.EncodeToString(vm.ReadMemory(uint16(addr), int(length))), nil
+
+	case 'M':
+		parts := strings.SplitN(packet[1:], ":", 2)
+		addr, _, ok := gdbAddrLen(parts[0])
+		if !ok || len(parts) != 2 || addr >= chip8.RAMSize {
+			return "E01", nil
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil {
+			return "E01", nil
+		}
+		vm.WriteMemory(uint16(addr), data)
+		return "OK", nil
+
+	case 'Z', 'z':
+		return s.handleBreakpoint(packet), nil
+
+	case 'c', 's':
+		if len(packet) > 1 {
+			addr, err := strconv.ParseUint(packet[1:], 16, 16)
+			if err != nil {
+				return "E01", nil
+			}
+			state := vm.State()
+			state.PC = uint16(addr)
+			vm.SetState(state)
+		}
+		if packet[0] == 's' {
+			return gdbStopReply(s.dbg.step()), nil
+		}
+		return "", s.dbg.resume()
+
+	case 'b':
+		// reverse execution, through the recorded history
+		switch packet {
+		case "bs":
+			return gdbStopReply(s.dbg.stepBack()), nil
+		case "bc":
+			return gdbStopReply(s.dbg.reverseContinue()), nil
+		}
+		return "", nil
+
+	case 'v':
+		return s.handleV(packet)
+
+	case 'q', 'Q':
+		return s.handleQuery(packet), nil
+
+	case 'H', 'T':
+		// a single thread, whatever is asked for
+		return "OK", nil
+
+	case 'D':
+		s.dbg.release()
+		s.done = true
+		return "OK", nil
+
+	case 'k':
+		vm.Reset()
+		s.done = true
+		return "", nil
+	}
+
+	return "", nil
+}
+
+func (s *gdbSession) handleV(packet string) (string, <-chan stopReason) {
+	switch {
+	case packet == "vCont?":
+		return "vCont;c;C;s;S", nil
+	case strings.HasPrefix(packet, "vCont;"):
+		// one thread, so the first action is the one
+		action := strings.SplitN(packet[len("vCont;"):], ";", 2)[0]
+		if action == "" {
+			return "E01", nil
+		}
+		switch action[0] {
+		case 's', 'S':
+			return gdbStopReply(s.dbg.step()), nil
+		case 'c', 'C':
+			return "", s.dbg.resume()
+		}
+		return "E01", nil
+	}
+
+	// vMustReplyEmpty and anything unsupported
+	return "", nil
+}
+
+func (s *gdbSession) handleQuery(packet string) string {
+	switch {
+	case strings.HasPrefix(packet, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+;vContSupported+;ReverseStep+;ReverseContinue+"
+	case packet == "QStartNoAckMode":
+		// read switched acks off once it acked this
+		return "OK"
+	case strings.HasPrefix(packet, "qXfer:features:read:target.xml:"):
+		offset, length, ok := gdbAddrLen(packet[len("qXfer:features:read:target.xml:"):])
+		if !ok {
+			return "E01"
+		}
+		if offset >= uint64(len(gdbTargetXML)) {
+			return "l"
+		}
+		end := offset + length
+		if end >= uint64(len(gdbTargetXML)) {
+			return "l" + gdbTargetXML[offset:]
+		}
+		return "m" + gdbTargetXML[offset:end]
+	case packet == "qAttached":
+		return "1"
+	case packet == "qC":
+		return "QC1"
+	case packet == "qfThreadInfo":
+		return "m1"
+	case packet == "qsThreadInfo":
+		return "l"
+	case strings.HasPrefix(packet, "qSymbol"):
+		return "OK"
+	case strings.HasPrefix(packet, "qRcmd,"):
+		command, err := hex.DecodeString(packet[len("qRcmd,"):])
+		if err != nil {
+			return "E01"
+		}
+		return hex.EncodeToString([]byte(s.monitor(string(command))))
+	}
+	return ""
+}
+
+// monitor runs a "monitor" command typed into gdb
+func (s *gdbSession) monitor(command string) string {
+	vm := s.dbg.vm
+
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		fields = []string{"help"}
+	}
+
+	switch fields[0] {
+	case "history":
+		r, err := vm.History()
+		if err != nil {
+			return err.Error() + "\n"
+		}
+		return fmt.Sprintf("at instruction %d, history goes from %d to %d\n", r.Now, r.Oldest, r.End)
+
+	case "goto":
+		if len(fields) != 2 {
+			return "usage: monitor goto <instruction>\n"
+		}
+		n, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return fmt.Sprintf("bad instruction number %q\n", fields[1])
+		}
+		if err := vm.Goto(n); err != nil {
+			return err.Error() + "\n"
+		}
+		return fmt.Sprintf("at instruction %d, pc %03X\n", n, vm.PC())
+
+	case "back-frame":
+		if err := vm.StepBackFrame(); err != nil {
+			return err.Error() + "\n"
+		}
+		r, _ := vm.History()
+		return fmt.Sprintf("at instruction %d, pc %03X\n", r.Now, vm.PC())
+	}
+
+	return "commands: history, goto <instruction>, back-frame\n"
+}
+
+// handleBreakpoint sets (Z) or clears (z) a breakpoint or watchpoint:
+// types 0 and 1 break on execution, 2 watch writes, 3 reads, 4 both
+func (s *gdbSession) handleBreakpoint(packet string) string {
+	vm := s.dbg.vm
+
+	parts := strings.Split(packet[1:], ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	addr, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil || addr >= chip8.RAMSize {
+		return "E01"
+	}
+	length, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	set := packet[0] == 'Z'
+
+	var kind chip8.AccessKind
+	switch parts[0] {
+	case "0", "1":
+		if set {
+			vm.AddBreakpoint(uint16(addr))
+		} else {
+			vm.RemoveBreakpoint(uint16(addr))
+		}
+		return "OK"
+	case "2":
+		kind = chip8.AccessWrite
+	case "3":
+		kind = chip8.AccessRead
+	case "4":
+		kind = chip8.AccessRead | chip8.AccessWrite
+	default:
+		return ""
+	}
+
+	w := chip8.Watchpoint{Addr: uint16(addr), Len: uint16(length), Kind: kind}
+	if set {
+		vm.AddWatchpoint(w)
+	} else {
+		vm.RemoveWatchpoint(w)
+	}
+	return "OK"
+}
+
+// gdbAddrLen parses "addr,length" in hex
+func gdbAddrLen(s string) (uint64, uint64, bool) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err1 := strconv.ParseUint(parts[0], 16, 64)
+	length, err2 := strconv.ParseUint(parts[1], 16, 64)
+	return addr, length, err1 == nil && err2 == nil
+}
+
+func gdbRegHex(state *chip8.State, n int) string {
+	switch n {
+	case gdbRegI:
+		return fmt.Sprintf("%04x", state.I)
+	case gdbRegPC:
+		return fmt.Sprintf("%04x", state.PC)
+	case gdbRegSP:
+		return fmt.Sprintf("%02x", state.SP)
+	case gdbRegDT:
+		return fmt.Sprintf("%02x", state.DT)
+	case gdbRegST:
+		return fmt.Sprintf("%02x", state.ST)
+	}
+	return fmt.Sprintf("%02x", state.V[n])
+}
+
+func gdbSetReg(state *chip8.State, n int, value string) error {
+	v, err := strconv.ParseUint(value, 16, 8*gdbRegSize(n))
+	if err != nil {
+		return err
+	}
+
+	switch n {
+	case gdbRegI:
+		state.I = uint16(v)
+	case gdbRegPC:
+		state.PC = uint16(v)
+	case gdbRegSP:
+		state.SP = byte(v)
+	case gdbRegDT:
+		state.DT = byte(v)
+	case gdbRegST:
+		state.ST = byte(v)
+	default:
+		state.V[n] = byte(v)
+	}
+	return nil
+}
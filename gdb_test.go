@@ -0,0 +1,128 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"chip8-emulator/chip8"
+)
+
+// gdbClient is the gdb end of a session over a pipe
+type gdbClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newGDBClient starts a session on a vm looping at 0x200
+func newGDBClient(t *testing.T) (*gdbClient, *debugger, chan struct{}) {
+	vm := chip8.New(chip8.Config{})
+	// 0x200: JP 0x200
+	if err := vm.LoadROM([]byte{0x12, 0x00}); err != nil {
+		t.Fatal(err)
+	}
+	return newGDBClientFor(t, newDebugger("test", vm))
+}
+
+// newGDBClientFor starts a session driving dbg
+func newGDBClientFor(t *testing.T, dbg *debugger) (*gdbClient, *debugger, chan struct{}) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s := &gdbSession{dbg: dbg, conn: server}
+		s.run()
+		server.Close()
+		close(done)
+	}()
+	return &gdbClient{conn: client, r: bufio.NewReader(client)}, dbg, done
+}
+
+// request sends packet and returns the reply, past the ack
+func (c *gdbClient) request(t *testing.T, packet string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, gdbChecksum(packet))
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		t.Fatalf("%s: got ack %q, %v", packet, ack, err)
+	}
+	return c.reply(t)
+}
+
+func (c *gdbClient) reply(t *testing.T) string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sum [2]byte
+	if _, err := c.r.Read(sum[:]); err != nil {
+		t.Fatal(err)
+	}
+	return data[:len(data)-1]
+}
+
+func TestGDBPackets(t *testing.T) {
+	c, _, done := newGDBClient(t)
+
+	tests := []struct{ packet, want string }{
+		{"vCont?", "vCont;c;C;s;S"},
+		{"vCont;", "E01"},
+		{"vCont;x", "E01"},
+		{"vCont;s", "T05"},
+		{"m200,2", "1200"},
+		{"m200,ffffffffffffffff", ""},
+		{"m1000,1", "E01"},
+	}
+	for _, tt := range tests {
+		got := c.request(t, tt.packet)
+		if tt.packet == "m200,ffffffffffffffff" {
+			// the rest of ram, from 0x200
+			if len(got) != 2*(chip8.RAMSize-0x200) {
+				t.Errorf("%s: got %d hex digits", tt.packet, len(got))
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.packet, got, tt.want)
+		}
+	}
+
+	c.conn.Close()
+	<-done
+}
+
+func TestGDBDisconnectWhileRunning(t *testing.T) {
+	c, dbg, done := newGDBClient(t)
+
+	// continue, then hang up without interrupting
+	fmt.Fprintf(c.conn, "$c#%02x", gdbChecksum("c"))
+	if ack, err := c.r.ReadByte(); err != nil || ack != '+' {
+		t.Fatalf("got ack %q, %v", ack, err)
+	}
+	c.conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session didn't end")
+	}
+	if dbg.running() {
+		t.Fatal("vm still running after gdb went away")
+	}
+
+	// the next gdb gets its stops reported
+	c, _, done = newGDBClientFor(t, dbg)
+	fmt.Fprintf(c.conn, "$c#%02x", gdbChecksum("c"))
+	c.r.ReadByte()
+	c.conn.Write([]byte{0x03})
+	if got := c.reply(t); got != "T02" {
+		t.Errorf("got %q after interrupting, want T02", got)
+	}
+	c.conn.Close()
+	<-done
+}
@@ -1,8 +1,10 @@
 module chip8-emulator
 
-go 1.15
+go 1.16
 
 require (
+	github.com/google/pprof v0.0.0-20210720184732-4bb14d4b1be1
+	github.com/gorilla/websocket v1.4.2
 	github.com/sirupsen/logrus v1.7.0
 	golang.org/x/exp v0.0.0-20190731235908-ec7cb31e5a56
 	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
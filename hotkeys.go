@@ -0,0 +1,164 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/mobile/event/key"
+
+	"chip8-emulator/chip8"
+)
+
+// Emulator hotkeys, none of these overlap the keypad keys (0-9, A-F):
+//
+//	= / +    speed up
+//	-        slow down
+//	Tab      fast forward while held
+//	P        pause / resume
+//	N        advance a single frame while paused, with Shift
+//	         step back one (with -history)
+//	F5       reset, with Shift a hard reset (clears ram)
+//	F6       reload the rom from disk
+//	Back     back to the rom menu (with -rom-dir)
+const (
+	HotkeySpeedUp     = key.CodeEqualSign
+	HotkeySlowDown    = key.CodeHyphenMinus
+	HotkeyFastForward = key.CodeTab
+	HotkeyPause       = key.CodeP
+	HotkeyFrameStep   = key.CodeN
+	HotkeyReset       = key.CodeF5
+	HotkeyReload      = key.CodeF6
+	HotkeyMenu        = key.CodeDeleteBackspace
+)
+
+// How long the on-screen speed note stays up after a change
+const OSDTimeout = 2 * time.Second
+
+// osd is the status line drawn over the game. shiny can't
+// retitle a window once it's open, so speed changes show here.
+type osd struct {
+	text  string
+	until time.Time
+}
+
+func (o *osd) show(text string) {
+	o.text = text
+	o.until = time.Now().Add(OSDTimeout)
+}
+
+// message returns the last text shown until it times out
+func (o *osd) message() string {
+	if time.Now().Before(o.until) {
+		return o.text
+	}
+	return ""
+}
+
+// current returns the text to draw right now, if any
+func (o *osd) current(vm *chip8.VM) string {
+	if text := o.message(); text != "" {
+		return text
+	}
+
+	// always visible while the speed is out of the ordinary
+	if vm.Paused() {
+		return vm.SpeedLabel()
+	}
+	return ""
+}
+
+// handleHotkey applies e if it is an emulator hotkey,
+// returns false for anything that should go to the keypad
+func handleHotkey(vm *chip8.VM, e key.Event, o *osd) bool {
+	switch e.Code {
+	case HotkeySpeedUp, key.CodeKeypadPlusSign:
+		if e.Direction == key.DirPress {
+			vm.SpeedUp()
+		}
+	case HotkeySlowDown, key.CodeKeypadHyphenMinus:
+		if e.Direction == key.DirPress {
+			vm.SlowDown()
+		}
+	case HotkeyFastForward:
+		if e.Direction == key.DirPress || e.Direction == key.DirRelease {
+			vm.SetFastForward(e.Direction == key.DirPress)
+		}
+	case HotkeyPause:
+		if e.Direction == key.DirPress {
+			vm.SetPaused(!vm.Paused())
+		}
+	case HotkeyReset:
+		if e.Direction == key.DirPress {
+			if e.Modifiers&key.ModShift != 0 {
+				vm.HardReset()
+				o.show("hard reset")
+			} else {
+				vm.Reset()
+				o.show("reset")
+			}
+			log.Infof("Vm %s", o.text)
+		}
+		return true
+	case HotkeyFrameStep:
+		if e.Direction == key.DirPress && e.Modifiers&key.ModShift != 0 {
+			if !vm.Paused() {
+				return true
+			}
+			if err := vm.StepBackFrame(); err != nil {
+				o.show("can't go back")
+				log.Warnf("Frame step back: %v", err)
+			} else {
+				r, _ := vm.History()
+				o.show(fmt.Sprintf("back to #%d", r.Now))
+			}
+			return true
+		}
+		if e.Direction == key.DirPress {
+			if err := vm.AdvanceFrame(); err != nil {
+				log.Errorf("Frame advance stopped: %v", err)
+			}
+		}
+	default:
+		return false
+	}
+
+	if e.Direction == key.DirPress {
+		o.show(vm.SpeedLabel())
+		log.Infof("Speed: %s", vm.SpeedLabel())
+	}
+	return true
+}
+
+// colours of the on screen display, made once rather than on every draw
+var (
+	osdBackground = image.NewUniform(Blue)
+	osdForeground = image.NewUniform(White)
+)
+
+// drawOSD writes text in the top left corner of img
+func drawOSD(img *image.RGBA, text string) {
+	face := basicfont.Face7x13
+	width := font.MeasureString(face, text).Ceil()
+
+	// dark backdrop so the text reads over lit pixels
+	box := image.Rect(0, 0, width+8, face.Height+6)
+	draw.Draw(img, box, osdBackground, image.Point{}, draw.Src)
+
+	d := font.Drawer{
+		Dst:  img,
+		Src:  osdForeground,
+		Face: face,
+		Dot:  fixed.P(4, face.Ascent+3),
+	}
+	d.DrawString(text)
+}
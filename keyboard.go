@@ -1,29 +1,62 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
 	"golang.org/x/mobile/event/key"
-	"time"
+
+	"chip8-emulator/chip8"
 )
 
-// LastPressedKey stores the what and when the key was pressed
-type LastPressedKey struct {
-	code key.Code
-	time time.Time
+// Keyboard maps the window's key events onto the chip-8 keypad
+type Keyboard struct {
+	keypad      [4][4]byte
+	keyboardMap map[key.Code]byte
+	vm          *chip8.VM
 }
 
-// Keyboard, todo: document members
-type Keyboard struct {
-	keypad             [4][4]byte
-	keyboardState      map[key.Code]key.Direction
-	keyboardMap        map[key.Code]byte
-	reverseKeyboardMap map[byte]key.Code
-	lastPressedKey     LastPressedKey
+// named keys a rom keymap may use on top of letters and digits
+var namedKeyCodes = map[string]key.Code{
+	"SPACE": key.CodeSpacebar,
+	"ENTER": key.CodeReturnEnter,
+	"UP":    key.CodeUpArrow,
+	"DOWN":  key.CodeDownArrow,
+	"LEFT":  key.CodeLeftArrow,
+	"RIGHT": key.CodeRightArrow,
 }
 
-func newKeyboard() *Keyboard {
-	k := Keyboard{}
+// hostKeyCode turns a keymap key name ("W", "7", "Space") into its code
+func hostKeyCode(name string) (key.Code, bool) {
+	name = strings.ToUpper(name)
+	if code, ok := namedKeyCodes[name]; ok {
+		return code, true
+	}
+
+	if len(name) != 1 {
+		return 0, false
+	}
 
-	k.keyboardState = make(map[key.Code]key.Direction)
+	c := name[0]
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return key.CodeA + key.Code(c-'A'), true
+	case c == '0':
+		return key.Code0, true
+	case c >= '1' && c <= '9':
+		return key.Code1 + key.Code(c-'1'), true
+	}
+
+	return 0, false
+}
+
+// newKeyboard sets up the default 0-9/A-F layout,
+// with the rom's own keymap (if any) on top
+func newKeyboard(vm *chip8.VM, keymap map[string]byte) *Keyboard {
+	k := Keyboard{vm: vm}
 
 	// define keypad
 	keypad := [4][4]byte{
@@ -52,9 +85,13 @@ func newKeyboard() *Keyboard {
 		key.CodeF: keypad[3][3],
 	}
 
-	k.reverseKeyboardMap = make(map[byte]key.Code)
-	for keyy, val := range k.keyboardMap {
-		k.reverseKeyboardMap[val] = keyy
+	for name, chip8Key := range keymap {
+		code, ok := hostKeyCode(name)
+		if !ok || chip8Key > 0xF {
+			log.Warnf("Ignoring keymap entry %q: %d", name, chip8Key)
+			continue
+		}
+		k.keyboardMap[code] = chip8Key
 	}
 
 	return &k
@@ -63,34 +100,18 @@ func newKeyboard() *Keyboard {
 func eligibleKeyEvent(event key.Event, k *Keyboard) bool {
 	_, present := k.keyboardMap[event.Code]
 
-	// skip DirRelease and DirNone events
+	// skip DirNone events
 	// DirNone indicates that the key was not released
 	// or Pressed; it remains static..
 
-	return present && (event.Direction == key.DirPress || event.Direction == key.DirNone)
+	return present && (event.Direction == key.DirPress || event.Direction == key.DirRelease)
 }
 
-// ProcessKeyEvent ..
+// ProcessKeyEvent forwards mapped key presses and releases to the vm
 func (k *Keyboard) ProcessKeyEvent(event key.Event) {
 	if !eligibleKeyEvent(event, k) {
 		return
 	}
 
-	// record last key press
-	k.lastPressedKey.code = event.Code
-	k.lastPressedKey.time = time.Now()
-
-	// Put the `Pressed` event inside the keyboard state.
-	k.keyboardState[event.Code] = key.DirPress
-}
-
-// GetKeyState ..
-func (k *Keyboard) GetKeyState(chip8Key byte) key.Direction {
-	res, _ := k.reverseKeyboardMap[chip8Key]
-	dir, _ := k.keyboardState[res]
-
-	// side-effect: reset key state since we're consuming this
-	k.keyboardState[res] = key.DirRelease
-
-	return dir
+	k.vm.SetKey(k.keyboardMap[event.Code], event.Direction == key.DirPress)
 }
@@ -0,0 +1,271 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Rom launcher for -rom-dir: a menu drawn straight into the
+// chip-8 framebuffer with the built in font, driven by the keypad.
+//
+//	2 / 8    move up / down
+//	4 / 6    page up / down
+//	5        start the selected rom
+//
+// HotkeyMenu brings the menu back up from a running game.
+
+const (
+	// Glyphs are 4x5 with a pixel of spacing either way
+	launcherGlyphWidth  = 5
+	launcherLineHeight  = 6
+	launcherVisibleRows = chip8.EmuHeight / launcherLineHeight
+
+	// Characters of a title that fit next to the cursor
+	launcherTitleChars = chip8.EmuWidth/launcherGlyphWidth - 1
+
+	// Frames per character step when scrolling a long title
+	launcherScrollFrames = 8
+)
+
+// Extensions the launcher lists, anything ReadRom can open
+var launcherExtensions = map[string]bool{
+	".ch8": true,
+	".c8":  true,
+	".sc8": true,
+	".xo8": true,
+	".rom": true,
+	".zip": true,
+	".gz":  true,
+	".hex": true,
+}
+
+// launcherEntry is one rom in the menu
+type launcherEntry struct {
+	path  string
+	title string
+}
+
+// launcher is the menu state of one window
+type launcher struct {
+	entries  []launcherEntry
+	selected int
+	top      int
+
+	// counts rendered frames, for scrolling long titles
+	frames int
+
+	db       chip8.RomDB
+	vmConfig *VMConfig
+}
+
+// scanRomDir lists the roms in dir, titled from the rom
+// database when they're in it and by file name otherwise
+func scanRomDir(dir string, db chip8.RomDB) ([]launcherEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []launcherEntry
+	for _, f := range files {
+		ext := strings.ToLower(filepath.Ext(f.Name()))
+		if f.IsDir() || !launcherExtensions[ext] {
+			continue
+		}
+
+		entry := launcherEntry{
+			path:  filepath.Join(dir, f.Name()),
+			title: strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())),
+		}
+
+		if rom, err := ReadRom(entry.path, ""); err == nil {
+			if info, ok := db.Lookup(rom); ok && info.Title != "" {
+				entry.title = info.Title
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no roms found in %s", dir)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToUpper(entries[i].title) < strings.ToUpper(entries[j].title)
+	})
+
+	log.Infof("Found %d roms in %s", len(entries), dir)
+	return entries, nil
+}
+
+func newLauncher(entries []launcherEntry, db chip8.RomDB, vmConfig *VMConfig) *launcher {
+	return &launcher{entries: entries, db: db, vmConfig: vmConfig}
+}
+
+// move shifts the selection by delta entries, keeping it on screen
+func (l *launcher) move(delta int) {
+	l.selected += delta
+	if l.selected < 0 {
+		l.selected = 0
+	}
+	if l.selected > len(l.entries)-1 {
+		l.selected = len(l.entries) - 1
+	}
+
+	if l.selected < l.top {
+		l.top = l.selected
+	} else if l.selected >= l.top+launcherVisibleRows {
+		l.top = l.selected - launcherVisibleRows + 1
+	}
+
+	l.frames = 0
+}
+
+// press handles a keypad key, returning true when
+// the selected rom should be started
+func (l *launcher) press(k byte) bool {
+	switch k {
+	case 0x2:
+		l.move(-1)
+	case 0x8:
+		l.move(1)
+	case 0x4:
+		l.move(-launcherVisibleRows)
+	case 0x6:
+		l.move(launcherVisibleRows)
+	case 0x5:
+		return true
+	}
+	return false
+}
+
+// load reads the selected rom and works out what to run it with
+func (l *launcher) load() ([]byte, romSettings, string, error) {
+	entry := l.entries[l.selected]
+
+	rom, err := ReadRom(entry.path, "")
+	if err != nil {
+		return nil, romSettings{}, "", err
+	}
+
+	settings := resolveRomSettings(rom, entry.path, l.db, l.vmConfig)
+	return rom, settings, entry.path, nil
+}
+
+// Framebuffer renders the menu, a page of titles with
+// a cursor on the selected one
+func (l *launcher) Framebuffer() chip8.Framebuffer {
+	var fb chip8.Framebuffer
+
+	l.frames++
+
+	for row := 0; row < launcherVisibleRows; row++ {
+		i := l.top + row
+		if i >= len(l.entries) {
+			break
+		}
+
+		y := row*launcherLineHeight + 1
+		title := strings.ToUpper(l.entries[i].title)
+
+		if i == l.selected {
+			drawLauncherText(&fb, 0, y, ">")
+			title = scrollTitle(title, l.frames/launcherScrollFrames)
+		}
+
+		if len(title) > launcherTitleChars {
+			title = title[:launcherTitleChars]
+		}
+		drawLauncherText(&fb, launcherGlyphWidth, y, title)
+	}
+
+	return fb
+}
+
+// scrollTitle rotates titles too long for the screen by step characters
+func scrollTitle(title string, step int) string {
+	if len(title) <= launcherTitleChars {
+		return title
+	}
+
+	looped := title + "   " + title
+	start := step % (len(title) + 3)
+	return looped[start:]
+}
+
+// drawLauncherText draws s at x, y, characters without a glyph are blank
+func drawLauncherText(fb *chip8.Framebuffer, x, y int, s string) {
+	for _, c := range s {
+		glyph, ok := launcherGlyph(c)
+		if ok {
+			for row, bits := range glyph {
+				for col := 0; col < 4; col++ {
+					px, py := x+col, y+row
+					if bits&(0x80>>uint(col)) != 0 && px < chip8.EmuWidth && py < chip8.EmuHeight {
+						fb[py][px] = 1
+					}
+				}
+			}
+		}
+		x += launcherGlyphWidth
+	}
+}
+
+// launcherGlyph looks c up in the chip-8 font,
+// falling back to launcherFont for everything but hex digits
+func launcherGlyph(c rune) ([5]byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return chip8.DigitSprite(byte(c - '0')), true
+	case c >= 'A' && c <= 'F':
+		return chip8.DigitSprite(byte(c-'A') + 0xA), true
+	}
+
+	glyph, ok := launcherFont[c]
+	return glyph, ok
+}
+
+// launcherFont completes the chip-8 hex font with the rest
+// of the alphabet and some punctuation, drawn the same way
+var launcherFont = map[rune][5]byte{
+	'G':  {0xF0, 0x80, 0xB0, 0x90, 0xF0},
+	'H':  {0x90, 0x90, 0xF0, 0x90, 0x90},
+	'I':  {0xE0, 0x40, 0x40, 0x40, 0xE0},
+	'J':  {0x30, 0x10, 0x10, 0x90, 0x60},
+	'K':  {0x90, 0xA0, 0xC0, 0xA0, 0x90},
+	'L':  {0x80, 0x80, 0x80, 0x80, 0xF0},
+	'M':  {0x90, 0xF0, 0xF0, 0x90, 0x90},
+	'N':  {0x90, 0xD0, 0xB0, 0x90, 0x90},
+	'O':  {0x60, 0x90, 0x90, 0x90, 0x60},
+	'P':  {0xE0, 0x90, 0xE0, 0x80, 0x80},
+	'Q':  {0x60, 0x90, 0x90, 0xB0, 0x70},
+	'R':  {0xE0, 0x90, 0xE0, 0xA0, 0x90},
+	'S':  {0x70, 0x80, 0x60, 0x10, 0xE0},
+	'T':  {0xF0, 0x40, 0x40, 0x40, 0x40},
+	'U':  {0x90, 0x90, 0x90, 0x90, 0xF0},
+	'V':  {0x90, 0x90, 0x90, 0x90, 0x60},
+	'W':  {0x90, 0x90, 0xF0, 0xF0, 0x90},
+	'X':  {0x90, 0x90, 0x60, 0x90, 0x90},
+	'Y':  {0xA0, 0xA0, 0x40, 0x40, 0x40},
+	'Z':  {0xF0, 0x10, 0x60, 0x80, 0xF0},
+	'-':  {0x00, 0x00, 0xF0, 0x00, 0x00},
+	'_':  {0x00, 0x00, 0x00, 0x00, 0xF0},
+	'.':  {0x00, 0x00, 0x00, 0x00, 0x40},
+	'!':  {0x40, 0x40, 0x40, 0x00, 0x40},
+	'?':  {0xE0, 0x10, 0x60, 0x00, 0x40},
+	'(':  {0x20, 0x40, 0x40, 0x40, 0x20},
+	')':  {0x40, 0x20, 0x20, 0x20, 0x40},
+	'>':  {0x80, 0x40, 0x20, 0x40, 0x80},
+	'\'': {0x40, 0x40, 0x00, 0x00, 0x00},
+}
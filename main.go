@@ -1,65 +1,380 @@
+//go:build !js
+// +build !js
+
 package main
 
 import (
 	"flag"
-	"time"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
-)
 
-// Refer to: http://mattmik.com/files/chip8/mastering/chip8.html
-// Excellent guide to understanding everything about chip8 emulation
-const (
-	CPUTickerSpeed = time.Duration(5) * time.Millisecond
+	"chip8-emulator/chip8"
 )
 
-var (
-	// EmulatorTick ..
-	EmulatorTick = time.NewTicker(CPUTickerSpeed)
-)
+// VMConfig ...
+type VMConfig struct {
+	// one vm gets started per rom (times instances)
+	romFilePaths []string
+	instances    int
+
+	// file to run out of zip archives
+	romEntry string
+
+	// romFilePaths name roms compiled into the
+	// binary rather than files, see transpile.go
+	builtin bool
+
+	// romDir, when set, starts on a menu of the roms in it
+	// instead of running romFilePaths
+	romDir string
+
+	// seed for the first vm, the rest count up from it.
+	// 0 seeds every vm off the clock
+	seed int64
+
+	// cpu speed, instructions executed per 60 Hz frame.
+	// 0 leaves it to the rom database
+	instructionsPerFrame int
+
+	// rom database overrides, left empty (nil) when not given
+	romDBPath string
+	platform  string
+	quirks    *chip8.Quirks
+	palette   *Palette
+
+	// serveAddr, when set, runs the web frontend on
+	// this address instead of opening a window
+	serveAddr string
+
+	// tracePath, when set, records an execution trace there
+	tracePath string
+
+	// profilePath, when set, writes a pprof profile there on exit
+	profilePath string
+
+	// coveragePath, when set, adds the coverage of this run there on exit
+	coveragePath string
+
+	// watchpoints to pause the vms on
+	watchpoints []chip8.Watchpoint
+
+	// gdbAddr, when set, hands the first vm to gdb on this address
+	gdbAddr string
+
+	// history records the vms for stepping back
+	history bool
+
+	// recompile runs the vms on the block recompiler
+	recompile bool
+
+	// symbols label addresses in debug output and faults
+	symbols *chip8.Symbols
+}
+
+// instance is one vm running in the process
+type instance struct {
+	name    string
+	vm      *chip8.VM
+	palette Palette
+	keymap  map[string]byte
+
+	// the rom it started with and where it came from, for reloading it
+	rom         []byte
+	romFilePath string
+	romEntry    string
+
+	// rom menu the window starts on, nil unless -rom-dir is given
+	launcher *launcher
+}
 
 func main() {
 	setupLogging()
 
+	if runCommand(os.Args[1:]) {
+		return
+	}
+
 	log.Info("Booting up CHIP-8...")
 
 	conf := parseConfig()
-	vm := InitVM(&conf)
-
-	// todo: document
-	go func() {
-		// @hack: sleep for 2 seconds to ensure the window (in screen struct) is up and running
-		time.Sleep(2 * time.Second)
-		log.Debugln("\n\n Rom file: ",
-			vm.memory.ram[ProgramAreaStart:ProgramAreaStart+vm.memory.romSize])
-
-		for {
-			select {
-			case <-EmulatorTick.C:
-				vm.Tick()
+	instances := InitVMs(&conf)
+
+	handleSignals()
+	defer closeAll()
+
+	if conf.tracePath != "" {
+		closeOnExit(attachTracers(instances, conf.tracePath)...)
+	}
+	if conf.profilePath != "" {
+		closeOnExit(attachProfilers(instances, conf.profilePath)...)
+	}
+	if conf.coveragePath != "" {
+		closeOnExit(attachCoverage(instances, conf.coveragePath)...)
+	}
+	if conf.symbols != nil {
+		for _, inst := range instances {
+			inst.vm.SetSymbols(conf.symbols)
+		}
+	}
+	if conf.history {
+		for _, inst := range instances {
+			inst.vm.RecordHistory(true)
+		}
+	}
+	if conf.recompile {
+		for _, inst := range instances {
+			inst.vm.Recompile(true)
+		}
+	}
+	if len(conf.watchpoints) > 0 {
+		for _, inst := range instances {
+			inst.vm.TrackMemory(true)
+			for _, w := range conf.watchpoints {
+				inst.vm.AddWatchpoint(w)
 			}
 		}
-	}()
+		log.Infof("Watching %v", conf.watchpoints)
+	}
+
+	for i, inst := range instances {
+		if i == 0 && conf.gdbAddr != "" {
+			// gdb decides when it runs
+			go ServeGDB(newDebugger(inst.name, inst.vm), conf.gdbAddr)
+			continue
+		}
+		go runVM(inst.name, inst.vm)
+	}
+
+	if conf.serveAddr != "" {
+		// headless, the browser does the drawing
+		ServeVMs(instances, conf.serveAddr)
+		return
+	}
 
 	// Running display in the main go routine due to:
 	// https://stackoverflow.com/a/57474359/1180321
 	// Throws hard error when running on different routine
 	// on macOS
-	vm.InitDisplay(vm.keyboard)
+	InitDisplays(instances)
+}
+
+// InitVMs creates an independent vm for every rom and instance
+func InitVMs(vmConfig *VMConfig) []instance {
+
+	var instances []instance
+
+	db := loadRomDB(vmConfig.romDBPath)
+
+	if vmConfig.romDir != "" {
+		return initLauncherVMs(vmConfig, db)
+	}
+
+	for _, romFilePath := range vmConfig.romFilePaths {
+		var rom []byte
+		reloadPath := romFilePath
+		if vmConfig.builtin {
+			// no file to reload it from
+			rom, reloadPath = builtinRom(romFilePath), ""
+		} else {
+			rom = LoadRomFile(romFilePath, vmConfig.romEntry)
+		}
+		log.Debugln("\n\n Rom file: ", rom)
+
+		settings := resolveRomSettings(rom, romFilePath, db, vmConfig)
+
+		for i := 0; i < vmConfig.instances; i++ {
+			config := settings.config
+			config.Seed = vmConfig.seed
+			if config.Seed != 0 {
+				config.Seed += int64(len(instances))
+			}
+
+			vm := chip8.New(config)
+			if err := vm.LoadROM(rom); err != nil {
+				log.Fatalf("Not able to load the rom %s: %v", romFilePath, err)
+			}
+
+			name := settings.title
+			if vmConfig.instances > 1 {
+				name = fmt.Sprintf("%s #%d", name, i+1)
+			}
+
+			instances = append(instances, instance{
+				name:    name,
+				vm:      vm,
+				palette: settings.palette,
+				keymap:  settings.keymap,
+
+				rom:         rom,
+				romFilePath: reloadPath,
+				romEntry:    vmConfig.romEntry})
+		}
+	}
+
+	return instances
+}
+
+// initLauncherVMs creates idle vms which wait on
+// the rom menu for something to run
+func initLauncherVMs(vmConfig *VMConfig, db chip8.RomDB) []instance {
+	entries, err := scanRomDir(vmConfig.romDir, db)
+	if err != nil {
+		log.Fatalf("Not able to open the rom directory: %v", err)
+	}
+
+	var instances []instance
+	for i := 0; i < vmConfig.instances; i++ {
+		config := chip8.Config{Seed: vmConfig.seed}
+		if config.Seed != 0 {
+			config.Seed += int64(i)
+		}
+
+		vm := chip8.New(config)
+		vm.SetPaused(true)
+
+		name := filepath.Base(vmConfig.romDir)
+		if vmConfig.instances > 1 {
+			name = fmt.Sprintf("%s #%d", name, i+1)
+		}
+
+		instances = append(instances, instance{
+			name:     name,
+			vm:       vm,
+			palette:  DefaultPalette,
+			launcher: newLauncher(entries, db, vmConfig)})
+	}
+
+	return instances
 }
 
 func parseConfig() VMConfig {
 	// Read romFilePath from cmd args
-	romFilePath := flag.String("rom", "", "Rom File to execute on the interpreter")
+	romFilePaths := flag.String("rom", "", "Rom File to execute on the interpreter, comma separate several to run them side by side. - reads stdin, .zip/.gz and hex dumps work too")
+	romDir := flag.String("rom-dir", "", "Directory of roms to pick from on an in-window menu, instead of -rom")
+	romEntry := flag.String("rom-entry", "", "File inside a zip archive to run, needed when it holds more than one")
+	instances := flag.Int("instances", 1, "Number of independent vms to run per rom")
+	ipf := flag.Int("ipf", chip8.DefaultInstructionsPerFrame, "Instructions executed per 60 Hz frame, i.e. the cpu speed")
+	romDBPath := flag.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	platform := flag.String("platform", "", "Target platform to take quirks from: chip-8, schip or xo-chip (overrides the rom database)")
+	quirks := flag.String("quirks", "", "Comma separated quirks to run with, e.g. shift,loadstore,jump,vfreset,clip or none (overrides -platform)")
+	palette := flag.String("palette", "", "Background and foreground colours, e.g. #000000,#33FF66 (overrides the rom database)")
+	seed := flag.Int64("seed", 0, "Seed for the random number generator, 0 picks one at random")
+	tracePath := flag.String("trace", "", "Record a binary trace of every executed instruction to this file, see the trace command")
+	profilePath := flag.String("profile", "", "Profile the rom, writing a pprof profile (e.g. rom.pb.gz) on exit")
+	coveragePath := flag.String("coverage", "", "Record which instructions run, merged into this file on exit, see the coverage command")
+	watch := flag.String("watch", "", "Pause when memory is accessed: comma separated kinds:address, e.g. w:3A0,rx:300-30F (r read, w write, x execute)")
+	symbolsPath := flag.String("symbols", "", "Symbol file (addr name lines, or Octo style) labelling addresses in debug output, faults and call stacks")
+	history := flag.Bool("history", false, "Record execution history, so Shift+N steps a paused vm back a frame")
+	recompile := flag.Bool("recompile", false, "Run roms on the block recompiler instead of interpreting every instruction")
+	gdbAddr := flag.String("gdb", "", "Wait for gdb on this address (e.g. localhost:1234) to debug the first vm")
+	serveAddr := flag.String("serve", "", "Serve the emulator to browsers on this address (e.g. :8080) instead of opening a window")
 	flag.Parse()
 
-	if *romFilePath == "" {
-		log.Fatal("Rom file path missing..")
+	builtin := false
+	if *romFilePaths == "" && *romDir == "" {
+		// a binary with roms compiled in runs those
+		var names []string
+		for _, p := range chip8.NativePrograms() {
+			names = append(names, p.Name)
+		}
+		if len(names) == 0 {
+			log.Fatal("Rom file path missing..")
+		}
+		*romFilePaths = strings.Join(names, ",")
+		builtin = true
+	}
+
+	if *romDir != "" && *serveAddr != "" {
+		log.Fatal("The -rom-dir menu needs a window, it can't be used with -serve")
 	}
 
-	log.Info("Provided rom filepath: %s", *romFilePath)
+	if *romDir != "" && *gdbAddr != "" {
+		log.Fatal("gdb debugs a single rom, use -rom rather than -rom-dir")
+	}
+
+	if *romDir != "" && *coveragePath != "" {
+		log.Fatal("Coverage is kept per rom, use -rom rather than -rom-dir")
+	}
+
+	if *instances < 1 {
+		log.Fatal("Need at least one instance..")
+	}
+
+	// only an explicit -ipf wins over the rom database
+	ipfSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "ipf" {
+			ipfSet = true
+		}
+	})
+	if !ipfSet {
+		*ipf = 0
+	}
+
+	log.Infof("Provided rom filepath: %s", *romFilePaths)
 	conf := VMConfig{
-		romFilePath: *romFilePath}
+		romFilePaths: strings.Split(*romFilePaths, ","),
+		romEntry:     *romEntry,
+		builtin:      builtin,
+		romDir:       *romDir,
+		instances:    *instances,
+		seed:         *seed,
+		serveAddr:    *serveAddr,
+		tracePath:    *tracePath,
+		profilePath:  *profilePath,
+		coveragePath: *coveragePath,
+		gdbAddr:      *gdbAddr,
+		history:      *history,
+		recompile:    *recompile,
+		romDBPath:    *romDBPath,
+
+		instructionsPerFrame: *ipf}
+
+	if *platform != "" {
+		if _, ok := chip8.PlatformQuirks(*platform); !ok {
+			log.Fatalf("Unknown platform %q", *platform)
+		}
+		conf.platform = *platform
+	}
+
+	if *quirks != "" {
+		q, err := chip8.ParseQuirks(*quirks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.quirks = &q
+	}
+
+	if *symbolsPath != "" {
+		s, err := loadSymbols(*symbolsPath)
+		if err != nil {
+			log.Fatalf("Not able to load symbols: %v", err)
+		}
+		log.Infof("Loaded %d symbols from %s", s.Len(), *symbolsPath)
+		conf.symbols = s
+	}
+
+	if *watch != "" {
+		for _, spec := range strings.Split(*watch, ",") {
+			w, err := chip8.ParseWatchpoint(spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			conf.watchpoints = append(conf.watchpoints, w)
+		}
+	}
+
+	if *palette != "" {
+		p, err := parsePalette(strings.Split(*palette, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		conf.palette = &p
+	}
 
 	return conf
 }
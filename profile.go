@@ -0,0 +1,54 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// profileFile writes an instance's profile out on exit
+type profileFile struct {
+	name string
+	path string
+	p    *chip8.Profiler
+}
+
+func (pf *profileFile) Close() error {
+	f, err := os.Create(pf.path)
+	if err != nil {
+		return err
+	}
+
+	if err := pf.p.WriteProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	log.Infof("Profile of %s: %s", pf.name, pf.p)
+	log.Infof("Wrote the profile to %s, see go tool pprof", pf.path)
+	return f.Close()
+}
+
+// attachProfilers profiles every instance, writing them
+// to path (numbered when there are several) on exit
+func attachProfilers(instances []instance, path string) []io.Closer {
+	var closers []io.Closer
+
+	for i, inst := range instances {
+		p := chip8.NewProfiler(inst.romFilePath)
+		inst.vm.AddTracer(p)
+
+		closers = append(closers, &profileFile{
+			name: inst.name,
+			path: instanceOutputPath(path, i, len(instances)),
+			p:    p})
+	}
+
+	return closers
+}
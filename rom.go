@@ -0,0 +1,384 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Roms can be given as
+//   - a plain binary file
+//   - "-" to read from stdin
+//   - a .gz file, or a .zip archive (pick the entry with -rom-entry
+//     unless the archive holds a single file)
+//   - an Intel HEX file or a plain hex text dump ("00 E0 A2 2A ...")
+// Archives and text formats are recognised by their contents,
+// falling back to the file extension.
+
+// Largest rom that fits the program area (0x200-0xFFF)
+const MaxRomSize = chip8.ProgramAreaEnd - chip8.ProgramAreaStart + 1
+
+// Most a compressed rom may unpack to. Hex text takes a few
+// times the size of the rom, so this is well over MaxRomSize,
+// which is checked once the rom is decoded.
+const MaxUnpackedSize = 1 << 20
+
+// LoadRomFile reads and validates a rom, bailing out on any error
+func LoadRomFile(romFilePath, entry string) []byte {
+	rom, err := ReadRom(romFilePath, entry)
+	if err != nil {
+		log.Fatalf("Not able to load the rom file: %v", err)
+	}
+
+	log.Infoln("Successfully read rom file")
+	return rom
+}
+
+// ReadRom reads the rom at romFilePath in whatever format
+// it comes in, entry names the file to use inside a zip archive
+func ReadRom(romFilePath, entry string) ([]byte, error) {
+	var data []byte
+	var err error
+
+	if romFilePath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(romFilePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rom, err := decodeRom(romFilePath, data, entry)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", romFilePath, err)
+	}
+
+	if err := validateRom(rom); err != nil {
+		return nil, fmt.Errorf("%s: %w", romFilePath, err)
+	}
+
+	return rom, nil
+}
+
+// decodeRom unpacks data into the raw rom bytes
+func decodeRom(name string, data []byte, entry string) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	switch {
+	case bytes.HasPrefix(data, []byte{0x1f, 0x8b}):
+		return gunzipRom(name, data)
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")) || ext == ".zip":
+		return unzipRom(data, entry)
+	case ext == ".gz":
+		return gunzipRom(name, data)
+	}
+
+	return decodeText(name, data)
+}
+
+// decodeText reads a rom which may be in one of the text
+// formats. Archives inside archives aren't unpacked.
+func decodeText(name string, data []byte) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+
+	switch {
+	case ext == ".ch8" || ext == ".c8":
+		// explicitly binary, even if it happens to read as text
+		return data, nil
+	case isIntelHex(data):
+		return parseIntelHex(data)
+	case isHexText(data):
+		return parseHexText(data)
+	}
+
+	return data, nil
+}
+
+// validateRom rejects roms the vm can't run and
+// warns about ones which look off
+func validateRom(rom []byte) error {
+	if len(rom) == 0 {
+		return chip8.ErrRomEmpty
+	}
+
+	if len(rom) > MaxRomSize {
+		return fmt.Errorf("%w: rom is %d bytes, the program area only holds %d", chip8.ErrRomTooLarge, len(rom), MaxRomSize)
+	}
+
+	if len(rom)%2 != 0 {
+		log.Warnf("Rom is an odd %d bytes long, it may be truncated or not a CHIP-8 rom", len(rom))
+	}
+
+	return nil
+}
+
+// unpack reads a decompressed rom, up to MaxUnpackedSize
+func unpack(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxUnpackedSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > MaxUnpackedSize {
+		return nil, fmt.Errorf("%w: unpacks to over %d bytes", chip8.ErrRomTooLarge, MaxUnpackedSize)
+	}
+
+	return data, nil
+}
+
+func gunzipRom(name string, data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading gzip: %w", err)
+	}
+	defer r.Close()
+
+	rom, err := unpack(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading gzip: %w", err)
+	}
+
+	// rom.hex.gz holds hex text
+	if strings.EqualFold(filepath.Ext(name), ".gz") {
+		name = name[:len(name)-len(".gz")]
+	}
+	return decodeText(name, rom)
+}
+
+func unzipRom(data []byte, entry string) ([]byte, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading zip: %w", err)
+	}
+
+	var files []*zip.File
+	for _, f := range r.File {
+		if !f.FileInfo().IsDir() {
+			files = append(files, f)
+		}
+	}
+
+	var chosen *zip.File
+	switch {
+	case entry != "":
+		for _, f := range files {
+			if f.Name == entry || filepath.Base(f.Name) == entry {
+				chosen = f
+				break
+			}
+		}
+		if chosen == nil {
+			return nil, fmt.Errorf("no entry %q in the archive, it has: %s", entry, zipNames(files))
+		}
+	case len(files) == 1:
+		chosen = files[0]
+	case len(files) == 0:
+		return nil, errors.New("archive is empty")
+	default:
+		return nil, fmt.Errorf("archive holds several files, pick one with -rom-entry: %s", zipNames(files))
+	}
+
+	rc, err := chosen.Open()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s from zip: %w", chosen.Name, err)
+	}
+	defer rc.Close()
+
+	rom, err := unpack(rc)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s from zip: %w", chosen.Name, err)
+	}
+
+	// entries may be in any of the text formats
+	return decodeText(chosen.Name, rom)
+}
+
+func zipNames(files []*zip.File) string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// isIntelHex checks whether every non blank line is a ':' record
+func isIntelHex(data []byte) bool {
+	sawRecord := false
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if line[0] != ':' {
+			return false
+		}
+		sawRecord = true
+	}
+
+	return sawRecord
+}
+
+// parseIntelHex assembles the data records of an Intel HEX file.
+// Addresses at or past 0x200 are taken as ram addresses, lower
+// ones as offsets into the rom.
+func parseIntelHex(data []byte) ([]byte, error) {
+	var records []hexRecord
+	base := 0
+	lowest := -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		raw, err := hex.DecodeString(line[1:])
+		if err != nil || len(raw) < 5 || len(raw) != int(raw[0])+5 {
+			return nil, fmt.Errorf("intel hex line %d: malformed record", lineNo)
+		}
+
+		var sum byte
+		for _, b := range raw {
+			sum += b
+		}
+		if sum != 0 {
+			return nil, fmt.Errorf("intel hex line %d: bad checksum", lineNo)
+		}
+
+		count := int(raw[0])
+		addr := int(raw[1])<<8 | int(raw[2])
+		payload := raw[4 : 4+count]
+
+		switch raw[3] {
+		case 0x00: // data
+			addr += base
+			records = append(records, hexRecord{addr: addr, data: payload})
+			if lowest < 0 || addr < lowest {
+				lowest = addr
+			}
+		case 0x01: // end of file
+			return assembleHexRecords(records, lowest)
+		case 0x02: // extended segment address
+			if count != 2 {
+				return nil, fmt.Errorf("intel hex line %d: malformed segment address", lineNo)
+			}
+			base = (int(payload[0])<<8 | int(payload[1])) << 4
+		case 0x04: // extended linear address
+			if count != 2 {
+				return nil, fmt.Errorf("intel hex line %d: malformed linear address", lineNo)
+			}
+			base = (int(payload[0])<<8 | int(payload[1])) << 16
+		case 0x03, 0x05:
+			// start address records mean nothing here
+		default:
+			return nil, fmt.Errorf("intel hex line %d: unknown record type %02X", lineNo, raw[3])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("intel hex: missing end of file record")
+}
+
+// hexRecord is one data record of an Intel HEX file
+type hexRecord struct {
+	addr int
+	data []byte
+}
+
+// assembleHexRecords lays the records out into one rom image,
+// gaps between records are zero filled
+func assembleHexRecords(records []hexRecord, lowest int) ([]byte, error) {
+	if len(records) == 0 {
+		return nil, errors.New("intel hex holds no data")
+	}
+
+	origin := 0
+	if lowest >= chip8.ProgramAreaStart {
+		origin = chip8.ProgramAreaStart
+	}
+
+	var rom []byte
+	for _, r := range records {
+		start := r.addr - origin
+		end := start + len(r.data)
+		if end > MaxRomSize {
+			return nil, fmt.Errorf("intel hex data at %04X lies past the end of ram", r.addr)
+		}
+		if end > len(rom) {
+			rom = append(rom, make([]byte, end-len(rom))...)
+		}
+		copy(rom[start:end], r.data)
+	}
+
+	return rom, nil
+}
+
+// isHexText checks whether data is nothing but hex
+// byte pairs, separators and comments
+func isHexText(data []byte) bool {
+	_, err := parseHexText(data)
+	return err == nil
+}
+
+// parseHexText reads a plain hex dump like "00E0 A22A" or
+// "0x00, 0xE0". Comments start with '#', ';' or "//" and
+// an "0200:" style address column is skipped.
+func parseHexText(data []byte) ([]byte, error) {
+	var rom []byte
+
+	for lineNo, line := range strings.Split(string(data), "\n") {
+		for _, marker := range []string{"#", ";", "//"} {
+			if i := strings.Index(line, marker); i >= 0 {
+				line = line[:i]
+			}
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == '\r' || r == ','
+		})
+
+		for i, field := range fields {
+			if i == 0 && strings.HasSuffix(field, ":") {
+				continue
+			}
+
+			field = strings.TrimPrefix(strings.TrimPrefix(field, "0x"), "0X")
+			if len(field) == 0 || len(field)%2 != 0 {
+				return nil, fmt.Errorf("hex text line %d: %q is not whole bytes", lineNo+1, field)
+			}
+
+			for j := 0; j < len(field); j += 2 {
+				b, err := strconv.ParseUint(field[j:j+2], 16, 8)
+				if err != nil {
+					return nil, fmt.Errorf("hex text line %d: %q is not hex", lineNo+1, field)
+				}
+				rom = append(rom, byte(b))
+			}
+		}
+	}
+
+	if len(rom) == 0 {
+		return nil, errors.New("hex text holds no bytes")
+	}
+
+	return rom, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"chip8-emulator/chip8"
+)
+
+func TestDecodeRom(t *testing.T) {
+	tests := []struct {
+		name, file, data string
+		want             []byte
+		wantErr          bool
+	}{
+		// hex text
+		{"hex pairs", "rom", "00E0 A22A\n", []byte{0x00, 0xE0, 0xA2, 0x2A}, false},
+		{"hex 0x and commas", "rom.txt", "0x00, 0xE0, // cls\n0x12, 0x00 ; loop", []byte{0x00, 0xE0, 0x12, 0x00}, false},
+		{"hex address column", "rom.hex", "0200: 00E0\n0202: 1200 # loop\n", []byte{0x00, 0xE0, 0x12, 0x00}, false},
+		{"hex odd byte count", "rom", "00E0 A2", []byte{0x00, 0xE0, 0xA2}, false},
+		// half a byte doesn't read as hex text, so it's taken as binary
+		{"hex odd digits", "rom", "0E0", []byte("0E0"), false},
+
+		// Intel HEX
+		{"ihex offsets", "rom.ihx", ":0400000000E0A22A50\n:00000001FF\n", []byte{0x00, 0xE0, 0xA2, 0x2A}, false},
+		{"ihex ram addresses with gap", "rom", ":0202000000E01C\n:020204001200E6\n:00000001FF\n", []byte{0x00, 0xE0, 0x00, 0x00, 0x12, 0x00}, false},
+		{"ihex segment address", "rom", ":020000020020DC\n:0200000000E01E\n:00000001FF\n", []byte{0x00, 0xE0}, false},
+		{"ihex linear address", "rom", ":020000040000FA\n:0202000000E01C\n:00000001FF\n", []byte{0x00, 0xE0}, false},
+		{"ihex past ram", "rom", ":020000040001F9\n:0202000000E01C\n:00000001FF\n", nil, true},
+		{"ihex bad checksum", "rom", ":0400000000E0A22A51\n:00000001FF\n", nil, true},
+		{"ihex short record", "rom", ":0400000000E0\n:00000001FF\n", nil, true},
+		{"ihex odd digits", "rom", ":0400000000E0A22A5\n:00000001FF\n", nil, true},
+		{"ihex no end", "rom", ":0400000000E0A22A50\n", nil, true},
+
+		// the extension says binary, whatever the bytes look like
+		{"ch8 forced binary", "rom.ch8", "00E0", []byte("00E0"), false},
+		{"c8 forced binary", "ROM.C8", ":00000001FF\n", []byte(":00000001FF\n"), false},
+		{"binary", "rom", "\x00\xE0\x12\x00", []byte{0x00, 0xE0, 0x12, 0x00}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeRom(tt.file, []byte(tt.data), "")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got % X, want % X", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseHexText(t *testing.T) {
+	tests := []struct {
+		name, data string
+		wantErr    bool
+	}{
+		{"bytes", "00 E0", false},
+		{"odd digits", "00 E", true},
+		{"not hex", "00 EG", true},
+		{"only comments", "# nothing\n; here", true},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseHexText([]byte(tt.data)); (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+// hexDump writes rom as hex text, three bytes of text per rom byte
+func hexDump(rom []byte) string {
+	var b strings.Builder
+	for i, c := range rom {
+		fmt.Fprintf(&b, "%02X", c)
+		if i%16 == 15 {
+			b.WriteByte('\n')
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+func gzipped(t *testing.T, data string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// zipped packs files, given as name then contents
+func zipped(t *testing.T, files ...string) string {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		f, err := w.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestReadRomArchives(t *testing.T) {
+	// a rom big enough that its hex dump is larger than the program area
+	big := make([]byte, 2000)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+	tooBig := string(make([]byte, MaxRomSize+1))
+
+	tests := []struct {
+		name, file, data, entry string
+		want                    []byte
+		wantErr                 error
+	}{
+		{"gzip binary", "rom.gz", gzipped(t, "\x00\xE0\x12\x00"), "", []byte{0x00, 0xE0, 0x12, 0x00}, nil},
+		{"gzip hex", "rom.hex.gz", gzipped(t, hexDump(big)), "", big, nil},
+		{"gzip oversize", "rom.gz", gzipped(t, tooBig), "", nil, chip8.ErrRomTooLarge},
+		{"gzip bomb", "rom.gz", gzipped(t, string(make([]byte, MaxUnpackedSize+1))), "", nil, chip8.ErrRomTooLarge},
+
+		{"zip binary", "roms.zip", zipped(t, "rom.ch8", "\x00\xE0\x12\x00"), "", []byte{0x00, 0xE0, 0x12, 0x00}, nil},
+		{"zip hex", "roms.zip", zipped(t, "rom.txt", hexDump(big)), "", big, nil},
+		{"zip entry", "roms.zip", zipped(t, "a.ch8", "\x00\xE0", "dir/b.ch8", "\x12\x00"), "b.ch8", []byte{0x12, 0x00}, nil},
+		{"zip oversize entry", "roms.zip", zipped(t, "a.ch8", "\x00\xE0", "b.ch8", tooBig), "b.ch8", nil, chip8.ErrRomTooLarge},
+	}
+
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.file)
+		if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ReadRom(path, tt.entry)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: got %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+
+	// several files and no entry picked
+	path := filepath.Join(dir, "several.zip")
+	if err := os.WriteFile(path, []byte(zipped(t, "a.ch8", "\x00\xE0", "b.ch8", "\x12\x00")), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadRom(path, ""); err == nil {
+		t.Error("several entries and none picked: got no error")
+	}
+}
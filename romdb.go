@@ -0,0 +1,155 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Palette is the background and foreground colour of the display
+type Palette [2]color.RGBA
+
+// DefaultPalette is the black and white the display has always had
+var DefaultPalette = Palette{Black, White}
+
+// romSettings is everything picked for a rom at startup,
+// from the rom database and the command line
+type romSettings struct {
+	title   string
+	config  chip8.Config
+	palette Palette
+	keymap  map[string]byte
+}
+
+// defaultRomDBPath is the user's local rom database,
+// e.g. ~/.config/chip8-emulator/romdb.json
+func defaultRomDBPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "chip8-emulator", "romdb.json")
+}
+
+// loadRomDB returns the built in rom database with the
+// local database file, if there is one, merged over it
+func loadRomDB(path string) chip8.RomDB {
+	db := chip8.DefaultRomDB()
+	if path == "" {
+		return db
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		log.Debugf("No local rom database at %s", path)
+		return db
+	}
+	if err != nil {
+		log.Fatalf("Not able to open the rom database: %v", err)
+	}
+	defer f.Close()
+
+	local, err := chip8.ReadRomDB(f)
+	if err != nil {
+		log.Fatalf("Not able to load %s: %v", path, err)
+	}
+
+	log.Infof("Loaded %d entries from the local rom database %s", len(local), path)
+	db.Merge(local)
+	return db
+}
+
+// resolveRomSettings looks the rom up in the database
+// and then applies any overrides given on the command line
+func resolveRomSettings(rom []byte, romFilePath string, db chip8.RomDB, vmConfig *VMConfig) romSettings {
+	settings := romSettings{
+		title:   filepath.Base(romFilePath),
+		palette: DefaultPalette,
+	}
+	if romFilePath == "-" {
+		settings.title = "stdin"
+	}
+
+	info, known := db.Lookup(rom)
+	if known {
+		log.Infof("Recognised rom %s: %q by %q for %s", romFilePath, info.Title, info.Author, info.Platform)
+		info.Apply(&settings.config)
+
+		if info.Title != "" {
+			settings.title = info.Title
+		}
+		settings.keymap = info.Keymap
+
+		if len(info.Palette) > 0 {
+			palette, err := parsePalette(info.Palette)
+			if err != nil {
+				log.Warnf("Ignoring rom database palette for %s: %v", romFilePath, err)
+			} else {
+				settings.palette = palette
+			}
+		}
+	} else {
+		log.Infof("Rom %s (sha1 %s) is not in the rom database", romFilePath, chip8.RomHash(rom))
+
+		// fall back to guessing from the opcodes used
+		det := chip8.DetectPlatform(rom)
+		if det.Platform != "" {
+			log.Infof("Rom %s looks like %s: %s", romFilePath, det.Platform, strings.Join(det.Reasons, "; "))
+			settings.config.Platform = det.Platform
+			settings.config.Quirks = det.Quirks
+		}
+		if det.Platform != "" && det.Platform != chip8.PlatformChip8 || det.Hires {
+			log.Warnf("Only the CHIP-8 instruction set and 64x32 display are emulated, %s may not run correctly", romFilePath)
+		}
+	}
+
+	// command line wins over the database
+	if vmConfig.platform != "" {
+		settings.config.Platform = vmConfig.platform
+		settings.config.Quirks, _ = chip8.PlatformQuirks(vmConfig.platform)
+	}
+	if vmConfig.quirks != nil {
+		settings.config.Quirks = *vmConfig.quirks
+	}
+	if vmConfig.instructionsPerFrame > 0 {
+		settings.config.InstructionsPerFrame = vmConfig.instructionsPerFrame
+	}
+	if vmConfig.palette != nil {
+		settings.palette = *vmConfig.palette
+	}
+
+	log.Infof("Running %s with quirks: %s", settings.title, settings.config.Quirks)
+	return settings
+}
+
+// parsePalette reads background and foreground colours
+// given as "#RRGGBB" (the # is optional)
+func parsePalette(colors []string) (Palette, error) {
+	var palette Palette
+
+	if len(colors) != 2 {
+		return palette, fmt.Errorf("want a background and a foreground colour, got %d colours", len(colors))
+	}
+
+	for i, c := range colors {
+		c = strings.TrimPrefix(strings.TrimSpace(c), "#")
+		rgb, err := strconv.ParseUint(c, 16, 32)
+		if err != nil || len(c) != 6 {
+			return palette, fmt.Errorf("bad colour %q, expected #RRGGBB", colors[i])
+		}
+
+		palette[i] = color.RGBA{R: byte(rgb >> 16), G: byte(rgb >> 8), B: byte(rgb), A: 255}
+	}
+
+	return palette, nil
+}
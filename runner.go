@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// runVM runs the vm on its own clock, leaving any other vm
+// in the process running. A program fault pauses the vm rather
+// than ending it, so another rom can still be loaded into it.
+func runVM(name string, vm *chip8.VM) {
+	for {
+		err := vm.Run(context.Background())
+		if err == nil || err == context.Canceled {
+			return
+		}
+
+		var hit *chip8.WatchpointHit
+		if errors.As(err, &hit) {
+			log.Warnf("%s paused on %v%s", name, hit, provenance(vm, hit))
+		} else {
+			log.Errorf("%s stopped: %v", name, err)
+			for _, frame := range strings.Split(vm.CallStack(), "\n") {
+				log.Errorf("    %s", frame)
+			}
+		}
+		vm.SetPaused(true)
+	}
+}
+
+// provenance notes who last wrote the byte a read or execute
+// watchpoint tripped on, when memory tracking knows
+func provenance(vm *chip8.VM, hit *chip8.WatchpointHit) string {
+	stats := vm.MemoryStats(hit.Addr)
+	if hit.Kind == chip8.AccessWrite || !stats.Written {
+		return ""
+	}
+	return fmt.Sprintf(", last written by the instruction at %03X", stats.LastWriter)
+}
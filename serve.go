@@ -0,0 +1,228 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// Web frontend for sharing a running vm over the LAN.
+// Browsers load a page which draws the framebuffer on a canvas,
+// receive frame diffs + machine state over a websocket
+// and send keypad events back on the same socket.
+
+const (
+	// Push frames to the browsers at the same 60 Hz as the timers
+	ServeFrameRate = time.Duration(16666) * time.Microsecond
+
+	// Size of the memory panel window, in bytes
+	ServeMemoryWindow = 256
+
+	// Memory panel is refreshed every Nth frame only
+	ServeMemoryEvery = 10
+)
+
+// memoryWindow is a hex encoded slice of ram starting at Addr
+type memoryWindow struct {
+	Addr int    `json:"addr"`
+	Data string `json:"data"`
+}
+
+// serverMessage is pushed to the browser every frame.
+// The very first one carries the whole framebuffer in Full,
+// every later one only the pixels which flipped since.
+type serverMessage struct {
+	Palette []string      `json:"palette,omitempty"`
+	Full    string        `json:"full,omitempty"`
+	Flip    []int         `json:"flip,omitempty"`
+	State   *chip8.State  `json:"state,omitempty"`
+	Memory  *memoryWindow `json:"memory,omitempty"`
+}
+
+// clientMessage is sent by the browser,
+// Type is either "key" or "memory"
+type clientMessage struct {
+	Type string `json:"type"`
+	Key  byte   `json:"key"`
+	Down bool   `json:"down"`
+	Addr int    `json:"addr"`
+}
+
+var upgrader = websocket.Upgrader{}
+
+// ServeVMs serves the web frontend for every instance on addr,
+// blocks until the http server fails.
+// Browsers pick an instance with ?vm=N on the page url.
+func ServeVMs(instances []instance, addr string) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, servePage)
+	})
+	mux.HandleFunc("/vms", func(w http.ResponseWriter, r *http.Request) {
+		names := make([]string, len(instances))
+		for i, inst := range instances {
+			names[i] = inst.name
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(names)
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		serveWebSocket(pickInstance(instances, r), w, r)
+	})
+	log.Infof("Serving the emulator on http://%s", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
+
+// pickInstance returns the instance picked with ?vm=N, the first by default
+func pickInstance(instances []instance, r *http.Request) instance {
+	i, err := strconv.Atoi(r.URL.Query().Get("vm"))
+	if err != nil || i < 0 || i >= len(instances) {
+		i = 0
+	}
+	return instances[i]
+}
+
+func serveWebSocket(inst instance, w http.ResponseWriter, r *http.Request) {
+	vm := inst.vm
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Infof("Unable to upgrade web client connection: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	log.Infof("Web client connected: %s", r.RemoteAddr)
+
+	// memory panel address, written by the reader below
+	memAddr := int32(chip8.ProgramAreaStart)
+
+	// gorilla allows one reader and one writer at a time,
+	// so reading happens here and all the writing below
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			var msg clientMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+
+			switch msg.Type {
+			case "key":
+				vm.SetKey(msg.Key, msg.Down)
+			case "memory":
+				atomic.StoreInt32(&memAddr, int32(clampMemoryWindow(msg.Addr)))
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(ServeFrameRate)
+	defer ticker.Stop()
+
+	var lastDisplay chip8.Framebuffer
+	lastMemAddr := -1
+
+	for frame := 0; ; frame++ {
+		select {
+		case <-done:
+			log.Infof("Web client disconnected: %s", r.RemoteAddr)
+			return
+		case <-ticker.C:
+		}
+
+		state, display := vm.State(), vm.Framebuffer()
+		msg := serverMessage{State: &state}
+
+		if frame == 0 {
+			msg.Palette = []string{hexColor(inst.palette[0]), hexColor(inst.palette[1])}
+			msg.Full = encodeDisplay(&display)
+		} else {
+			msg.Flip = diffDisplay(&lastDisplay, &display)
+		}
+		lastDisplay = display
+
+		addr := int(atomic.LoadInt32(&memAddr))
+		if addr != lastMemAddr || frame%ServeMemoryEvery == 0 {
+			msg.Memory = &memoryWindow{
+				Addr: addr,
+				Data: hex.EncodeToString(vm.ReadMemory(uint16(addr), ServeMemoryWindow))}
+			lastMemAddr = addr
+		}
+
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Infof("Web client %s went away: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// hexColor formats c as "#RRGGBB" for the page
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", c.R, c.G, c.B)
+}
+
+func clampMemoryWindow(addr int) int {
+	if addr < 0 {
+		return 0
+	}
+	if addr > chip8.RAMSize-ServeMemoryWindow {
+		return chip8.RAMSize - ServeMemoryWindow
+	}
+	return addr
+}
+
+// encodeDisplay flattens the display row by row into "0"/"1" chars
+func encodeDisplay(display *chip8.Framebuffer) string {
+	var sb strings.Builder
+	sb.Grow(chip8.EmuHeight * chip8.EmuWidth)
+
+	for y := 0; y < chip8.EmuHeight; y++ {
+		for x := 0; x < chip8.EmuWidth; x++ {
+			if display[y][x] == 0 {
+				sb.WriteByte('0')
+			} else {
+				sb.WriteByte('1')
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+// diffDisplay returns the flattened (y*width + x) index
+// of every pixel that differs between the two displays
+func diffDisplay(prev, curr *chip8.Framebuffer) []int {
+	var flipped []int
+
+	for y := 0; y < chip8.EmuHeight; y++ {
+		for x := 0; x < chip8.EmuWidth; x++ {
+			if prev[y][x] != curr[y][x] {
+				flipped = append(flipped, y*chip8.EmuWidth+x)
+			}
+		}
+	}
+
+	return flipped
+}
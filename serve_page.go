@@ -0,0 +1,158 @@
+//go:build !js
+// +build !js
+
+package main
+
+// servePage is the single page served by the web frontend,
+// kept inline so the binary stays self contained.
+// Keyboard layout matches the desktop window: keys 0-9 and A-F
+// map straight onto the chip-8 keypad.
+const servePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Chip-8 VM</title>
+<style>
+  body { background: #111; color: #ddd; font-family: monospace; margin: 16px; }
+  #main { display: flex; gap: 16px; align-items: flex-start; }
+  canvas { background: #000; image-rendering: pixelated; border: 1px solid #444; }
+  .panel { background: #1b1b1b; border: 1px solid #333; padding: 8px; }
+  #keypad { display: grid; grid-template-columns: repeat(4, 48px); gap: 4px; margin-top: 8px; }
+  #keypad button { height: 48px; font: inherit; font-size: 18px; background: #2a2a2a; color: #ddd; border: 1px solid #444; }
+  #keypad button.down { background: #5a5a5a; }
+  #regs td { padding: 0 6px; }
+  #memory { white-space: pre; }
+  #status { margin-bottom: 8px; }
+</style>
+</head>
+<body>
+<div id="status">connecting...</div>
+<div id="vms"></div>
+<div id="main">
+  <div>
+    <canvas id="screen" width="640" height="320"></canvas>
+    <div id="keypad"></div>
+  </div>
+  <div class="panel">
+    <div>Registers</div>
+    <table id="regs"></table>
+    <div>Stack</div>
+    <div id="stack"></div>
+  </div>
+  <div class="panel">
+    <div>Memory <input id="memaddr" size="5" value="200"> (hex)</div>
+    <div id="memory"></div>
+  </div>
+</div>
+<script>
+const W = 64, H = 32, SCALE = 10;
+const KEYPAD = [0x1, 0x2, 0x3, 0xC, 0x4, 0x5, 0x6, 0xD, 0x7, 0x8, 0x9, 0xE, 0xA, 0x0, 0xB, 0xF];
+
+const canvas = document.getElementById("screen");
+const ctx = canvas.getContext("2d");
+const pixels = new Uint8Array(W * H);
+let palette = ["#000000", "#FFFFFF"];
+
+const hex = (n, width) => n.toString(16).toUpperCase().padStart(width, "0");
+
+const vm = new URLSearchParams(location.search).get("vm") || "0";
+
+fetch("/vms").then((r) => r.json()).then((names) => {
+  if (names.length < 2) return;
+  document.getElementById("vms").innerHTML = names.map((name, i) =>
+    (String(i) === vm ? "<b>" + name + "</b>" : "<a href=\"?vm=" + i + "\">" + name + "</a>")).join(" | ");
+});
+
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/ws?vm=" + vm);
+ws.onopen = () => document.getElementById("status").textContent = "connected to " + location.host;
+ws.onclose = () => document.getElementById("status").textContent = "disconnected";
+
+ws.onmessage = (e) => {
+  const msg = JSON.parse(e.data);
+  if (msg.palette) palette = msg.palette;
+  if (msg.full) {
+    for (let i = 0; i < msg.full.length; i++) pixels[i] = msg.full.charCodeAt(i) === 49 ? 1 : 0;
+  }
+  if (msg.flip) {
+    for (const i of msg.flip) pixels[i] ^= 1;
+  }
+  if (msg.full || msg.flip) draw();
+  if (msg.state) showState(msg.state);
+  if (msg.memory) showMemory(msg.memory);
+};
+
+function draw() {
+  ctx.fillStyle = palette[0];
+  ctx.fillRect(0, 0, canvas.width, canvas.height);
+  ctx.fillStyle = palette[1];
+  for (let y = 0; y < H; y++) {
+    for (let x = 0; x < W; x++) {
+      if (pixels[y * W + x]) ctx.fillRect(x * SCALE, y * SCALE, SCALE, SCALE);
+    }
+  }
+}
+
+function showState(s) {
+  let rows = "";
+  for (let i = 0; i < 16; i += 4) {
+    rows += "<tr>";
+    for (let j = i; j < i + 4; j++) rows += "<td>V" + hex(j, 1) + " " + hex(s.v[j], 2) + "</td>";
+    rows += "</tr>";
+  }
+  rows += "<tr><td>I " + hex(s.i, 3) + "</td><td>PC " + hex(s.pc, 3) + "</td><td>SP " + hex(s.sp, 1) + "</td></tr>";
+  rows += "<tr><td>DT " + hex(s.dt, 2) + "</td><td>ST " + hex(s.st, 2) + "</td></tr>";
+  document.getElementById("regs").innerHTML = rows;
+  document.getElementById("stack").textContent = (s.stack || []).map((a) => hex(a, 3)).join(" ") || "-";
+}
+
+function showMemory(m) {
+  let out = "";
+  for (let row = 0; row < m.data.length; row += 32) {
+    out += hex(m.addr + row / 2, 3) + ": " + m.data.slice(row, row + 32).replace(/(..)/g, "$1 ").toUpperCase() + "\n";
+  }
+  document.getElementById("memory").textContent = out;
+}
+
+document.getElementById("memaddr").addEventListener("change", (e) => {
+  const addr = parseInt(e.target.value, 16);
+  if (!isNaN(addr)) ws.send(JSON.stringify({ type: "memory", addr: addr }));
+});
+
+function sendKey(key, down) {
+  if (ws.readyState !== WebSocket.OPEN) return;
+  ws.send(JSON.stringify({ type: "key", key: key, down: down }));
+  const button = document.getElementById("key" + key);
+  if (button) button.classList.toggle("down", down);
+}
+
+const keypad = document.getElementById("keypad");
+for (const key of KEYPAD) {
+  const button = document.createElement("button");
+  button.id = "key" + key;
+  button.textContent = hex(key, 1);
+  button.addEventListener("pointerdown", () => sendKey(key, true));
+  button.addEventListener("pointerup", () => sendKey(key, false));
+  button.addEventListener("pointerleave", () => sendKey(key, false));
+  keypad.appendChild(button);
+}
+
+function keyOf(e) {
+  if (e.target.tagName === "INPUT" || e.key.length !== 1) return -1;
+  const key = parseInt(e.key, 16);
+  return isNaN(key) ? -1 : key;
+}
+
+document.addEventListener("keydown", (e) => {
+  const key = keyOf(e);
+  if (key >= 0 && !e.repeat) sendKey(key, true);
+});
+document.addEventListener("keyup", (e) => {
+  const key = keyOf(e);
+  if (key >= 0) sendKey(key, false);
+});
+
+draw();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,183 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"chip8-emulator/chip8"
+)
+
+// sprite sheet layout, in pixels of the png
+const (
+	sheetColumns = 8
+	sheetPadding = 8
+)
+
+// spriteIndex is the json written next to a sprite sheet
+type spriteIndex struct {
+	Rom     string        `json:"rom"`
+	RomHash string        `json:"rom_sha1"`
+	Sheet   string        `json:"sheet"`
+	Sprites []spriteEntry `json:"sprites"`
+}
+
+// spriteEntry is a sprite and where the sheet shows it
+type spriteEntry struct {
+	Addr    uint16   `json:"addr"`
+	Label   string   `json:"label,omitempty"`
+	Width   int      `json:"width"`
+	Height  int      `json:"height"`
+	DrawnAt []uint16 `json:"drawn_at"`
+
+	// where the sheet shows the sprite's pixels
+	X int `json:"x"`
+	Y int `json:"y"`
+
+	rows []byte
+}
+
+// spritesCommand writes the sprites a rom draws as a png
+// sprite sheet and a json index of them
+func spritesCommand(args []string) {
+	fs := flag.NewFlagSet("sprites", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to take the sprites of")
+	symbolsPath := fs.String("symbols", "", "Symbol file naming the sprites")
+	outPath := fs.String("o", "", "Sprite sheet png to write, the rom's name with .sprites.png by default")
+	indexPath := fs.String("json", "", "Index to write, the sheet's name with .json by default")
+	scale := fs.Int("scale", 4, "Size of a sprite pixel on the sheet")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator sprites [flags] rom")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || *scale < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	romFilePath := fs.Arg(0)
+
+	sheetPath := *outPath
+	if sheetPath == "" {
+		sheetPath = strings.TrimSuffix(filepath.Base(romFilePath), filepath.Ext(romFilePath)) + ".sprites.png"
+	}
+	if *indexPath == "" {
+		*indexPath = strings.TrimSuffix(sheetPath, filepath.Ext(sheetPath)) + ".json"
+	}
+
+	a, symbols := analyzeRom(romFilePath, *romEntry, *romDBPath, *symbolsPath)
+	index := spriteIndex{
+		Rom:     filepath.Base(romFilePath),
+		RomHash: chip8.RomHash(a.Rom),
+		Sheet:   filepath.Base(sheetPath),
+	}
+	for _, ref := range a.Sprites {
+		rows, ok := a.SpriteBytes(ref)
+		if !ok {
+			log.Warnf("Skipping the sprite at %03X, the rom builds it in memory", ref.Addr)
+			continue
+		}
+		index.Sprites = append(index.Sprites, spriteEntry{
+			Addr:    ref.Addr,
+			Label:   symbols.Label(ref.Addr),
+			Width:   8,
+			Height:  ref.Height,
+			DrawnAt: ref.DrawnAt,
+			rows:    rows,
+		})
+	}
+	if len(index.Sprites) == 0 {
+		log.Fatalf("No sprites found in %s", romFilePath)
+	}
+
+	settings := resolveRomSettings(a.Rom, romFilePath, loadRomDB(*romDBPath), &VMConfig{})
+	sheet := drawSpriteSheet(index.Sprites, settings.palette, *scale, symbols)
+
+	writeFile(sheetPath, func(w io.Writer) error { return png.Encode(w, sheet) })
+	writeFile(*indexPath, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		return enc.Encode(index)
+	})
+	fmt.Printf("Wrote %d sprites to %s and %s\n", len(index.Sprites), sheetPath, *indexPath)
+}
+
+// drawSpriteSheet lays the sprites out in a grid, each under
+// its address (or label) and size, and fills in their X and Y
+func drawSpriteSheet(sprites []spriteEntry, palette Palette, scale int, symbols *chip8.Symbols) *image.RGBA {
+	face := basicfont.Face7x13
+	labels := make([][2]string, len(sprites))
+
+	// every cell fits the widest label and the tallest sprite
+	cellWidth, spriteHeight := 8*scale, 0
+	for i, s := range sprites {
+		labels[i] = [2]string{symbols.Describe(s.Addr), fmt.Sprintf("%dx%d", s.Width, s.Height)}
+		for _, text := range labels[i] {
+			if w := font.MeasureString(face, text).Ceil(); w > cellWidth {
+				cellWidth = w
+			}
+		}
+		if h := s.Height * scale; h > spriteHeight {
+			spriteHeight = h
+		}
+	}
+	labelHeight := 2 * face.Height
+	cellHeight := labelHeight + 2 + spriteHeight
+
+	columns := sheetColumns
+	if len(sprites) < columns {
+		columns = len(sprites)
+	}
+	rows := (len(sprites) + columns - 1) / columns
+	sheet := image.NewRGBA(image.Rect(0, 0,
+		sheetPadding+columns*(cellWidth+sheetPadding),
+		sheetPadding+rows*(cellHeight+sheetPadding)))
+
+	// the palette's colours, opaque
+	bg, fg := palette[0], palette[1]
+	bg.A, fg.A = 255, 255
+	text := color.RGBA{R: 128, G: 128, B: 128, A: 255}
+	draw.Draw(sheet, sheet.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+
+	for i := range sprites {
+		s := &sprites[i]
+		x := sheetPadding + (i%columns)*(cellWidth+sheetPadding)
+		y := sheetPadding + (i/columns)*(cellHeight+sheetPadding)
+
+		d := font.Drawer{Dst: sheet, Src: image.NewUniform(text), Face: face}
+		for line, label := range labels[i] {
+			d.Dot = fixed.P(x, y+line*face.Height+face.Ascent)
+			d.DrawString(label)
+		}
+
+		s.X, s.Y = x, y+labelHeight+2
+		for row, bits := range s.rows {
+			for col := 0; col < s.Width; col++ {
+				if bits&(0x80>>col) == 0 {
+					continue
+				}
+				px := image.Rect(0, 0, scale, scale).Add(image.Pt(s.X+col*scale, s.Y+row*scale))
+				draw.Draw(sheet, px, image.NewUniform(fg), image.Point{}, draw.Src)
+			}
+		}
+	}
+	return sheet
+}
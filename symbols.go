@@ -0,0 +1,26 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"chip8-emulator/chip8"
+)
+
+// loadSymbols reads a symbol file, see chip8.ReadSymbols
+func loadSymbols(path string) (*chip8.Symbols, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := chip8.ReadSymbols(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
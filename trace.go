@@ -0,0 +1,317 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// traceFile records one instance's execution trace to disk
+type traceFile struct {
+	f *os.File
+	w *chip8.TraceWriter
+}
+
+func (t *traceFile) Close() error {
+	err := t.w.Flush()
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// attachTracers records every instance to path, numbering
+// the files (trace.1.bin, trace.2.bin, ...) when there are several
+func attachTracers(instances []instance, path string) []io.Closer {
+	var closers []io.Closer
+
+	for i, inst := range instances {
+		name := instanceOutputPath(path, i, len(instances))
+
+		f, err := os.Create(name)
+		if err != nil {
+			log.Fatalf("Not able to create the trace file: %v", err)
+		}
+
+		w, err := chip8.NewTraceWriter(f)
+		if err != nil {
+			log.Fatalf("Not able to write the trace file: %v", err)
+		}
+
+		inst.vm.AddTracer(w)
+		closers = append(closers, &traceFile{f: f, w: w})
+		log.Infof("Tracing %s to %s", inst.name, name)
+	}
+
+	return closers
+}
+
+// instanceOutputPath numbers path for the ith of n
+// instances (out.1.bin, out.2.bin, ...) when there are several
+func instanceOutputPath(path string, i, n int) string {
+	if n == 1 {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	if strings.HasSuffix(path, ".pb.gz") {
+		ext = ".pb.gz"
+	}
+	return fmt.Sprintf("%s.%d%s", strings.TrimSuffix(path, ext), i+1, ext)
+}
+
+// traceFilter picks records out of a trace, every set field must match
+type traceFilter struct {
+	// register (0-15 for Vx, 16 for I) which changed, to regValue if set
+	reg      int
+	regValue int
+
+	writeFrom, writeTo int
+	frameFrom, frameTo int64
+	pc                 int
+	ops                map[string]bool
+}
+
+func (f *traceFilter) match(rec *chip8.TraceRecord) bool {
+	if f.frameFrom >= 0 && int64(rec.Frame) < f.frameFrom {
+		return false
+	}
+	if f.frameTo >= 0 && int64(rec.Frame) > f.frameTo {
+		return false
+	}
+	if f.pc >= 0 && int(rec.PC) != f.pc {
+		return false
+	}
+	if f.ops != nil && !f.ops[chip8.Decode(rec.Opcode).Mnemonic] {
+		return false
+	}
+
+	if f.reg >= 0 {
+		var changed bool
+		var value int
+		if f.reg == 16 {
+			changed, value = rec.Changed&chip8.TraceChangedI != 0, int(rec.Regs.I)
+		} else {
+			changed, value = rec.RegisterChanged(uint8(f.reg)), int(rec.Regs.V[f.reg])
+		}
+		if !changed || f.regValue >= 0 && value != f.regValue {
+			return false
+		}
+	}
+
+	if f.writeFrom >= 0 {
+		hit := false
+		for _, w := range rec.Writes {
+			if int(w.Addr) >= f.writeFrom && int(w.Addr) <= f.writeTo {
+				hit = true
+				break
+			}
+		}
+		if !hit {
+			return false
+		}
+	}
+
+	return true
+}
+
+// traceCommand filters a trace recorded with -trace
+func traceCommand(args []string) {
+	fs := flag.NewFlagSet("trace", flag.ExitOnError)
+	reg := fs.String("reg", "", "Instructions which changed a register, optionally to a value: V3, V3=7, I=0x2A0")
+	write := fs.String("write", "", "Instructions which wrote to an address or range: 0x3A0 or 0x3A0-0x3AF")
+	frames := fs.String("frames", "", "Only frames in this range: 100-200, or a single frame")
+	pc := fs.String("pc", "", "Only the instruction at this address")
+	op := fs.String("op", "", "Only these instruction classes, comma separated: DRW,CALL")
+	last := fs.Bool("last", false, "Print only the last match, e.g. when a register last changed")
+	limit := fs.Int("limit", 0, "Stop after this many matches, 0 for all")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator trace [flags] trace.bin")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	filter, err := parseTraceFilter(*reg, *write, *frames, *pc, *op)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	r, err := chip8.NewTraceReader(f)
+	if err != nil {
+		log.Fatalf("%s: %v", fs.Arg(0), err)
+	}
+
+	matches := 0
+	var lastLine string
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatalf("%s: %v", fs.Arg(0), err)
+		}
+
+		if !filter.match(rec) {
+			continue
+		}
+
+		matches++
+		if *last {
+			lastLine = formatTraceRecord(rec)
+			continue
+		}
+
+		fmt.Println(formatTraceRecord(rec))
+		if *limit > 0 && matches >= *limit {
+			break
+		}
+	}
+
+	if lastLine != "" {
+		fmt.Println(lastLine)
+	}
+	if matches == 0 {
+		fmt.Println("no matching instructions")
+	}
+}
+
+func parseTraceFilter(reg, write, frames, pc, op string) (*traceFilter, error) {
+	f := &traceFilter{reg: -1, regValue: -1, writeFrom: -1, frameFrom: -1, frameTo: -1, pc: -1}
+
+	if reg != "" {
+		name, value := reg, ""
+		if i := strings.Index(reg, "="); i >= 0 {
+			name, value = reg[:i], reg[i+1:]
+		}
+
+		name = strings.ToUpper(name)
+		switch {
+		case name == "I":
+			f.reg = 16
+		case len(name) == 2 && name[0] == 'V':
+			x, err := strconv.ParseUint(name[1:], 16, 4)
+			if err != nil {
+				return nil, fmt.Errorf("bad register %q", name)
+			}
+			f.reg = int(x)
+		default:
+			return nil, fmt.Errorf("bad register %q, want V0-VF or I", name)
+		}
+
+		if value != "" {
+			v, err := strconv.ParseUint(value, 0, 16)
+			if err != nil {
+				return nil, fmt.Errorf("bad register value %q", value)
+			}
+			f.regValue = int(v)
+		}
+	}
+
+	if write != "" {
+		from, to, err := parseRange(write, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad -write: %v", err)
+		}
+		f.writeFrom, f.writeTo = int(from), int(to)
+	}
+
+	if frames != "" {
+		from, to, err := parseRange(frames, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad -frames: %v", err)
+		}
+		f.frameFrom, f.frameTo = int64(from), int64(to)
+	}
+
+	if pc != "" {
+		addr, err := strconv.ParseUint(pc, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("bad -pc: %v", err)
+		}
+		f.pc = int(addr)
+	}
+
+	if op != "" {
+		f.ops = map[string]bool{}
+		for _, name := range strings.Split(op, ",") {
+			f.ops[strings.ToUpper(strings.TrimSpace(name))] = true
+		}
+	}
+
+	return f, nil
+}
+
+// parseRange reads "from-to" or a single value
+func parseRange(s string, base, bits int) (uint64, uint64, error) {
+	parts := strings.SplitN(s, "-", 2)
+
+	from, err := strconv.ParseUint(parts[0], base, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(parts) == 1 {
+		return from, from, nil
+	}
+
+	to, err := strconv.ParseUint(parts[1], base, bits)
+	if err != nil {
+		return 0, 0, err
+	}
+	if to < from {
+		return 0, 0, fmt.Errorf("%s is backwards", s)
+	}
+	return from, to, nil
+}
+
+// formatTraceRecord prints one instruction with what it changed
+func formatTraceRecord(rec *chip8.TraceRecord) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "#%-8d frame %-6d %03X  %04X  %-18s", rec.Index, rec.Frame, rec.PC, rec.Opcode, chip8.Disassemble(rec.Opcode))
+
+	for x := uint8(0); x < 16; x++ {
+		if rec.RegisterChanged(x) {
+			fmt.Fprintf(&sb, " V%X=%02X", x, rec.Regs.V[x])
+		}
+	}
+	if rec.Changed&chip8.TraceChangedI != 0 {
+		fmt.Fprintf(&sb, " I=%03X", rec.Regs.I)
+	}
+	if rec.Changed&chip8.TraceChangedSP != 0 {
+		fmt.Fprintf(&sb, " SP=%X", rec.Regs.SP)
+	}
+	if rec.Changed&chip8.TraceChangedDT != 0 {
+		fmt.Fprintf(&sb, " DT=%02X", rec.Regs.DT)
+	}
+	if rec.Changed&chip8.TraceChangedST != 0 {
+		fmt.Fprintf(&sb, " ST=%02X", rec.Regs.ST)
+	}
+	for _, w := range rec.Writes {
+		fmt.Fprintf(&sb, " [%03X]=%02X", w.Addr, w.Value)
+	}
+
+	return strings.TrimRight(sb.String(), " ")
+}
@@ -0,0 +1,96 @@
+//go:build !js
+// +build !js
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// transpileCommand turns a rom into a Go file which, built into
+// the emulator, runs the rom compiled ahead of time
+func transpileCommand(args []string) {
+	fs := flag.NewFlagSet("transpile", flag.ExitOnError)
+	romDBPath := fs.String("romdb", defaultRomDBPath(), "Local rom database file, merged over the built in one")
+	romEntry := fs.String("rom-entry", "", "File inside a zip archive to transpile")
+	symbolsPath := fs.String("symbols", "", "Symbol file labelling the generated code")
+	outPath := fs.String("o", "", "Go file to write, the rom's name with _rom.go by default")
+	pkg := fs.String("package", "main", "Package of the generated code")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: chip8-emulator transpile [flags] rom")
+		fmt.Fprintln(fs.Output(), "Put the file next to main.go and go build for an emulator running the rom compiled.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	romFilePath := fs.Arg(0)
+
+	ident := goIdent(strings.TrimSuffix(filepath.Base(romFilePath), filepath.Ext(romFilePath)))
+	if *outPath == "" {
+		*outPath = ident + "_rom.go"
+	}
+
+	a, symbols := analyzeRom(romFilePath, *romEntry, *romDBPath, *symbolsPath)
+	if len(a.Unresolved) > 0 {
+		log.Warnf("%d computed jumps weren't resolved, their targets will be interpreted", len(a.Unresolved))
+	}
+	writeFile(*outPath, func(w io.Writer) error {
+		return a.Transpile(w, *pkg, filepath.Base(romFilePath), ident, symbols)
+	})
+	fmt.Printf("Wrote %s\n", *outPath)
+}
+
+// goIdent makes an unexported Go identifier out of name,
+// e.g. "Space Invaders [David Winter]" gives spaceInvadersDavidWinter
+func goIdent(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range name {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if b.Len() == 0 {
+				if unicode.IsDigit(r) {
+					b.WriteString("rom")
+					upper = false
+				} else {
+					r = unicode.ToLower(r)
+				}
+			} else if upper {
+				r = unicode.ToUpper(r)
+			}
+			b.WriteRune(r)
+			upper = false
+		default:
+			upper = true
+		}
+	}
+	if b.Len() == 0 {
+		return "rom"
+	}
+	return b.String()
+}
+
+// builtinRom returns the rom of the native program called name
+func builtinRom(name string) []byte {
+	for _, p := range chip8.NativePrograms() {
+		if p.Name == name {
+			return p.Rom
+		}
+	}
+	log.Fatalf("No rom %s compiled in", name)
+	return nil
+}
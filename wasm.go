@@ -0,0 +1,120 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"strconv"
+	"syscall/js"
+
+	log "github.com/sirupsen/logrus"
+
+	"chip8-emulator/chip8"
+)
+
+// WebAssembly frontend, built with
+//   GOOS=js GOARCH=wasm go build -o web/wasm/chip8.wasm .
+// web/wasm/index.html hosts it: the page owns the canvas and the
+// file picker, everything else (input, drawing) is wired up from here.
+
+func main() {
+	setupLogging()
+
+	log.Info("Booting up CHIP-8 (wasm)...")
+
+	vm := chip8.New(chip8.Config{})
+	document := js.Global().Get("document")
+
+	canvas := document.Call("getElementById", "screen")
+	ctx := canvas.Call("getContext", "2d")
+	imageData := ctx.Call("createImageData", chip8.EmuWidth, chip8.EmuHeight)
+	pixels := make([]byte, chip8.EmuWidth*chip8.EmuHeight*4)
+
+	running := false
+
+	// chip8LoadROM(Uint8Array) is called by the page's file picker
+	js.Global().Set("chip8LoadROM", js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		rom := make([]byte, args[0].Get("length").Int())
+		js.CopyBytesToGo(rom, args[0])
+
+		log.Infof("Loading rom from the page, %d bytes", len(rom))
+		if err := vm.LoadROM(rom); err != nil {
+			log.Errorf("Not able to load the rom: %v", err)
+			return nil
+		}
+		// a fault paused the old rom, the new one should run
+		vm.SetPaused(false)
+
+		if !running {
+			running = true
+			go runVM("chip8", vm)
+		}
+		return nil
+	}))
+
+	onKey := func(down bool) js.Func {
+		return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+			chip8Key, ok := jsKeyToChip8(args[0])
+			if !ok || args[0].Get("repeat").Bool() {
+				return nil
+			}
+
+			vm.SetKey(chip8Key, down)
+			return nil
+		})
+	}
+	document.Call("addEventListener", "keydown", onKey(true))
+	document.Call("addEventListener", "keyup", onKey(false))
+
+	// draw the display once per animation frame, the vm
+	// keeps running on its own tickers in the meantime
+	var renderFrame js.Func
+	renderFrame = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		display := vm.Framebuffer()
+		displayToRGBA(&display, pixels)
+
+		js.CopyBytesToJS(imageData.Get("data"), pixels)
+		ctx.Call("putImageData", imageData, 0, 0)
+
+		js.Global().Call("requestAnimationFrame", renderFrame)
+		return nil
+	})
+	js.Global().Call("requestAnimationFrame", renderFrame)
+
+	// keep the go side alive for the callbacks
+	select {}
+}
+
+// jsKeyToChip8 maps a KeyboardEvent onto the keypad, the same
+// way the desktop window does: keys 0-9 and A-F
+func jsKeyToChip8(event js.Value) (byte, bool) {
+	k := event.Get("key").String()
+	if len(k) != 1 {
+		return 0, false
+	}
+
+	val, err := strconv.ParseUint(k, 16, 8)
+	if err != nil {
+		return 0, false
+	}
+
+	return byte(val), true
+}
+
+// displayToRGBA flattens the display into canvas ImageData layout
+func displayToRGBA(display *chip8.Framebuffer, pixels []byte) {
+	for j := 0; j < chip8.EmuHeight; j++ {
+		for i := 0; i < chip8.EmuWidth; i++ {
+			col := Black
+			if display[j][i] != 0 {
+				col = White
+			}
+
+			offset := (j*chip8.EmuWidth + i) * 4
+			pixels[offset] = col.R
+			pixels[offset+1] = col.G
+			pixels[offset+2] = col.B
+			pixels[offset+3] = 255
+		}
+	}
+}